@@ -0,0 +1,366 @@
+package soratun
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const (
+	// AclActionAllow allows matched packets.
+	AclActionAllow = "allow"
+	// AclActionDeny drops matched packets.
+	AclActionDeny = "deny"
+
+	// aclMaxFlows is the maximum number of tracked connections. New connections are still evaluated by rules but
+	// replies to them will not be tracked while the table is full.
+	aclMaxFlows = 65536
+	// aclSweepInterval is an interval to remove expired connections.
+	aclSweepInterval = time.Minute
+)
+
+// connection tracking timeouts by protocol, since the last packet seen.
+var aclFlowTimeouts = map[uint8]time.Duration{
+	protocolTCP:    2 * time.Hour,
+	protocolUDP:    2 * time.Minute,
+	protocolICMP:   30 * time.Second,
+	protocolICMPv6: 30 * time.Second,
+}
+
+// aclDefaultFlowTimeout is a connection tracking timeout for other protocols.
+const aclDefaultFlowTimeout = time.Minute
+
+// aclFragmentTimeout is a timeout to wait for following fragments of an allowed packet, which is the reassembly
+// timeout of Linux.
+const aclFragmentTimeout = 30 * time.Second
+
+// AclConfig holds a stateful packet filter for traffic through the tunnel. Rules are evaluated in order and the first
+// matched rule wins. Replies to allowed connections are always allowed regardless of rules. Fragments of a packet
+// follow the first fragment, which has the ports, or the default action if the first fragment hasn't been allowed.
+type AclConfig struct {
+	// Inbound holds rules for packets from SORACOM Arc to the device.
+	Inbound []*AclRule `json:"inbound,omitempty"`
+	// DefaultInbound is the action for inbound packets which no rule matched. Defaults to "deny".
+	DefaultInbound string `json:"defaultInbound,omitempty"`
+	// Outbound holds rules for packets from the device to SORACOM Arc.
+	Outbound []*AclRule `json:"outbound,omitempty"`
+	// DefaultOutbound is the action for outbound packets which no rule matched. Defaults to "allow".
+	DefaultOutbound string `json:"defaultOutbound,omitempty"`
+}
+
+// AclRule matches packets. Empty fields match any packet.
+type AclRule struct {
+	// Action is "allow" or "deny".
+	Action string `json:"action"`
+	// Source is a CIDR of source address.
+	Source *IPNet `json:"source,omitempty"`
+	// Destination is a CIDR of destination address.
+	Destination *IPNet `json:"destination,omitempty"`
+	// Protocol is one of "tcp", "udp", or "icmp".
+	Protocol string `json:"protocol,omitempty"`
+	// DestinationPort is a TCP or UDP destination port "22", or port range "8000-8080".
+	DestinationPort string `json:"destinationPort,omitempty"`
+}
+
+// AclStats holds hit counts of rules by direction. Keys are rule index, "default", or "established" for replies to
+// tracked connections and following fragments of allowed packets.
+type AclStats struct {
+	Inbound  map[string]uint64 `json:"inbound"`
+	Outbound map[string]uint64 `json:"outbound"`
+}
+
+// Validate returns an error if the configuration has unknown actions, protocols, or invalid ports.
+func (c *AclConfig) Validate() error {
+	_, err := compileAcl(c)
+	return err
+}
+
+type aclRule struct {
+	allow       bool
+	source      *net.IPNet
+	destination *net.IPNet
+	protocol    string
+	portFrom    uint16
+	portTo      uint16
+	hits        atomic.Uint64
+}
+
+type aclChain struct {
+	rules       []*aclRule
+	allow       bool
+	defaultHits atomic.Uint64
+	replyHits   atomic.Uint64
+}
+
+type flowKey struct {
+	protocol uint8
+	src      [16]byte
+	dst      [16]byte
+	srcPort  uint16
+	dstPort  uint16
+}
+
+// acl evaluates packets with rules and tracks connections.
+type acl struct {
+	inbound  *aclChain
+	outbound *aclChain
+
+	mu        sync.Mutex
+	flows     map[flowKey]time.Time
+	fragments map[fragmentKey]time.Time // allowed packets whose following fragments are expected
+	lastSweep time.Time
+}
+
+func compileAcl(c *AclConfig) (*acl, error) {
+	inbound, err := compileAclChain("inbound", c.Inbound, c.DefaultInbound, AclActionDeny)
+	if err != nil {
+		return nil, err
+	}
+	outbound, err := compileAclChain("outbound", c.Outbound, c.DefaultOutbound, AclActionAllow)
+	if err != nil {
+		return nil, err
+	}
+	return &acl{
+		inbound:   inbound,
+		outbound:  outbound,
+		flows:     map[flowKey]time.Time{},
+		fragments: map[fragmentKey]time.Time{},
+		lastSweep: time.Now(),
+	}, nil
+}
+
+func compileAclChain(direction string, rules []*AclRule, defaultAction, fallback string) (*aclChain, error) {
+	if defaultAction == "" {
+		defaultAction = fallback
+	}
+	allow, err := parseAclAction(defaultAction)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default %s action: %w", direction, err)
+	}
+
+	chain := &aclChain{allow: allow}
+	for i, r := range rules {
+		rule := &aclRule{
+			source:      (*net.IPNet)(r.Source),
+			destination: (*net.IPNet)(r.Destination),
+			protocol:    r.Protocol,
+		}
+		if rule.allow, err = parseAclAction(r.Action); err != nil {
+			return nil, fmt.Errorf("invalid %s rule %d: %w", direction, i, err)
+		}
+		switch r.Protocol {
+		case "", "tcp", "udp", "icmp":
+		default:
+			return nil, fmt.Errorf("invalid %s rule %d: unknown protocol \"%s\", it should be one of tcp, udp, or icmp", direction, i, r.Protocol)
+		}
+		if r.DestinationPort != "" {
+			if rule.portFrom, rule.portTo, err = parsePortRange(r.DestinationPort); err != nil {
+				return nil, fmt.Errorf("invalid %s rule %d: %w", direction, i, err)
+			}
+		}
+		chain.rules = append(chain.rules, rule)
+	}
+	return chain, nil
+}
+
+func parseAclAction(action string) (bool, error) {
+	switch action {
+	case AclActionAllow:
+		return true, nil
+	case AclActionDeny:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown action \"%s\", it should be allow or deny", action)
+	}
+}
+
+func parsePortRange(s string) (uint16, uint16, error) {
+	from, to, found := strings.Cut(s, "-")
+	if !found {
+		to = from
+	}
+	f, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port \"%s\"", s)
+	}
+	t, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+	if err != nil || t < f {
+		return 0, 0, fmt.Errorf("invalid port range \"%s\"", s)
+	}
+	return uint16(f), uint16(t), nil
+}
+
+func (r *aclRule) match(p *packetInfo) bool {
+	if r.source != nil && !r.source.Contains(p.src) {
+		return false
+	}
+	if r.destination != nil && !r.destination.Contains(p.dst) {
+		return false
+	}
+	if r.protocol != "" && r.protocol != protocolName(p.protocol) {
+		return false
+	}
+	if r.portTo != 0 {
+		if p.protocol != protocolTCP && p.protocol != protocolUDP {
+			return false
+		}
+		if p.dstPort < r.portFrom || p.dstPort > r.portTo {
+			return false
+		}
+	}
+	return true
+}
+
+func newFlowKey(p *packetInfo) flowKey {
+	k := flowKey{protocol: p.protocol, srcPort: p.srcPort, dstPort: p.dstPort}
+	copy(k.src[:], p.src.To16())
+	copy(k.dst[:], p.dst.To16())
+	return k
+}
+
+// fragmentKey identifies fragments of a packet.
+type fragmentKey struct {
+	protocol uint8
+	src      [16]byte
+	dst      [16]byte
+	id       uint32
+}
+
+func newFragmentKey(p *packetInfo) fragmentKey {
+	k := fragmentKey{protocol: p.protocol, id: p.fragmentId}
+	copy(k.src[:], p.src.To16())
+	copy(k.dst[:], p.dst.To16())
+	return k
+}
+
+func (k flowKey) reverse() flowKey {
+	return flowKey{protocol: k.protocol, src: k.dst, dst: k.src, srcPort: k.dstPort, dstPort: k.srcPort}
+}
+
+// allow returns true if the packet should pass, and tracks the connection if so.
+func (a *acl) allow(chain *aclChain, packet []byte) bool {
+	p, ok := parsePacket(packet)
+	if !ok {
+		chain.defaultHits.Add(1)
+		return chain.allow
+	}
+
+	key := newFlowKey(p)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Sub(a.lastSweep) > aclSweepInterval {
+		a.sweep(now)
+	}
+
+	if p.fragmentOffset != 0 {
+		// following fragments have no ports, so they are allowed only if the first fragment was
+		if expiry, ok := a.fragments[newFragmentKey(p)]; ok && now.Before(expiry) {
+			chain.replyHits.Add(1)
+			return true
+		}
+		chain.defaultHits.Add(1)
+		return chain.allow
+	}
+
+	if expiry, ok := a.flows[key.reverse()]; ok && now.Before(expiry) {
+		chain.replyHits.Add(1)
+		a.track(key.reverse(), p.protocol, now)
+		a.trackFragments(p, now)
+		return true
+	}
+
+	allowed := chain.allow
+	matched := false
+	for _, r := range chain.rules {
+		if r.match(p) {
+			r.hits.Add(1)
+			allowed, matched = r.allow, true
+			break
+		}
+	}
+	if !matched {
+		chain.defaultHits.Add(1)
+	}
+
+	if allowed {
+		a.track(key, p.protocol, now)
+		a.trackFragments(p, now)
+	}
+	return allowed
+}
+
+// trackFragments records the allowed packet to allow its following fragments, if it is the first fragment. The caller
+// must hold a.mu.
+func (a *acl) trackFragments(p *packetInfo, now time.Time) {
+	if !p.fragmented || len(a.fragments) >= aclMaxFlows {
+		return
+	}
+	a.fragments[newFragmentKey(p)] = now.Add(aclFragmentTimeout)
+}
+
+// track records or refreshes the connection. The caller must hold a.mu.
+func (a *acl) track(key flowKey, protocol uint8, now time.Time) {
+	if _, ok := a.flows[key]; !ok && len(a.flows) >= aclMaxFlows {
+		return
+	}
+	timeout, ok := aclFlowTimeouts[protocol]
+	if !ok {
+		timeout = aclDefaultFlowTimeout
+	}
+	a.flows[key] = now.Add(timeout)
+}
+
+// sweep removes expired connections. The caller must hold a.mu.
+func (a *acl) sweep(now time.Time) {
+	for k, expiry := range a.flows {
+		if now.After(expiry) {
+			delete(a.flows, k)
+		}
+	}
+	for k, expiry := range a.fragments {
+		if now.After(expiry) {
+			delete(a.fragments, k)
+		}
+	}
+	a.lastSweep = now
+}
+
+func (a *acl) stats() *AclStats {
+	return &AclStats{
+		Inbound:  a.inbound.stats(),
+		Outbound: a.outbound.stats(),
+	}
+}
+
+func (c *aclChain) stats() map[string]uint64 {
+	s := map[string]uint64{
+		"default":     c.defaultHits.Load(),
+		"established": c.replyHits.Load(),
+	}
+	for i, r := range c.rules {
+		s[strconv.Itoa(i)] = r.hits.Load()
+	}
+	return s
+}
+
+// tun wraps t to drop packets which rules deny.
+func (a *acl) tun(t tun.Device) tun.Device {
+	return &filterTun{
+		Device: t,
+		outbound: func(packet []byte) bool {
+			return a.allow(a.outbound, packet)
+		},
+		inbound: func(packet []byte) bool {
+			return a.allow(a.inbound, packet)
+		},
+	}
+}
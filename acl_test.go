@@ -0,0 +1,115 @@
+package soratun
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_acl(t *testing.T) {
+	_, arc, _ := net.ParseCIDR("100.127.0.0/16")
+	a, err := compileAcl(&AclConfig{
+		Inbound: []*AclRule{
+			{Action: AclActionDeny, Source: (*IPNet)(arc), Protocol: "tcp", DestinationPort: "23"},
+			{Action: AclActionAllow, Source: (*IPNet)(arc), Protocol: "tcp", DestinationPort: "20-25"},
+		},
+	})
+	assert.NoError(t, err)
+
+	// inbound SSH from SORACOM Arc is allowed by the rule, telnet is denied
+	assert.True(t, a.allow(a.inbound, ipv4Packet(protocolTCP, "100.127.0.1", "10.0.0.1", 40000, 22)))
+	assert.False(t, a.allow(a.inbound, ipv4Packet(protocolTCP, "100.127.0.1", "10.0.0.1", 40000, 23)))
+	// inbound HTTP is denied by default
+	assert.False(t, a.allow(a.inbound, ipv4Packet(protocolTCP, "100.127.0.1", "10.0.0.1", 40000, 80)))
+	// outbound reply to the allowed SSH connection
+	assert.True(t, a.allow(a.outbound, ipv4Packet(protocolTCP, "10.0.0.1", "100.127.0.1", 22, 40000)))
+
+	// outbound DNS query is allowed by default, and its reply is allowed as an established connection
+	assert.True(t, a.allow(a.outbound, ipv4Packet(protocolUDP, "10.0.0.1", "100.127.0.53", 50000, 53)))
+	assert.True(t, a.allow(a.inbound, ipv4Packet(protocolUDP, "100.127.0.53", "10.0.0.1", 53, 50000)))
+	// but other inbound packets from the same host are not
+	assert.False(t, a.allow(a.inbound, ipv4Packet(protocolUDP, "100.127.0.53", "10.0.0.1", 53, 50001)))
+
+	stats := a.stats()
+	assert.EqualValues(t, 1, stats.Inbound["0"])
+	assert.EqualValues(t, 1, stats.Inbound["1"])
+	assert.EqualValues(t, 2, stats.Inbound["default"])
+	assert.EqualValues(t, 1, stats.Inbound["established"])
+	assert.EqualValues(t, 1, stats.Outbound["default"])
+	assert.EqualValues(t, 1, stats.Outbound["established"])
+}
+
+// fragment returns a fragment of the IPv4 packet with the ID, and fragment offset in 8 bytes. Following fragments
+// have no transport header.
+func fragment(packet []byte, id uint16, offset uint16, more bool) []byte {
+	b := append([]byte{}, packet...)
+	binary.BigEndian.PutUint16(b[4:6], id)
+	flags := offset
+	if more {
+		flags |= 0x2000
+	}
+	binary.BigEndian.PutUint16(b[6:8], flags)
+	if offset != 0 {
+		for i := 20; i < len(b); i++ {
+			b[i] = 0
+		}
+	}
+	return b
+}
+
+func Test_acl_fragments(t *testing.T) {
+	_, arc, _ := net.ParseCIDR("100.127.0.0/16")
+	a, err := compileAcl(&AclConfig{
+		Inbound: []*AclRule{
+			{Action: AclActionDeny, Source: (*IPNet)(arc), Protocol: "udp", DestinationPort: "5000"},
+			{Action: AclActionAllow, Source: (*IPNet)(arc), Protocol: "udp"},
+		},
+	})
+	assert.NoError(t, err)
+
+	// a large DNS reply to an outbound query is fragmented
+	assert.True(t, a.allow(a.outbound, ipv4Packet(protocolUDP, "10.0.0.1", "100.127.0.53", 50000, 53)))
+	reply := ipv4Packet(protocolUDP, "100.127.0.53", "10.0.0.1", 53, 50000)
+	assert.True(t, a.allow(a.inbound, fragment(reply, 1, 0, true)))
+	assert.True(t, a.allow(a.inbound, fragment(reply, 1, 185, true)))
+	assert.True(t, a.allow(a.inbound, fragment(reply, 1, 370, false)))
+
+	// fragments of another packet without the first fragment are denied by default
+	assert.False(t, a.allow(a.inbound, fragment(reply, 2, 185, false)))
+
+	// following fragments of a packet denied by a port rule are denied too, though they have no port
+	denied := ipv4Packet(protocolUDP, "100.127.0.1", "10.0.0.1", 40000, 5000)
+	assert.False(t, a.allow(a.inbound, fragment(denied, 3, 0, true)))
+	assert.False(t, a.allow(a.inbound, fragment(denied, 3, 185, false)))
+
+	// IPv6 fragment header has 32-bit ID
+	p, ok := parsePacket(ipv6Fragment(7, 185))
+	assert.True(t, ok)
+	assert.True(t, p.fragmented)
+	assert.EqualValues(t, 7, p.fragmentId)
+	assert.EqualValues(t, 185, p.fragmentOffset)
+	assert.EqualValues(t, protocolUDP, p.protocol)
+}
+
+// ipv6Fragment returns a following fragment of an IPv6 UDP packet.
+func ipv6Fragment(id uint32, offset uint16) []byte {
+	b := make([]byte, 56)
+	b[0] = 0x60
+	b[6] = 44
+	copy(b[8:24], net.ParseIP("fd00::1"))
+	copy(b[24:40], net.ParseIP("fd00::2"))
+	b[40] = protocolUDP
+	binary.BigEndian.PutUint16(b[42:44], offset<<3)
+	binary.BigEndian.PutUint32(b[44:48], id)
+	return b
+}
+
+func Test_AclConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&AclConfig{DefaultInbound: AclActionAllow}).Validate())
+	assert.Error(t, (&AclConfig{DefaultOutbound: "reject"}).Validate())
+	assert.Error(t, (&AclConfig{Inbound: []*AclRule{{Action: AclActionAllow, Protocol: "sctp"}}}).Validate())
+	assert.Error(t, (&AclConfig{Inbound: []*AclRule{{Action: AclActionAllow, DestinationPort: "25-20"}}}).Validate())
+	assert.Error(t, (&AclConfig{Outbound: []*AclRule{{Action: AclActionAllow, DestinationPort: "70000"}}}).Validate())
+}
@@ -0,0 +1,167 @@
+package soratun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// steps of AuthKeyBootstrapper recorded in BootstrapJournal.
+const (
+	// BootstrapStepCreateVirtualSim is creating a virtual SIM. If it was interrupted, the SIM may or may not exist.
+	BootstrapStepCreateVirtualSim = "createVirtualSim"
+	// BootstrapStepSetSimTags is setting name and tags of the created SIM.
+	BootstrapStepSetSimTags = "setSimTags"
+	// BootstrapStepSetSimGroup is attaching the created SIM to the group.
+	BootstrapStepSetSimGroup = "setSimGroup"
+	// BootstrapStepCreateArcSession is creating Arc session of the created SIM with the key pair in the journal.
+	BootstrapStepCreateArcSession = "createArcSession"
+	// BootstrapStepSaveConfig is saving the configuration file.
+	BootstrapStepSaveConfig = "saveConfig"
+)
+
+// BootstrapJournal records a bootstrap in progress, which is written before each API call. If the bootstrap fails
+// after a virtual SIM is created, the SIM is billed but not configured, so AuthKeyBootstrapper.Resume finishes the
+// configuration from the journal instead of creating another SIM.
+type BootstrapJournal struct {
+	// Step is the step which was started last.
+	Step string `json:"step"`
+	// StartedAt is the time when the bootstrap started.
+	StartedAt time.Time `json:"startedAt"`
+	// Profile is used to create the SIM, without AuthKey which is never written to the journal. The auth key has to be
+	// given again to resume the bootstrap.
+	Profile *Profile `json:"profile"`
+	// SimOptions are applied to the created SIM.
+	SimOptions *SimOptions `json:"simOptions,omitempty"`
+	// Sim is the SIM returned from SORACOM API, once it is created.
+	Sim *VirtualSim `json:"sim,omitempty"`
+	// SimResponse is the raw response of creating the SIM with private keys redacted, if it couldn't be decoded into
+	// Sim. The SIM ID is recovered from it to resume the bootstrap.
+	SimResponse string `json:"simResponse,omitempty"`
+	// PrivateKey and PublicKey are the key pair generated on the device for Arc session.
+	PrivateKey *Key `json:"privateKey,omitempty"`
+	PublicKey  *Key `json:"publicKey,omitempty"`
+	// Error is the error which stopped the bootstrap, if any.
+	Error string `json:"error,omitempty"`
+
+	path string
+}
+
+// BootstrapJournalPath returns the journal path for the configuration file.
+func BootstrapJournalPath(configPath string) string {
+	return configPath + ".bootstrap.json"
+}
+
+// ReadBootstrapJournal reads the journal, or returns an error which satisfies errors.Is(err, os.ErrNotExist) if no
+// bootstrap is in progress.
+func ReadBootstrapJournal(path string) (*BootstrapJournal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var j BootstrapJournal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("invalid bootstrap journal %s: %w", path, err)
+	}
+	j.path = path
+	return &j, nil
+}
+
+// RemoveBootstrapJournal removes the journal after the configuration is saved. It is not an error if the journal
+// does not exist.
+func RemoveBootstrapJournal(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// journalProfile returns a copy of profile without AuthKey to be written to the journal.
+func journalProfile(profile *Profile) *Profile {
+	if profile == nil {
+		return nil
+	}
+	p := *profile
+	p.AuthKey = ""
+	return &p
+}
+
+// privateKeyPattern and simIdPattern match fields in a SIM response which may not be valid JSON, e.g. truncated.
+var (
+	privateKeyPattern = regexp.MustCompile(`(?i)("[a-z]*privatekey"\s*:\s*)"[^"]*"?`)
+	simIdPattern      = regexp.MustCompile(`"simId"\s*:\s*"([^"]+)"`)
+)
+
+// journalSimResponse returns the raw SIM response b with private keys redacted, to be written to the journal.
+func journalSimResponse(b []byte) string {
+	if json.Valid(b) {
+		return string(redactBody(b))
+	}
+	return privateKeyPattern.ReplaceAllString(string(b), `${1}"`+redacted+`"`)
+}
+
+// simId returns ID of the created SIM, from Sim or SimResponse, or empty if it is unknown.
+func (j *BootstrapJournal) simId() string {
+	if j.Sim != nil {
+		return j.Sim.SimId
+	}
+	if m := simIdPattern.FindStringSubmatch(j.SimResponse); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// begin records that step is starting. A nil journal records nothing.
+func (j *BootstrapJournal) begin(step string) error {
+	if j == nil {
+		return nil
+	}
+	j.Step = step
+	j.Error = ""
+	if err := j.write(); err != nil {
+		return fmt.Errorf("failed to write bootstrap journal: %w", err)
+	}
+	return nil
+}
+
+// fail records err which stopped the current step. A nil journal records nothing.
+func (j *BootstrapJournal) fail(err error) {
+	if j == nil {
+		return
+	}
+	j.Error = err.Error()
+	_ = j.write()
+}
+
+// write writes the journal atomically with 0600, since it has the private key of the device.
+func (j *BootstrapJournal) write() error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), j.path)
+}
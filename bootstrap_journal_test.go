@@ -0,0 +1,268 @@
+package soratun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AuthKeyBootstrapper_Resume(t *testing.T) {
+	var sims, sessions atomic.Int32
+	var registered string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth":
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+		case "/v1/sims":
+			sims.Add(1)
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"active"}`)
+		case "/v1/sims/8942310022000000000/enable_termination", "/v1/sims/8942310022000000000/terminate":
+			t.Errorf("SIM should be kept on a transient failure, but %s is requested", r.URL.Path)
+		case "/v1/sims/8942310022000000000/sessions/arc":
+			if sessions.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var body struct {
+				ArcClientPeerPublicKey string `json:"arcClientPeerPublicKey"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			registered = body.ArcClientPeerPublicKey
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010","arcClientPeerIpAddress":"10.0.0.1"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "arc.json.bootstrap.json")
+	b := &AuthKeyBootstrapper{
+		Profile:     &Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL},
+		Retry:       &RetryConfig{MaxAttempts: 1},
+		JournalPath: path,
+	}
+
+	_, err := b.Execute(context.Background(), nil)
+	assert.ErrorContains(t, err, "soratun bootstrap resume")
+
+	journal, err := ReadBootstrapJournal(path)
+	assert.NoError(t, err)
+	assert.Equal(t, BootstrapStepCreateArcSession, journal.Step)
+	assert.Equal(t, "8942310022000000000", journal.Sim.SimId)
+	assert.NotEmpty(t, journal.Error)
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	assert.Equal(t, "keyId-xxx", journal.Profile.AuthKeyID)
+	assert.Empty(t, journal.Profile.AuthKey)
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "secret-xxx", "auth key should not be written to the journal")
+
+	// another bootstrap must not create another SIM
+	_, err = b.Execute(context.Background(), nil)
+	assert.ErrorContains(t, err, "interrupted")
+
+	_, err = (&AuthKeyBootstrapper{JournalPath: path}).Resume(context.Background(), nil)
+	assert.ErrorContains(t, err, "auth key is required")
+
+	profile := *journal.Profile
+	profile.AuthKey = "secret-xxx"
+	config, err := (&AuthKeyBootstrapper{Profile: &profile, JournalPath: path}).Resume(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, sims.Load())
+	assert.Equal(t, "8942310022000000000", config.SimId)
+	assert.Equal(t, journal.PublicKey.String(), registered)
+	assert.Equal(t, journal.PrivateKey.String(), config.PrivateKey.String())
+	assert.Equal(t, "keyId-xxx", config.Profile.AuthKeyID)
+	assert.Equal(t, "secret-xxx", config.Profile.AuthKey)
+}
+
+func Test_AuthKeyBootstrapper_Resume_invalidResponse(t *testing.T) {
+	var sims atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth":
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+		case "/v1/sims":
+			sims.Add(1)
+			// the SIM is created, but the response has a key which can't be decoded
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"active","arcSessionStatus":{"arcClientPeerPrivateKey":"invalid-private-key"},`+
+				`"profiles":{"8942310022000000000":{"arcClientPeerPrivateKey":"invalid-private-key"}}}`)
+		case "/v1/sims/8942310022000000000":
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"active"}`)
+		case "/v1/sims/8942310022000000000/sessions/arc":
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010","arcClientPeerIpAddress":"10.0.0.1"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "arc.json.bootstrap.json")
+	b := &AuthKeyBootstrapper{
+		Profile:     &Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL},
+		JournalPath: path,
+	}
+
+	_, err := b.Execute(context.Background(), nil)
+	assert.ErrorContains(t, err, "8942310022000000000")
+	assert.ErrorContains(t, err, "soratun bootstrap resume")
+
+	journal, err := ReadBootstrapJournal(path)
+	assert.NoError(t, err)
+	assert.Equal(t, BootstrapStepCreateVirtualSim, journal.Step)
+	assert.Nil(t, journal.Sim)
+	assert.Contains(t, journal.SimResponse, "8942310022000000000")
+	assert.NotContains(t, journal.SimResponse, "invalid-private-key", "private key should not be written to the journal")
+
+	config, err := b.Resume(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, sims.Load())
+	assert.Equal(t, "8942310022000000000", config.SimId)
+	assert.Equal(t, "10.0.0.1", config.ArcSession.ArcClientPeerIpAddress.String())
+}
+
+func Test_journalSimResponse(t *testing.T) {
+	j := &BootstrapJournal{SimResponse: journalSimResponse([]byte(`{"simId":"8942310022000000000","profiles":{"8942310022000000000":{"arcClientPeerPrivateKey":"private-key`))}
+	assert.NotContains(t, j.SimResponse, "private-key")
+	assert.Equal(t, "8942310022000000000", j.simId())
+}
+
+func Test_AuthKeyBootstrapper_rejected(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth" {
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "arc.json.bootstrap.json")
+	b := &AuthKeyBootstrapper{
+		Profile:     &Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL},
+		JournalPath: path,
+	}
+
+	_, err := b.Execute(context.Background(), nil)
+	assert.Error(t, err)
+
+	// no SIM was created, so nothing to resume
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func Test_AuthKeyBootstrapper_rollback(t *testing.T) {
+	var requests []string
+	terminationEnabled := false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth" {
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/sims":
+			var body struct {
+				Subscription string `json:"subscription"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "planArc02", body.Subscription)
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"active"}`)
+		case "/v1/sims/8942310022000000000/tags":
+			var tags []map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&tags))
+			assert.Equal(t, []map[string]string{{"tagName": "name", "tagValue": "sensor-1"}, {"tagName": "site", "tagValue": "tokyo"}}, tags)
+			fmt.Fprint(w, `{"simId":"8942310022000000000"}`)
+		case "/v1/sims/8942310022000000000/set_group":
+			w.WriteHeader(http.StatusBadRequest)
+		case "/v1/sims/8942310022000000000/enable_termination":
+			terminationEnabled = true
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"active"}`)
+		case "/v1/sims/8942310022000000000/terminate":
+			if !terminationEnabled {
+				// termination protection is enabled by default
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"terminated"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "arc.json.bootstrap.json")
+	b := &AuthKeyBootstrapper{
+		Profile:     &Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL},
+		JournalPath: path,
+		SimOptions: &SimOptions{
+			Name:         "sensor-1",
+			Tags:         map[string]string{"site": "tokyo"},
+			GroupId:      "group-1",
+			Subscription: "planArc02",
+		},
+	}
+
+	_, err := b.Execute(context.Background(), nil)
+	assert.ErrorContains(t, err, "terminated")
+	assert.Equal(t, []string{
+		"POST /v1/sims",
+		"PUT /v1/sims/8942310022000000000/tags",
+		"POST /v1/sims/8942310022000000000/set_group",
+		"POST /v1/sims/8942310022000000000/enable_termination",
+		"POST /v1/sims/8942310022000000000/terminate",
+	}, requests)
+
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func Test_AuthKeyBootstrapper_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth":
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+		case "/v1/sims":
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"active"}`)
+		case "/v1/sims/8942310022000000000/sessions/arc":
+			// e.g. Ctrl-C while creating the session
+			_, _ = io.Copy(io.Discard, r.Body)
+			cancel()
+			<-r.Context().Done()
+		default:
+			t.Errorf("SIM should be kept on cancellation, but %s is requested", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "arc.json.bootstrap.json")
+	b := &AuthKeyBootstrapper{
+		Profile:     &Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL},
+		JournalPath: path,
+	}
+
+	_, err := b.Execute(ctx, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "soratun bootstrap resume")
+
+	journal, err := ReadBootstrapJournal(path)
+	assert.NoError(t, err)
+	assert.Equal(t, BootstrapStepCreateArcSession, journal.Step)
+	assert.Equal(t, "8942310022000000000", journal.Sim.SimId)
+}
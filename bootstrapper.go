@@ -1,6 +1,8 @@
 package soratun
 
-// Bootstrapper defines how to bootstrap virtual SIM with SORACOM.
+import "context"
+
+// Bootstrapper defines how to bootstrap virtual SIM with SORACOM. Execute should give up when ctx is done.
 type Bootstrapper interface {
-	Execute(config *Config) (*Config, error)
+	Execute(ctx context.Context, config *Config) (*Config, error)
 }
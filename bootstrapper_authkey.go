@@ -1,70 +1,342 @@
 package soratun
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 )
 
+// DefaultSubscription is the subscription plan of virtual SIMs created by AuthKeyBootstrapper.
+const DefaultSubscription = "planArc01"
+
+// rollbackTimeout is a deadline to terminate a virtual SIM which was created by a failed bootstrap. It is separate
+// from the bootstrap deadline, which may have been exceeded already.
+const rollbackTimeout = 30 * time.Second
+
+// SimOptions are applied to a virtual SIM created by AuthKeyBootstrapper.
+type SimOptions struct {
+	// Name is displayed in SORACOM User Console, which is saved as "name" tag.
+	Name string `json:"name,omitempty"`
+	// Tags are arbitrary tags of the SIM.
+	Tags map[string]string `json:"tags,omitempty"`
+	// GroupId is ID of the group to attach the SIM to, so SORACOM Arc configuration of the group applies.
+	GroupId string `json:"groupId,omitempty"`
+	// Subscription is the subscription plan, DefaultSubscription if empty.
+	Subscription string `json:"subscription,omitempty"`
+}
+
+// tags returns Tags with Name as "name" tag.
+func (o *SimOptions) tags() map[string]string {
+	tags := map[string]string{}
+	for k, v := range o.Tags {
+		tags[k] = v
+	}
+	if o.Name != "" {
+		tags["name"] = o.Name
+	}
+	return tags
+}
+
 // AuthKeyBootstrapper defines bootstrap method with SORACOM API authentication. Needs Profile information.
 type AuthKeyBootstrapper struct {
 	Profile *Profile
+	// Retry overrides Profile.Retry for this bootstrap, without saving it to the configuration.
+	Retry *RetryConfig
+	// Transport overrides Profile.Transport for this bootstrap, without saving it to the configuration.
+	Transport *TransportConfig
+	// JournalPath is a path to BootstrapJournal, which is written while creating a new virtual SIM. The caller should
+	// remove it with RemoveBootstrapJournal once the configuration is saved. No journal is written if empty.
+	JournalPath string
+	// SimOptions are applied to a new virtual SIM.
+	SimOptions *SimOptions
+	// SimId is an existing SIM to create Arc session for, e.g. a physical SIM or a SIM of a broken device which is
+	// replaced. If set, no SIM is created.
+	SimId string
 }
 
-// Execute calls SORACOM API to create a new standalone virtual subscriber.
-func (b *AuthKeyBootstrapper) Execute(config *Config) (*Config, error) {
-	client, err := NewDefaultSoracomClient(*b.Profile)
+// Execute calls SORACOM API to create a new standalone virtual subscriber. If SimId is set, or config has SIM ID, a new
+// Arc session of the SIM is created instead. Otherwise config, if any, is used as a template of the new
+// configuration. If any step fails after the SIM is created, the SIM is terminated, so no unconfigured SIM is left
+// billed.
+func (b *AuthKeyBootstrapper) Execute(ctx context.Context, config *Config) (*Config, error) {
+	client, err := b.newClient(ctx, b.Profile)
 	if err != nil {
 		return nil, err
 	}
 
-	if v := os.Getenv("SORACOM_VERBOSE"); v != "" {
-		client.SetVerbose(true)
+	if b.SimId != "" {
+		return b.attach(ctx, client, config)
 	}
 
-	if config == nil {
-		// if no config, bootstrap with API call
-		sim, err := client.CreateVirtualSim()
+	if config != nil && config.SimId != "" {
+		// or just update arcSession
+		arcSession, err := client.CreateArcSession(ctx, config.SimId, config.PublicKey.AsWgKey().String())
 		if err != nil {
 			return nil, err
 		}
+		config.ArcSession = arcSession
+		return config, nil
+	}
 
-		privateKey, err := NewKey(sim.Profiles[sim.SimId].ArcClientPeerPrivateKey)
-		if err != nil {
-			return nil, fmt.Errorf("virtual SIM/subscriber %s was created but failed to create a configuration. "+
-				"due to unexpected ArcClientPeerPrivateKey received. "+
-				"Please open SORACOM User Console at https://console.soracom.io and check virtual SIM status. "+
-				"You have to create arc.json manually", sim.SimId)
+	// if no config, bootstrap with API call
+	options := b.SimOptions
+	if options == nil {
+		options = &SimOptions{}
+	}
+
+	var journal *BootstrapJournal
+	if b.JournalPath != "" {
+		if _, err := os.Stat(b.JournalPath); err == nil {
+			return nil, fmt.Errorf("previous bootstrap was interrupted, and recorded in %s. Run \"soratun bootstrap resume\" to finish it, instead of creating another virtual SIM", b.JournalPath)
 		}
+		journal = &BootstrapJournal{StartedAt: time.Now(), Profile: journalProfile(b.Profile), SimOptions: options, path: b.JournalPath}
+	}
+
+	if err := journal.begin(BootstrapStepCreateVirtualSim); err != nil {
+		return nil, err
+	}
+	sim, err := client.CreateVirtualSim(ctx, options.Subscription)
+	if err != nil {
+		var e *apiError
+		var invalid *invalidResponseError
+		switch {
+		case errors.As(err, &e) && e.StatusCode < 500:
+			// the request was rejected, so no SIM was created
+			_ = RemoveBootstrapJournal(b.JournalPath)
+		case errors.As(err, &invalid) && journal != nil:
+			// the SIM was created, so keep the response to find the SIM on resume
+			journal.SimResponse = journalSimResponse(invalid.body)
+			journal.fail(err)
+			if simId := journal.simId(); simId != "" {
+				return nil, fmt.Errorf("virtual SIM/subscriber %s was created but failed to read it: %w. "+
+					"Run \"soratun bootstrap resume\" to finish the configuration", simId, err)
+			}
+			return nil, fmt.Errorf("virtual SIM/subscriber may have been created but failed to read it: %w. "+
+				"The response is saved in %s", err, b.JournalPath)
+		default:
+			journal.fail(err)
+		}
+		return nil, err
+	}
+	if journal != nil {
+		journal.Sim = sim
+	}
+
+	privateKey, publicKey, arcSession, err := b.finish(ctx, client, journal, sim, options)
+	if err != nil {
+		return nil, b.rollback(ctx, client, journal, sim, err)
+	}
+	return newAuthKeyConfig(config, b.Profile, sim, privateKey, publicKey, arcSession), nil
+}
+
+// attach creates Arc session of the existing SIM with a key pair generated on the device, and returns a configuration
+// which is based on config if not nil.
+func (b *AuthKeyBootstrapper) attach(ctx context.Context, client SoracomClient, config *Config) (*Config, error) {
+	sim, err := client.GetSim(ctx, b.SimId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SIM %s: %w", b.SimId, err)
+	}
+	if sim.Status == "terminated" {
+		return nil, fmt.Errorf("SIM %s is terminated", sim.SimId)
+	}
+
+	privateKey, publicKey, arcSession, err := CreateArcSessionWithNewKey(ctx, client, sim.SimId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SORACOM Arc session for SIM %s: %w", sim.SimId, err)
+	}
+	return newAuthKeyConfig(config, b.Profile, sim, privateKey, publicKey, arcSession), nil
+}
+
+// Resume finishes the bootstrap recorded in the journal at JournalPath, without creating another virtual SIM. Profile
+// and SimOptions in the journal are used if they are nil, but Profile with AuthKey is required since the journal has no
+// auth key. Like Execute, config is used as a template if not nil. Unlike Execute, the SIM is kept even if Resume
+// fails, so it can be resumed again.
+func (b *AuthKeyBootstrapper) Resume(ctx context.Context, config *Config) (*Config, error) {
+	journal, err := ReadBootstrapJournal(b.JournalPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("no interrupted bootstrap is found")
+		}
+		return nil, err
+	}
+
+	if journal.simId() == "" {
+		return nil, fmt.Errorf("creating a virtual SIM was interrupted at %s with error: %s. It is unknown whether the SIM was created. "+
+			"Please check virtual SIMs in SORACOM User Console at https://console.soracom.io or with \"soratun sim list\", "+
+			"then remove %s to bootstrap again", journal.StartedAt.Format(time.RFC3339), journal.Error, b.JournalPath)
+	}
+
+	profile := journal.Profile
+	if b.Profile != nil {
+		profile = b.Profile
+	}
+	if profile == nil || profile.AuthKey == "" {
+		return nil, errors.New("auth key is required to resume bootstrap, since it is not saved in the journal")
+	}
+	// a journal written by an older version may have the auth key, which is removed when the journal is updated
+	journal.Profile = journalProfile(profile)
+	if b.SimOptions != nil {
+		journal.SimOptions = b.SimOptions
+	}
+	options := journal.SimOptions
+	if options == nil {
+		options = &SimOptions{}
+	}
+
+	client, err := b.newClient(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
 
-		publicKey, err := NewKey(sim.Profiles[sim.SimId].ArcClientPeerPublicKey)
+	if journal.Sim == nil {
+		// the SIM was created, but the response couldn't be decoded
+		simId := journal.simId()
+		sim, err := client.GetSim(ctx, simId)
 		if err != nil {
-			return nil, fmt.Errorf("virtual SIM/subscriber %s was created but failed to create a configuration. "+
-				"due to unexpected ArcClientPeerPublicKey received. "+
-				"Please open SORACOM User Console at https://console.soracom.io and check virtual SIM status. "+
-				"You have to create arc.json manually", sim.SimId)
+			journal.fail(err)
+			return nil, fmt.Errorf("failed to get virtual SIM/subscriber %s: %w. Run \"soratun bootstrap resume\" again", simId, err)
 		}
+		journal.Sim = sim
+	}
 
-		config = &Config{
-			PrivateKey:           privateKey,
-			PublicKey:            publicKey,
-			SimId:                sim.SimId,
-			LogLevel:             LogLevelVerbose,
-			EnableMetrics:        true,
-			Interface:            DefaultInterfaceName(),
-			AdditionalAllowedIPs: nil,
-			Mtu:                  DefaultMTU,
-			PersistentKeepalive:  DefaultPersistentKeepaliveInterval,
-			Profile:              b.Profile,
-			ArcSession:           &sim.ArcSession,
+	privateKey, publicKey, arcSession, err := b.finish(ctx, client, journal, journal.Sim, options)
+	if err != nil {
+		journal.fail(err)
+		return nil, fmt.Errorf("failed to configure virtual SIM/subscriber %s: %w. Run \"soratun bootstrap resume\" again", journal.Sim.SimId, err)
+	}
+	return newAuthKeyConfig(config, profile, journal.Sim, privateKey, publicKey, arcSession), nil
+}
+
+// finish applies options to the created SIM, then creates Arc session with a key pair generated on the device. The key
+// pair in the journal is reused, since the server may have registered it before the interruption.
+func (b *AuthKeyBootstrapper) finish(ctx context.Context, client SoracomClient, journal *BootstrapJournal, sim *VirtualSim, options *SimOptions) (privateKey, publicKey Key, session *ArcSession, err error) {
+	if tags := options.tags(); len(tags) > 0 {
+		if err := journal.begin(BootstrapStepSetSimTags); err != nil {
+			return Key{}, Key{}, nil, err
+		}
+		if _, err := client.SetSimTags(ctx, sim.SimId, tags); err != nil {
+			return Key{}, Key{}, nil, fmt.Errorf("failed to set tags: %w", err)
 		}
+	}
+
+	if options.GroupId != "" {
+		if err := journal.begin(BootstrapStepSetSimGroup); err != nil {
+			return Key{}, Key{}, nil, err
+		}
+		if _, err := client.SetSimGroup(ctx, sim.SimId, options.GroupId); err != nil {
+			return Key{}, Key{}, nil, fmt.Errorf("failed to set group: %w", err)
+		}
+	}
+
+	if journal != nil && journal.PrivateKey != nil && journal.PublicKey != nil {
+		privateKey, publicKey = *journal.PrivateKey, *journal.PublicKey
 	} else {
-		// or just update arcSession
-		arcSession, err := client.CreateArcSession(config.SimId, config.PublicKey.AsWgKey().String())
-		if err != nil {
-			return nil, err
+		// SORACOM generates a key pair for a new SIM, but replace it with one generated on this device, so the private
+		// key in use has never been sent over the network
+		if privateKey, publicKey, err = GenerateKeyPair(); err != nil {
+			return Key{}, Key{}, nil, err
 		}
-		config.ArcSession = arcSession
+		if journal != nil {
+			journal.PrivateKey, journal.PublicKey = &privateKey, &publicKey
+		}
+	}
+
+	if err := journal.begin(BootstrapStepCreateArcSession); err != nil {
+		return Key{}, Key{}, nil, err
+	}
+	session, err = client.CreateArcSession(ctx, sim.SimId, publicKey.String())
+	if err != nil {
+		return Key{}, Key{}, nil, fmt.Errorf("failed to create SORACOM Arc session: %w", err)
+	}
+
+	if err := journal.begin(BootstrapStepSaveConfig); err != nil {
+		return Key{}, Key{}, nil, err
+	}
+	return privateKey, publicKey, session, nil
+}
+
+// rollback terminates the SIM which was created by failed Execute, and returns an error which describes cause. The SIM
+// is terminated only if the request was rejected, since retrying it wouldn't help. If the failure may be transient,
+// e.g. 5xx response, network error, or cancellation, or the SIM can't be terminated, the SIM and the journal are kept so
+// the bootstrap can be resumed.
+func (b *AuthKeyBootstrapper) rollback(ctx context.Context, client SoracomClient, journal *BootstrapJournal, sim *VirtualSim, cause error) error {
+	hint := fmt.Sprintf("Please configure it with \"soratun bootstrap authkey --sim-id %s\", or terminate it", sim.SimId)
+	if journal != nil {
+		hint = "Run \"soratun bootstrap resume\" to finish the configuration, or terminate it"
 	}
 
-	return config, nil
+	var e *apiError
+	if !errors.As(cause, &e) || e.StatusCode < 400 || e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests {
+		journal.fail(cause)
+		return fmt.Errorf("virtual SIM/subscriber %s was created but failed to create a configuration: %w. "+
+			"%s in SORACOM User Console at https://console.soracom.io", sim.SimId, cause, hint)
+	}
+
+	// terminate even if the bootstrap was cancelled or timed out
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	_, err := client.EnableSimTermination(ctx, sim.SimId)
+	if err == nil {
+		_, err = client.TerminateSim(ctx, sim.SimId)
+	}
+	if err != nil {
+		journal.fail(cause)
+		return fmt.Errorf("virtual SIM/subscriber %s was created but failed to create a configuration: %w. "+
+			"Failed to terminate the SIM as well: %v. "+
+			"%s in SORACOM User Console at https://console.soracom.io", sim.SimId, cause, err, hint)
+	}
+
+	if journal != nil {
+		_ = RemoveBootstrapJournal(journal.path)
+	}
+	return fmt.Errorf("virtual SIM/subscriber %s was created but failed to create a configuration, and terminated: %w", sim.SimId, cause)
+}
+
+// newAuthKeyConfig returns a configuration for the SIM, which is based on template if not nil.
+func newAuthKeyConfig(template *Config, profile *Profile, sim *VirtualSim, privateKey, publicKey Key, arcSession *ArcSession) *Config {
+	config := &Config{
+		LogLevel:            LogLevelVerbose,
+		EnableMetrics:       true,
+		Interface:           DefaultInterfaceName(),
+		Mtu:                 DefaultMTU,
+		PersistentKeepalive: DefaultPersistentKeepaliveInterval,
+	}
+	if template != nil {
+		config = template
+		if config.Interface == "" {
+			config.Interface = DefaultInterfaceName()
+		}
+	}
+
+	config.PrivateKey = privateKey
+	config.PublicKey = publicKey
+	config.SimId = sim.SimId
+	config.Profile = profile
+	config.ArcSession = arcSession
+	return config
+}
+
+// newClient returns SORACOM API client with the profile, and Retry and Transport overrides.
+func (b *AuthKeyBootstrapper) newClient(ctx context.Context, p *Profile) (SoracomClient, error) {
+	profile := *p
+	if b.Retry != nil {
+		profile.Retry = b.Retry
+	}
+	if b.Transport != nil {
+		profile.Transport = b.Transport
+	}
+
+	client, err := NewDefaultSoracomClient(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := os.Getenv("SORACOM_VERBOSE"); v != "" {
+		client.SetVerbose(true)
+	}
+	return client, nil
 }
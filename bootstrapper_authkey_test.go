@@ -0,0 +1,47 @@
+package soratun
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AuthKeyBootstrapper_SimId(t *testing.T) {
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth" {
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/sims/8942310022000000000":
+			fmt.Fprint(w, `{"simId":"8942310022000000000","status":"active"}`)
+		case "/v1/sims/8942310022000000001":
+			fmt.Fprint(w, `{"simId":"8942310022000000001","status":"terminated"}`)
+		case "/v1/sims/8942310022000000000/sessions/arc":
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010","arcClientPeerIpAddress":"10.0.0.1"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	profile := &Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL}
+	template := &Config{SimId: "8942310022000000009", Interface: "arc0", LogLevel: LogLevelError}
+
+	config, err := (&AuthKeyBootstrapper{Profile: profile, SimId: "8942310022000000000"}).Execute(context.Background(), template)
+	assert.NoError(t, err)
+	assert.Equal(t, "8942310022000000000", config.SimId)
+	assert.Equal(t, "arc0", config.Interface)
+	assert.Equal(t, LogLevelError, config.LogLevel)
+	assert.Equal(t, "10.0.0.1", config.ArcSession.ArcClientPeerIpAddress.String())
+	assert.Equal(t, []string{"GET /v1/sims/8942310022000000000", "POST /v1/sims/8942310022000000000/sessions/arc"}, requests)
+
+	_, err = (&AuthKeyBootstrapper{Profile: profile, SimId: "8942310022000000001"}).Execute(context.Background(), nil)
+	assert.ErrorContains(t, err, "terminated")
+}
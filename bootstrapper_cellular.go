@@ -1,16 +1,21 @@
 package soratun
 
 import (
+	"context"
 	"os"
 )
 
 // CellularBootstrapper defines bootstrap method with SORACOM Krypton cellular authentication. Needs active cellular connection.
 type CellularBootstrapper struct {
 	Endpoint string
+	// Retry controls timeouts and retries of SORACOM Krypton Provisioning API requests.
+	Retry *RetryConfig
+	// Transport controls TLS and proxy settings to connect to SORACOM Krypton Provisioning API.
+	Transport *TransportConfig
 }
 
 // Execute calls SORACOM Krypton Provisioning API cellular endpoint to create a new virtual subscriber which is associated with current physical SIM.
-func (b *CellularBootstrapper) Execute(config *Config) (*Config, error) {
+func (b *CellularBootstrapper) Execute(ctx context.Context, config *Config) (*Config, error) {
 	// if no config, create a blank, then replace keys and ArcSession with new
 	if config == nil {
 		config = &Config{
@@ -27,13 +32,16 @@ func (b *CellularBootstrapper) Execute(config *Config) (*Config, error) {
 			ArcSession:           nil,
 		}
 	}
-	client := NewDefaultSoracomKryptonClient(&KryptonClientConfig{Endpoint: b.Endpoint})
+	client, err := NewDefaultSoracomKryptonClient(&KryptonClientConfig{Endpoint: b.Endpoint, Retry: b.Retry, Transport: b.Transport})
+	if err != nil {
+		return nil, err
+	}
 
 	if v := os.Getenv("SORACOM_VERBOSE"); v != "" {
 		client.SetVerbose(true)
 	}
 
-	arcSession, err := client.Bootstrap()
+	arcSession, err := client.Bootstrap(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,275 @@
+package soratun
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soracom/soratun/internal"
+)
+
+// default endpoints of SORACOM Krypton SIM authentication.
+const (
+	DefaultKryptonKeysEndpoint         = "https://krypton.soracom.io:8036/v1/keys"
+	DefaultKryptonProvisioningEndpoint = "https://krypton.soracom.io:8036/v1/provisioning"
+)
+
+// SimBootstrapper defines bootstrap method with SORACOM Krypton SIM authentication. It runs krypton-cli, or
+// authenticates with the SIM through UICC if it is set. Requests to Krypton with UICC are not verified against
+// krypton-cli yet, so it is experimental.
+type SimBootstrapper struct {
+	// UICC is the SIM to authenticate with instead of running krypton-cli.
+	UICC UICC
+	// KeysEndpoint and ProvisioningEndpoint are base URLs of SORACOM Krypton Keys API and Provisioning API. Defaults
+	// are used if empty.
+	KeysEndpoint         string
+	ProvisioningEndpoint string
+	// SignatureAlgorithm is the hash algorithm to sign the provisioning request, SHA-1, SHA-256, SHA-384 or SHA-512.
+	SignatureAlgorithm string
+	// Params are additional JSON parameters of the provisioning request.
+	Params string
+	// Retry controls timeouts and retries of requests.
+	Retry *RetryConfig
+	// Transport controls TLS and proxy settings to connect to the endpoints.
+	Transport *TransportConfig
+
+	// KryptonCliPath and Arguments run krypton-cli if UICC is nil.
+	KryptonCliPath string
+	Arguments      []string
+}
+
+// Execute creates a new virtual subscriber which is associated with current physical SIM.
+func (b *SimBootstrapper) Execute(ctx context.Context, config *Config) (*Config, error) {
+	var arcSession *ArcSession
+	var err error
+	if b.UICC != nil {
+		arcSession, err = b.bootstrapWithUICC(ctx)
+	} else {
+		arcSession, err = b.runKryptonCli(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// if no config, create a blank, then replace keys and ArcSession with new
+	if config == nil {
+		config = &Config{
+			PrivateKey:           Key{},
+			PublicKey:            Key{},
+			SimId:                "",
+			LogLevel:             LogLevelVerbose,
+			EnableMetrics:        true,
+			Interface:            DefaultInterfaceName(),
+			AdditionalAllowedIPs: nil,
+			Mtu:                  DefaultMTU,
+			PersistentKeepalive:  DefaultPersistentKeepaliveInterval,
+			Profile:              nil,
+			ArcSession:           nil,
+		}
+	}
+
+	config.PrivateKey = arcSession.ArcClientPeerPrivateKey
+	config.PublicKey = (Key)(arcSession.ArcClientPeerPrivateKey.AsWgKey().PublicKey())
+	config.ArcSession = arcSession
+	return config, nil
+}
+
+// bootstrapWithUICC authenticates with the SIM, and creates SORACOM Arc session with the key derived from the result:
+//
+//  1. selects the USIM application, and reads IMSI from the SIM
+//  2. gets a challenge, RAND and AUTN, for the IMSI from Keys API
+//  3. runs AUTHENTICATE command of the SIM with the challenge, which results in RES, CK and IK
+//  4. sends RES to Keys API, which verifies it and returns key ID of CK and IK on the server side
+//  5. sends bootstrap request to Provisioning API, signed with CK and IK
+func (b *SimBootstrapper) bootstrapWithUICC(ctx context.Context) (*ArcSession, error) {
+	newHash, err := signatureHash(b.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	params := b.Params
+	if params == "" {
+		params = "{}"
+	}
+	if !json.Valid([]byte(params)) {
+		return nil, fmt.Errorf("invalid JSON parameters %s", params)
+	}
+
+	transport, err := newAPITransport(b.Retry, b.Transport)
+	if err != nil {
+		return nil, err
+	}
+	c := &kryptonSimAuthClient{
+		keysEndpoint:         endpointOrDefault(b.KeysEndpoint, DefaultKryptonKeysEndpoint),
+		provisioningEndpoint: endpointOrDefault(b.ProvisioningEndpoint, DefaultKryptonProvisioningEndpoint),
+		transport:            transport,
+		verbose:              os.Getenv("SORACOM_VERBOSE") != "",
+	}
+
+	if err := selectUSIM(ctx, b.UICC); err != nil {
+		return nil, fmt.Errorf("failed to select USIM application of SIM: %w", err)
+	}
+	imsi, err := readIMSI(ctx, b.UICC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IMSI from SIM: %w", err)
+	}
+
+	challenge, err := c.challenge(ctx, imsi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get challenge for %s: %w", imsi, err)
+	}
+	rand, err := hex.DecodeString(challenge.Rand)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RAND in challenge: %w", err)
+	}
+	autn, err := hex.DecodeString(challenge.Autn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTN in challenge: %w", err)
+	}
+
+	result, err := authenticateUICC(ctx, b.UICC, rand, autn)
+	if err != nil {
+		return nil, fmt.Errorf("SIM authentication failed: %w", err)
+	}
+
+	keyId, err := c.verify(ctx, imsi, result.res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify SIM authentication: %w", err)
+	}
+
+	arcSession, err := c.bootstrapArc(ctx, imsi, keyId, append(result.ck, result.ik...), newHash, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bootstrap: %w", err)
+	}
+	return arcSession, nil
+}
+
+// signatureHash returns the hash function of the signature algorithm.
+func signatureHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "SHA-1":
+		return sha1.New, nil
+	case "", "SHA-256":
+		return sha256.New, nil
+	case "SHA-384":
+		return sha512.New384, nil
+	case "SHA-512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported signature algorithm \"%s\", it should be one of SHA-1, SHA-256, SHA-384 or SHA-512", algorithm)
+}
+
+func endpointOrDefault(endpoint, defaultEndpoint string) string {
+	if endpoint == "" {
+		return defaultEndpoint
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+// kryptonSimAuthClient calls SORACOM Krypton Keys API and Provisioning API for SIM authentication.
+type kryptonSimAuthClient struct {
+	keysEndpoint         string
+	provisioningEndpoint string
+	transport            *apiTransport
+	verbose              bool
+	now                  func() time.Time // time.Now if nil
+}
+
+// kryptonChallenge is a challenge for SIM authentication, in hex.
+type kryptonChallenge struct {
+	Rand string `json:"rand"`
+	Autn string `json:"autn"`
+}
+
+// challenge gets a new challenge, so it is safe to retry.
+func (c *kryptonSimAuthClient) challenge(ctx context.Context, imsi string) (*kryptonChallenge, error) {
+	var challenge kryptonChallenge
+	err := c.post(ctx, c.keysEndpoint+"/"+url.PathEscape(imsi)+"/bootstrap", nil, "{}", true, &challenge)
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// verify sends RES, and returns key ID. The challenge is consumed, so it is never retried unless the server rejects
+// the request with 429.
+func (c *kryptonSimAuthClient) verify(ctx context.Context, imsi string, res []byte) (string, error) {
+	body, err := json.Marshal(map[string]string{"res": hex.EncodeToString(res)})
+	if err != nil {
+		return "", err
+	}
+
+	var v struct {
+		KeyId string `json:"keyId"`
+	}
+	err = c.post(ctx, c.keysEndpoint+"/"+url.PathEscape(imsi)+"/verify", nil, string(body), false, &v)
+	if err != nil {
+		return "", err
+	}
+	if v.KeyId == "" {
+		return "", errors.New("no key ID in the response")
+	}
+	return v.KeyId, nil
+}
+
+// bootstrapArc creates a new virtual SIM and SORACOM Arc session, with the request signed by hash of the key,
+// timestamp in milliseconds and body. It creates a new virtual SIM, so it is never retried unless the server rejects
+// the request with 429.
+func (c *kryptonSimAuthClient) bootstrapArc(ctx context.Context, imsi, keyId string, key []byte, newHash func() hash.Hash, body string) (*ArcSession, error) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	timestamp := strconv.FormatInt(now().UnixMilli(), 10)
+	h := newHash()
+	h.Write(key)
+	h.Write([]byte(timestamp))
+	h.Write([]byte(body))
+
+	header := http.Header{}
+	header.Set("X-Soracom-Imsi", imsi)
+	header.Set("X-Soracom-Key-Id", keyId)
+	header.Set("X-Soracom-Timestamp", timestamp)
+	header.Set("X-Soracom-Signature", hex.EncodeToString(h.Sum(nil)))
+
+	var arcSession ArcSession
+	err := c.post(ctx, c.provisioningEndpoint+"/soracom/arc/bootstrap", header, body, false, &arcSession)
+	if err != nil {
+		return nil, err
+	}
+	return &arcSession, nil
+}
+
+func (c *kryptonSimAuthClient) post(ctx context.Context, u string, header http.Header, body string, idempotent bool, v interface{}) error {
+	res, err := c.transport.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", u, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Soracom-Lang", "en")
+		req.Header.Set("User-Agent", internal.UserAgent)
+		return req, nil
+	}, idempotent, c.verbose)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	return json.NewDecoder(res.Body).Decode(v)
+}
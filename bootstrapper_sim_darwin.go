@@ -1,16 +1,11 @@
 package soratun
 
 import (
+	"context"
 	"errors"
 )
 
-// SimBootstrapper defines bootstrap method with SORACOM Krypton SIM authentication. Needs krypton-cli installed.
-type SimBootstrapper struct {
-	KryptonCliPath string
-	Arguments      []string
-}
-
-// Execute calls SORACOM Krypton CLI to create a new virtual subscriber which is associated with current physical SIM.
-func (b *SimBootstrapper) Execute(config *Config) (*Config, error) {
-	return nil, errors.New("bootstrap with SIM authentication is not supported on this platform")
+// runKryptonCli is not supported, since krypton-cli is not available on this platform.
+func (b *SimBootstrapper) runKryptonCli(ctx context.Context) (*ArcSession, error) {
+	return nil, errors.New("bootstrap with krypton-cli is not supported on this platform")
 }
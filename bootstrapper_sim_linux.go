@@ -2,21 +2,16 @@ package soratun
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 )
 
-// SimBootstrapper defines bootstrap method with SORACOM Krypton SIM authentication. Needs krypton-cli installed.
-type SimBootstrapper struct {
-	KryptonCliPath string
-	Arguments      []string
-}
-
-// Execute calls SORACOM Krypton CLI to create a new virtual subscriber which is associated with current physical SIM.
-func (b *SimBootstrapper) Execute(config *Config) (*Config, error) {
+// runKryptonCli calls SORACOM Krypton CLI to create a new virtual subscriber which is associated with current physical
+// SIM.
+func (b *SimBootstrapper) runKryptonCli(ctx context.Context) (*ArcSession, error) {
 	if _, err := os.Stat(b.KryptonCliPath); os.IsNotExist(err) {
 		return nil, err
 	}
@@ -25,32 +20,19 @@ func (b *SimBootstrapper) Execute(config *Config) (*Config, error) {
 		fmt.Fprintf(os.Stderr, "Running %s %s\n", b.KryptonCliPath, b.Arguments)
 	}
 
-	// if no config, create a blank, then replace keys and ArcSession with new
-	if config == nil {
-		config = &Config{
-			PrivateKey:           Key{},
-			PublicKey:            Key{},
-			SimId:                "",
-			LogLevel:             LogLevelVerbose,
-			EnableMetrics:        true,
-			Interface:            DefaultInterfaceName(),
-			AdditionalAllowedIPs: nil,
-			Mtu:                  DefaultMTU,
-			PersistentKeepalive:  DefaultPersistentKeepaliveInterval,
-			Profile:              nil,
-			ArcSession:           nil,
-		}
-	}
-
-	cmd := exec.Command(b.KryptonCliPath, b.Arguments...)
+	// krypton-cli is killed if ctx is done before it exits
+	cmd := exec.CommandContext(ctx, b.KryptonCliPath, b.Arguments...)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s was aborted: %w", b.KryptonCliPath, ctx.Err())
+	}
 	if err != nil {
-		log.Fatalf("Error while running %s: %s\n%s", b.KryptonCliPath, err, &stderr)
+		return nil, fmt.Errorf("error while running %s: %s\n%s", b.KryptonCliPath, err, &stderr)
 	}
 
 	var arcSession ArcSession
@@ -70,8 +52,5 @@ func (b *SimBootstrapper) Execute(config *Config) (*Config, error) {
 		fmt.Fprintf(os.Stderr, "Got response from %s: %s\n", b.KryptonCliPath, t)
 	}
 
-	config.PrivateKey = arcSession.ArcClientPeerPrivateKey
-	config.PublicKey = (Key)(arcSession.ArcClientPeerPrivateKey.AsWgKey().PublicKey())
-	config.ArcSession = &arcSession
-	return config, nil
+	return &arcSession, nil
 }
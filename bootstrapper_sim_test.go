@@ -0,0 +1,270 @@
+package soratun
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeModem answers AT+CSIM commands with the UICC, like a modem on a serial port.
+type fakeModem struct {
+	uicc UICC
+	out  bytes.Buffer
+}
+
+var csimPattern = regexp.MustCompile(`^AT\+CSIM=(\d+),"([0-9A-F]*)"\r$`)
+
+func (m *fakeModem) Write(p []byte) (int, error) {
+	// echo is enabled
+	m.out.Write(p)
+	m.out.WriteString("\n")
+
+	matches := csimPattern.FindStringSubmatch(string(p))
+	if matches == nil {
+		m.out.WriteString("\r\nERROR\r\n")
+		return len(p), nil
+	}
+	command, _ := hex.DecodeString(matches[2])
+	res, err := m.uicc.Transmit(context.Background(), command)
+	if err != nil {
+		return 0, err
+	}
+	h := strings.ToUpper(hex.EncodeToString(res))
+	fmt.Fprintf(&m.out, "\r\n+CSIM: %d,\"%s\"\r\n\r\nOK\r\n", len(h), h)
+	return len(p), nil
+}
+
+func (m *fakeModem) Read(p []byte) (int, error) {
+	return m.out.Read(p)
+}
+
+func Test_SimBootstrapper_UICC(t *testing.T) {
+	imsi := "440103123456789"
+	k := mustDecodeHex(t, "465b5ce8b199b49faa5f0a2ee238a6bc")
+	opc := mustDecodeHex(t, "cd63cb71954a9f4e48a5994e37a02baf")
+	rand := mustDecodeHex(t, "23553cbe9637a89d218ae64dae47bf35")
+	sqn := mustDecodeHex(t, "000000000021")
+	amf := mustDecodeHex(t, "8000")
+
+	m, err := newMilenage(k, opc)
+	assert.NoError(t, err)
+	expected, err := m.f2345(rand)
+	assert.NoError(t, err)
+
+	// the server verifies RES and the signature in the same way as kryptonSimAuthClient computes them
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/v1/keys/" + imsi + "/bootstrap":
+			autn, err := m.generateAutn(rand, sqn, amf)
+			assert.NoError(t, err)
+			fmt.Fprintf(w, `{"rand":"%x","autn":"%x"}`, rand, autn)
+		case "/v1/keys/" + imsi + "/verify":
+			var v struct {
+				Res string `json:"res"`
+			}
+			assert.NoError(t, json.Unmarshal(body, &v))
+			if v.Res != hex.EncodeToString(expected.res) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"keyId":"key-1"}`)
+		case "/v1/provisioning/soracom/arc/bootstrap":
+			h := sha256.New()
+			h.Write(expected.ck)
+			h.Write(expected.ik)
+			h.Write([]byte(r.Header.Get("X-Soracom-Timestamp")))
+			h.Write(body)
+			if r.Header.Get("X-Soracom-Imsi") != imsi || r.Header.Get("X-Soracom-Key-Id") != "key-1" ||
+				r.Header.Get("X-Soracom-Signature") != hex.EncodeToString(h.Sum(nil)) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			assert.JSONEq(t, `{"metadata":{"site":"tokyo"}}`, string(body))
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010","arcClientPeerIpAddress":"10.0.0.1","arcClientPeerPrivateKey":"MB9WzXmFTHmzZTm6SOnyM1dwR3QaeI9P7VQGfRYH4nI=","arcAllowedIPs":["100.127.0.0/16"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	card, err := NewSimulatedUICC(imsi, k, opc)
+	assert.NoError(t, err)
+	// AUTHENTICATE is rejected until the USIM application is selected
+	_, err = authenticateUICC(context.Background(), card, make([]byte, milenageRandSize), make([]byte, milenageAutnSize))
+	var uiccErr *UICCError
+	assert.ErrorAs(t, err, &uiccErr)
+	assert.EqualValues(t, 0x6985, uiccErr.SW)
+
+	b := &SimBootstrapper{
+		UICC:                 NewATCommandUICC(&fakeModem{uicc: card}),
+		KeysEndpoint:         s.URL + "/v1/keys",
+		ProvisioningEndpoint: s.URL + "/v1/provisioning/",
+		Params:               `{"metadata":{"site":"tokyo"}}`,
+		Retry:                &RetryConfig{MaxAttempts: 1},
+	}
+
+	config, err := b.Execute(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"POST /v1/keys/" + imsi + "/bootstrap",
+		"POST /v1/keys/" + imsi + "/verify",
+		"POST /v1/provisioning/soracom/arc/bootstrap",
+	}, requests)
+	assert.Equal(t, "10.0.0.1", config.ArcSession.ArcClientPeerIpAddress.String())
+	assert.Equal(t, "MB9WzXmFTHmzZTm6SOnyM1dwR3QaeI9P7VQGfRYH4nI=", config.PrivateKey.String())
+	assert.Equal(t, DefaultInterfaceName(), config.Interface)
+
+	// the same challenge is replayed, and the card rejects the sequence number
+	requests = nil
+	_, err = b.Execute(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrUICCSyncFailure)
+	assert.ErrorContains(t, err, "not supported")
+	assert.Equal(t, []string{"POST /v1/keys/" + imsi + "/bootstrap"}, requests)
+
+	// a card with another key rejects AUTN
+	other, err := NewSimulatedUICC(imsi, opc, k)
+	assert.NoError(t, err)
+	b.UICC = other
+	_, err = b.Execute(context.Background(), nil)
+	assert.ErrorAs(t, err, &uiccErr)
+	assert.EqualValues(t, swAuthMacFailure, uiccErr.SW)
+}
+
+func Test_parseCSIMResponse(t *testing.T) {
+	res, err := parseCSIMResponse("AT+CSIM=10,\"00B0000009\"\n+CSIM: 4,\"9000\"\nOK\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x90, 0x00}, res)
+
+	// mmcli output
+	res, err = parseCSIMResponse("response: '+CSIM: 4,\"6110\"'\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x61, 0x10}, res)
+
+	_, err = parseCSIMResponse("+CSIM: 6,\"9000\"\n")
+	assert.Error(t, err)
+	_, err = parseCSIMResponse("ERROR\n")
+	assert.Error(t, err)
+}
+
+func Test_withLe(t *testing.T) {
+	// case 1, case 2, case 3 and case 4
+	assert.Equal(t, []byte{0x00, 0xc0, 0x00, 0x00, 0x10}, withLe([]byte{0x00, 0xc0, 0x00, 0x00}, 0x10))
+	assert.Equal(t, []byte{0x00, 0xb0, 0x00, 0x00, 0x09}, withLe([]byte{0x00, 0xb0, 0x00, 0x00, 0x00}, 0x09))
+	assert.Equal(t, []byte{0x00, 0x88, 0x00, 0x81, 0x02, 0xaa, 0xbb, 0x35}, withLe([]byte{0x00, 0x88, 0x00, 0x81, 0x02, 0xaa, 0xbb}, 0x35))
+	assert.Equal(t, []byte{0x00, 0x88, 0x00, 0x81, 0x02, 0xaa, 0xbb, 0x35}, withLe([]byte{0x00, 0x88, 0x00, 0x81, 0x02, 0xaa, 0xbb, 0x00}, 0x35))
+}
+
+func Test_decodeIMSI(t *testing.T) {
+	for _, imsi := range []string{"440103123456789", "44010312345678"} {
+		got, err := decodeIMSI(encodeIMSI(imsi))
+		assert.NoError(t, err)
+		assert.Equal(t, imsi, got)
+	}
+
+	got, err := decodeIMSI(mustDecodeHex(t, "084904011332547698"))
+	assert.NoError(t, err)
+	assert.Equal(t, "440103123456789", got)
+
+	_, err = decodeIMSI([]byte{0x09, 0x49})
+	assert.Error(t, err)
+}
+
+// Test_kryptonSimAuthClient_requests pins the endpoints, request bodies and signatures which soratun sends for Krypton
+// SIM authentication, with RES, CK and IK of test set 1 in 3GPP TS 35.208. Only RES, CK and IK come from the
+// specification. The paths, headers and signatures are output of this implementation to catch regressions, and are
+// not verified against krypton-cli or the Krypton API documentation.
+func Test_kryptonSimAuthClient_requests(t *testing.T) {
+	res := mustDecodeHex(t, "a54211d5e3ba50bf")
+	ck := mustDecodeHex(t, "b40ba9a3c58b2a05bbf0d987b21bf8cb")
+	ik := mustDecodeHex(t, "f769bcd751044604127672711c6d3441")
+
+	m, err := newMilenage(mustDecodeHex(t, "465b5ce8b199b49faa5f0a2ee238a6bc"), mustDecodeHex(t, "cd63cb71954a9f4e48a5994e37a02baf"))
+	assert.NoError(t, err)
+	result, err := m.f2345(mustDecodeHex(t, "23553cbe9637a89d218ae64dae47bf35"))
+	assert.NoError(t, err)
+	assert.Equal(t, res, result.res)
+	assert.Equal(t, ck, result.ck)
+	assert.Equal(t, ik, result.ik)
+
+	assert.Equal(t, "https://krypton.soracom.io:8036/v1/keys", DefaultKryptonKeysEndpoint)
+	assert.Equal(t, "https://krypton.soracom.io:8036/v1/provisioning", DefaultKryptonProvisioningEndpoint)
+
+	type request struct {
+		method, path, body string
+		header             http.Header
+	}
+	var requests []request
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		header := http.Header{}
+		for _, k := range []string{"Content-Type", "X-Soracom-Imsi", "X-Soracom-Key-Id", "X-Soracom-Timestamp", "X-Soracom-Signature"} {
+			if v := r.Header.Get(k); v != "" {
+				header.Set(k, v)
+			}
+		}
+		requests = append(requests, request{r.Method, r.URL.Path, string(body), header})
+		switch r.URL.Path {
+		case "/v1/keys/440103123456789/bootstrap":
+			fmt.Fprint(w, `{"rand":"23553cbe9637a89d218ae64dae47bf35","autn":"00"}`)
+		case "/v1/keys/440103123456789/verify":
+			fmt.Fprint(w, `{"keyId":"key-1"}`)
+		case "/v1/provisioning/soracom/arc/bootstrap":
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010"}`)
+		}
+	}))
+	defer s.Close()
+
+	transport, err := newAPITransport(nil, nil)
+	assert.NoError(t, err)
+	c := &kryptonSimAuthClient{
+		keysEndpoint:         s.URL + "/v1/keys",
+		provisioningEndpoint: s.URL + "/v1/provisioning",
+		transport:            transport,
+		now: func() time.Time {
+			return time.UnixMilli(1700000000000)
+		},
+	}
+
+	_, err = c.challenge(context.Background(), "440103123456789")
+	assert.NoError(t, err)
+	_, err = c.verify(context.Background(), "440103123456789", res)
+	assert.NoError(t, err)
+	_, err = c.bootstrapArc(context.Background(), "440103123456789", "key-1", append(ck, ik...), sha256.New, `{"subscription":"planArc01"}`)
+	assert.NoError(t, err)
+	_, err = c.bootstrapArc(context.Background(), "440103123456789", "key-1", append(ck, ik...), sha1.New, `{"subscription":"planArc01"}`)
+	assert.NoError(t, err)
+
+	unsigned := http.Header{"Content-Type": {"application/json"}}
+	signed := func(signature string) http.Header {
+		return http.Header{
+			"Content-Type":        {"application/json"},
+			"X-Soracom-Imsi":      {"440103123456789"},
+			"X-Soracom-Key-Id":    {"key-1"},
+			"X-Soracom-Timestamp": {"1700000000000"},
+			"X-Soracom-Signature": {signature},
+		}
+	}
+	assert.Equal(t, []request{
+		{"POST", "/v1/keys/440103123456789/bootstrap", `{}`, unsigned},
+		{"POST", "/v1/keys/440103123456789/verify", `{"res":"a54211d5e3ba50bf"}`, unsigned},
+		// hash of CK, IK, timestamp and body in this order
+		{"POST", "/v1/provisioning/soracom/arc/bootstrap", `{"subscription":"planArc01"}`, signed("8d21483adcc7ba3c31f821e967239b3132f106e0560e4b3fe0cdd5b385484a21")},
+		{"POST", "/v1/provisioning/soracom/arc/bootstrap", `{"subscription":"planArc01"}`, signed("ff729114e0e27b9741bca5900360f673fd071d95")},
+	}, requests)
+}
@@ -0,0 +1,275 @@
+package soratun
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// CaptureFilter selects packets to capture. Empty fields match any packet.
+type CaptureFilter struct {
+	// Protocol is one of "tcp", "udp", or "icmp".
+	Protocol string `json:"protocol,omitempty"`
+	// Host matches source or destination IP address.
+	Host net.IP `json:"host,omitempty"`
+	// Port matches TCP or UDP source or destination port.
+	Port uint16 `json:"port,omitempty"`
+}
+
+// CaptureDirectory is a directory where packet captures are written. Captures are always written here, since the
+// control socket is accessible to a group, and the root "soratun up" process must not write to arbitrary paths. It is
+// on persistent storage rather than /var/run, which is usually tmpfs and would fill memory with a long capture.
+const CaptureDirectory = "/var/lib/soratun/captures"
+
+// CaptureOptions holds parameters for a packet capture on the tunnel.
+type CaptureOptions struct {
+	// Name is the name of pcapng file to write in CaptureDirectory. Rotated files will be named like "out.1.pcap",
+	// "out.2.pcap", and so on.
+	Name string `json:"name"`
+	// Filter selects packets to capture.
+	Filter CaptureFilter `json:"filter"`
+	// MaxFileSize is the size in bytes to rotate the file. 0 means no rotation.
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// MaxFiles is the number of files to keep on rotation, and the oldest one is removed. 0 means no limit.
+	MaxFiles int `json:"maxFiles,omitempty"`
+}
+
+// CaptureStats holds the result of a packet capture.
+type CaptureStats struct {
+	// Packets is the number of captured packets.
+	Packets uint64 `json:"packets"`
+	// Bytes is the number of captured bytes, excluding pcapng overhead.
+	Bytes uint64 `json:"bytes"`
+	// Files holds paths to written files, except ones removed on rotation.
+	Files []string `json:"files"`
+	// Error is the error which stopped the capture before it was requested, e.g. failure to open the rotated file.
+	Error string `json:"error,omitempty"`
+}
+
+// Validate returns an error if the filter has an unknown protocol.
+func (f *CaptureFilter) Validate() error {
+	switch f.Protocol {
+	case "", "tcp", "udp", "icmp":
+		return nil
+	default:
+		return fmt.Errorf("unknown protocol \"%s\", it should be one of tcp, udp, or icmp", f.Protocol)
+	}
+}
+
+func (f *CaptureFilter) match(p *packetInfo) bool {
+	if f.Protocol != "" && f.Protocol != protocolName(p.protocol) {
+		return false
+	}
+	if f.Host != nil && !f.Host.Equal(p.src) && !f.Host.Equal(p.dst) {
+		return false
+	}
+	if f.Port != 0 {
+		if p.protocol != protocolTCP && p.protocol != protocolUDP {
+			return false
+		}
+		if f.Port != p.srcPort && f.Port != p.dstPort {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateCaptureName returns an error if the name is not a plain file name, which could write outside
+// CaptureDirectory.
+func ValidateCaptureName(name string) error {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return fmt.Errorf("capture file name must be a plain file name without directory: %s", name)
+	}
+	return nil
+}
+
+// capture is an ongoing packet capture which writes packets to rotating pcapng files.
+type capture struct {
+	options CaptureOptions
+	dir     string
+	iname   string
+
+	mu      sync.Mutex
+	file    *os.File
+	writer  *pcapngWriter
+	written int64
+	opened  int // number of opened files, which is the index of the next file
+	stats   CaptureStats
+}
+
+func newCapture(dir, iname string, options CaptureOptions) (*capture, error) {
+	if err := options.Filter.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateCaptureName(options.Name); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	c := &capture{options: options, dir: dir, iname: iname}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *capture) open() error {
+	name := c.options.Name
+	if i := c.opened; i > 0 {
+		ext := filepath.Ext(name)
+		name = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	path := filepath.Join(c.dir, name)
+
+	// never overwrite an existing file, or follow a symlink planted in the directory
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+
+	w, n, err := newPcapngWriter(f, c.iname)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	c.file, c.writer, c.written = f, w, int64(n)
+	c.opened++
+	c.stats.Files = append(c.stats.Files, path)
+
+	if c.options.MaxFiles > 0 && len(c.stats.Files) > c.options.MaxFiles {
+		if err := os.Remove(c.stats.Files[0]); err != nil {
+			return err
+		}
+		c.stats.Files = c.stats.Files[1:]
+	}
+	return nil
+}
+
+// fail stops the capture with err, which is reported when the capture is stopped. The caller must hold c.mu.
+func (c *capture) fail(err error) {
+	if c.file != nil {
+		_ = c.file.Close()
+		c.file = nil
+	}
+	c.stats.Error = err.Error()
+}
+
+func (c *capture) write(packet []byte, inbound bool) {
+	p, ok := parsePacket(packet)
+	if !ok || !c.options.Filter.match(p) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return
+	}
+
+	if c.options.MaxFileSize > 0 && c.written >= c.options.MaxFileSize {
+		if err := c.file.Close(); err != nil {
+			c.fail(err)
+			return
+		}
+		c.file = nil
+		if err := c.open(); err != nil {
+			c.fail(fmt.Errorf("failed to rotate capture file: %w", err))
+			return
+		}
+	}
+
+	n, err := c.writer.writePacket(time.Now(), packet, inbound)
+	if err != nil {
+		c.fail(fmt.Errorf("failed to write capture file: %w", err))
+		return
+	}
+	c.written += int64(n)
+	c.stats.Packets++
+	c.stats.Bytes += uint64(len(packet))
+}
+
+func (c *capture) close() (*CaptureStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file != nil {
+		if err := c.file.Close(); err != nil {
+			c.stats.Error = err.Error()
+		}
+		c.file = nil
+	}
+	if c.stats.Error != "" {
+		return &c.stats, fmt.Errorf("%s, %d packets were captured to: %s", c.stats.Error, c.stats.Packets, strings.Join(c.stats.Files, ", "))
+	}
+	return &c.stats, nil
+}
+
+// captureTun wraps tun.Device and copies decrypted inner packets to the ongoing capture, if any.
+type captureTun struct {
+	tun.Device
+	iname   string
+	dir     string // directory to write captures, CaptureDirectory except in tests
+	current atomic.Pointer[capture]
+}
+
+func newCaptureTun(t tun.Device, iname string) *captureTun {
+	return &captureTun{Device: t, iname: iname, dir: CaptureDirectory}
+}
+
+// Read reads outbound packets from the device.
+func (t *captureTun) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := t.Device.Read(bufs, sizes, offset)
+	if c := t.current.Load(); c != nil {
+		for i := 0; i < n; i++ {
+			c.write(bufs[i][offset:offset+sizes[i]], false)
+		}
+	}
+	return n, err
+}
+
+// Write writes inbound packets to the device.
+func (t *captureTun) Write(bufs [][]byte, offset int) (int, error) {
+	if c := t.current.Load(); c != nil {
+		for _, buf := range bufs {
+			c.write(buf[offset:], true)
+		}
+	}
+	return t.Device.Write(bufs, offset)
+}
+
+func (t *captureTun) startCapture(options CaptureOptions) error {
+	if t.current.Load() != nil {
+		return errors.New("capture is already running")
+	}
+
+	c, err := newCapture(t.dir, t.iname, options)
+	if err != nil {
+		return err
+	}
+	if !t.current.CompareAndSwap(nil, c) {
+		_, _ = c.close()
+		return errors.New("capture is already running")
+	}
+	return nil
+}
+
+func (t *captureTun) stopCapture() (*CaptureStats, error) {
+	c := t.current.Swap(nil)
+	if c == nil {
+		return nil, errors.New("capture is not running")
+	}
+	return c.close()
+}
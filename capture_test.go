@@ -0,0 +1,132 @@
+package soratun
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ipv4Packet returns a minimal IPv4 packet with TCP or UDP header.
+func ipv4Packet(protocol uint8, src, dst string, srcPort, dstPort uint16) []byte {
+	b := make([]byte, 40)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[9] = protocol
+	copy(b[12:16], net.ParseIP(src).To4())
+	copy(b[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(b[20:22], srcPort)
+	binary.BigEndian.PutUint16(b[22:24], dstPort)
+	return b
+}
+
+func Test_CaptureFilter(t *testing.T) {
+	p, ok := parsePacket(ipv4Packet(protocolTCP, "10.0.0.1", "100.127.0.1", 40000, 22))
+	assert.True(t, ok)
+
+	assert.True(t, (&CaptureFilter{}).match(p))
+	assert.True(t, (&CaptureFilter{Protocol: "tcp", Host: net.ParseIP("100.127.0.1"), Port: 22}).match(p))
+	assert.False(t, (&CaptureFilter{Protocol: "udp"}).match(p))
+	assert.False(t, (&CaptureFilter{Host: net.ParseIP("10.0.0.2")}).match(p))
+	assert.False(t, (&CaptureFilter{Port: 80}).match(p))
+	assert.Error(t, (&CaptureFilter{Protocol: "sctp"}).Validate())
+
+	_, ok = parsePacket([]byte{0x45, 0x00})
+	assert.False(t, ok)
+}
+
+func Test_captureTun(t *testing.T) {
+	dir := t.TempDir()
+	ct := newCaptureTun(nil, "soratun0")
+	ct.dir = dir
+
+	assert.Error(t, ct.startCapture(CaptureOptions{Name: "../shadow"}))
+	assert.Error(t, ct.startCapture(CaptureOptions{Name: "/etc/shadow"}))
+
+	err := ct.startCapture(CaptureOptions{
+		Name:        "out.pcap",
+		Filter:      CaptureFilter{Protocol: "udp"},
+		MaxFileSize: 200,
+	})
+	assert.NoError(t, err)
+	assert.Error(t, ct.startCapture(CaptureOptions{Name: "another.pcap"}))
+
+	c := ct.current.Load()
+	for i := 0; i < 4; i++ {
+		c.write(ipv4Packet(protocolUDP, "10.0.0.1", "100.127.0.1", 40000, 53), i%2 == 0)
+		c.write(ipv4Packet(protocolTCP, "10.0.0.1", "100.127.0.1", 40000, 22), i%2 == 0)
+	}
+
+	stats, err := ct.stopCapture()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, stats.Packets)
+	assert.EqualValues(t, 160, stats.Bytes)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "out.pcap"),
+		filepath.Join(dir, "out.1.pcap"),
+	}, stats.Files)
+
+	_, err = ct.stopCapture()
+	assert.Error(t, err)
+
+	b, err := os.ReadFile(stats.Files[0])
+	assert.NoError(t, err)
+	assert.EqualValues(t, pcapngSectionHeaderBlock, binary.LittleEndian.Uint32(b[0:4]))
+	assert.EqualValues(t, pcapngByteOrderMagic, binary.LittleEndian.Uint32(b[8:12]))
+
+	// walk blocks and count enhanced packet blocks
+	packets := 0
+	for offset := 0; offset < len(b); {
+		length := int(binary.LittleEndian.Uint32(b[offset+4 : offset+8]))
+		assert.Equal(t, length, int(binary.LittleEndian.Uint32(b[offset+length-4:offset+length])))
+		if binary.LittleEndian.Uint32(b[offset:offset+4]) == pcapngEnhancedPacketBlock {
+			packets++
+		}
+		offset += length
+	}
+	assert.Positive(t, packets)
+
+	// existing files and symlinks are never overwritten
+	target := filepath.Join(t.TempDir(), "target")
+	assert.NoError(t, os.WriteFile(target, []byte("secret"), 0600))
+	assert.NoError(t, os.Symlink(target, filepath.Join(dir, "link.pcap")))
+	assert.Error(t, ct.startCapture(CaptureOptions{Name: "link.pcap"}))
+	assert.Error(t, ct.startCapture(CaptureOptions{Name: "out.pcap"}))
+	b, err = os.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(b))
+}
+
+func Test_captureTun_rotation(t *testing.T) {
+	dir := t.TempDir()
+	ct := newCaptureTun(nil, "soratun0")
+	ct.dir = dir
+	packet := ipv4Packet(protocolUDP, "10.0.0.1", "100.127.0.1", 40000, 53)
+
+	// each packet goes to a new file, and only the last 2 files are kept
+	assert.NoError(t, ct.startCapture(CaptureOptions{Name: "ring.pcap", MaxFileSize: 1, MaxFiles: 2}))
+	c := ct.current.Load()
+	for i := 0; i < 3; i++ {
+		c.write(packet, false)
+	}
+	stats, err := ct.stopCapture()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "ring.2.pcap"), filepath.Join(dir, "ring.3.pcap")}, stats.Files)
+	assert.NoFileExists(t, filepath.Join(dir, "ring.pcap"))
+	assert.NoFileExists(t, filepath.Join(dir, "ring.1.pcap"))
+
+	// failure to open the rotated file stops the capture, and is reported on stop
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "fail.2.pcap"), nil, 0600))
+	assert.NoError(t, ct.startCapture(CaptureOptions{Name: "fail.pcap", MaxFileSize: 1}))
+	c = ct.current.Load()
+	for i := 0; i < 3; i++ {
+		c.write(packet, false)
+	}
+	stats, err = ct.stopCapture()
+	assert.ErrorContains(t, err, "failed to rotate capture file")
+	assert.Contains(t, stats.Error, "fail.2.pcap")
+	assert.EqualValues(t, 1, stats.Packets)
+}
@@ -1,13 +1,17 @@
 package soratun
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
-	"os"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/soracom/soratun/internal"
 )
@@ -18,19 +22,46 @@ import (
 //
 //go:generate mockgen -source client.go -destination internal/mock/client.go
 type SoracomClient interface {
-	CreateVirtualSim() (*VirtualSim, error)
-	CreateArcSession(simId, publicKey string) (*ArcSession, error)
+	CreateVirtualSim(ctx context.Context, subscription string) (*VirtualSim, error)
+	CreateArcSession(ctx context.Context, simId, publicKey string) (*ArcSession, error)
+	DeleteArcSession(ctx context.Context, simId string) error
+	ListSims(ctx context.Context, limit int, lastEvaluatedKey string) ([]*VirtualSim, string, error)
+	GetSim(ctx context.Context, simId string) (*VirtualSim, error)
+	SetSimName(ctx context.Context, simId, name string) (*VirtualSim, error)
+	SetSimTags(ctx context.Context, simId string, tags map[string]string) (*VirtualSim, error)
+	SetSimGroup(ctx context.Context, simId, groupId string) (*VirtualSim, error)
+	SuspendSim(ctx context.Context, simId string) (*VirtualSim, error)
+	EnableSimTermination(ctx context.Context, simId string) (*VirtualSim, error)
+	TerminateSim(ctx context.Context, simId string) (*VirtualSim, error)
 	SetVerbose(v bool)
 	Verbose() bool
+	TokenTimeout() time.Duration
 }
 
-// DefaultSoracomClient is an implementation of the SoracomClient for the general use case.
+const (
+	// DefaultTokenTimeoutSeconds is the lifetime of SORACOM API token if Profile.TokenTimeoutSeconds is not set.
+	DefaultTokenTimeoutSeconds = 5 * 60
+	// minTokenTimeoutSeconds and maxTokenTimeoutSeconds are the range SORACOM API accepts.
+	minTokenTimeoutSeconds = 180
+	maxTokenTimeoutSeconds = 172800
+	// tokenRefreshMargin is the time before expiry to re-authenticate, so that a token never expires during a request.
+	tokenRefreshMargin = time.Minute
+)
+
+// DefaultSoracomClient is an implementation of the SoracomClient for the general use case. It re-authenticates
+// before the token expires, or when the API responds with 401.
 type DefaultSoracomClient struct {
-	apiKey   string       // SORACOM API key.
-	token    string       // SORACOM API token.
-	endpoint string       // SORACOM API endpoint.
-	client   *http.Client // HTTP client.
-	verbose  bool
+	authKeyId    string        // SORACOM API auth key ID.
+	authKey      string        // SORACOM API auth key secret.
+	tokenTimeout time.Duration // Lifetime of SORACOM API token.
+	endpoint     string        // SORACOM API endpoint.
+	transport    *apiTransport // HTTP client with retries.
+	verbose      bool
+
+	mu        sync.Mutex // serializes authentication, and guards following credentials.
+	apiKey    string     // SORACOM API key.
+	token     string     // SORACOM API token.
+	expiresAt time.Time  // Time when the token expires.
 }
 
 // A Profile holds SORACOM API client related information.
@@ -41,12 +72,20 @@ type Profile struct {
 	AuthKeyID string `json:"authKeyId,omitempty"`
 	// Endpoint is SORACOM API endpoint.
 	Endpoint string `json:"endpoint,omitempty"`
+	// TokenTimeoutSeconds is the lifetime of SORACOM API token. Defaults to DefaultTokenTimeoutSeconds.
+	TokenTimeoutSeconds int `json:"tokenTimeoutSeconds,omitempty"`
+	// Retry controls timeouts and retries of SORACOM API requests.
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Transport controls TLS and proxy settings to connect to SORACOM API.
+	Transport *TransportConfig `json:"transport,omitempty"`
 }
 
 type apiParams struct {
 	body   string
 	method string
 	path   string
+	// idempotent is true if the request can be safely retried after the server might have executed it.
+	idempotent bool
 }
 
 // VirtualSim represents virtual subscriber.
@@ -57,6 +96,10 @@ type VirtualSim struct {
 	Status string `json:"status"`
 	// SimId is SIM ID of the subscriber.
 	SimId string `json:"simId"`
+	// Tags holds tags of the SIM. "name" tag is displayed as the name in SORACOM User Console.
+	Tags map[string]string `json:"tags"`
+	// GroupId is ID of the group which the SIM belongs to, if any.
+	GroupId string `json:"groupId"`
 	// ArcSession holds Arc connection information.
 	ArcSession ArcSession `json:"arcSessionStatus"`
 	// Profiles holds series of SimProfile, (not SORACOM API Profile).
@@ -75,8 +118,8 @@ type SimProfile struct {
 	PrimaryImsi string `json:"primaryImsi"`
 }
 
-// NewDefaultSoracomClient returns new SoracomClient for caller.
-func NewDefaultSoracomClient(p Profile) (SoracomClient, error) {
+// NewDefaultSoracomClient returns new SoracomClient for caller, after authenticating with ctx.
+func NewDefaultSoracomClient(ctx context.Context, p Profile) (SoracomClient, error) {
 	authKeyId := p.AuthKeyID
 	if authKeyId == "" || !strings.HasPrefix(authKeyId, "keyId-") {
 		return nil, fmt.Errorf("invalid AuthKeyId is provided. It must starts with \"keyId-\"")
@@ -92,12 +135,48 @@ func NewDefaultSoracomClient(p Profile) (SoracomClient, error) {
 		endpoint = "https://api.soracom.io"
 	}
 
+	tokenTimeoutSeconds := p.TokenTimeoutSeconds
+	if tokenTimeoutSeconds == 0 {
+		tokenTimeoutSeconds = DefaultTokenTimeoutSeconds
+	}
+	if tokenTimeoutSeconds < minTokenTimeoutSeconds || tokenTimeoutSeconds > maxTokenTimeoutSeconds {
+		return nil, fmt.Errorf("invalid TokenTimeoutSeconds is provided. It must be between %d and %d", minTokenTimeoutSeconds, maxTokenTimeoutSeconds)
+	}
+
+	transport, err := newAPITransport(p.Retry, p.Transport)
+	if err != nil {
+		return nil, err
+	}
+
 	c := DefaultSoracomClient{
-		apiKey:   "",
-		token:    "",
-		endpoint: endpoint,
-		client:   http.DefaultClient,
-		verbose:  false,
+		authKeyId:    authKeyId,
+		authKey:      authKey,
+		tokenTimeout: time.Duration(tokenTimeoutSeconds) * time.Second,
+		endpoint:     endpoint,
+		transport:    transport,
+		verbose:      false,
+	}
+
+	if _, _, err := c.credentials(ctx, ""); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// TokenTimeout returns the lifetime of SORACOM API token.
+func (c *DefaultSoracomClient) TokenTimeout() time.Duration {
+	return c.tokenTimeout
+}
+
+// credentials returns API key and token, after re-authenticating if the token expires soon or the token is the same
+// as rejected, which is the token used for a request which got 401. Concurrent callers wait for a single
+// authentication.
+func (c *DefaultSoracomClient) credentials(ctx context.Context, rejected string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && c.token != rejected && time.Now().Before(c.expiresAt.Add(-tokenRefreshMargin)) {
+		return c.apiKey, c.token, nil
 	}
 
 	body, err := json.Marshal(struct {
@@ -105,34 +184,39 @@ func NewDefaultSoracomClient(p Profile) (SoracomClient, error) {
 		AuthKey             string `json:"authKey"`
 		TokenTimeoutSeconds int    `json:"tokenTimeoutSeconds"`
 	}{
-		AuthKeyID:           authKeyId,
-		AuthKey:             authKey,
-		TokenTimeoutSeconds: 5 * 60,
+		AuthKeyID:           c.authKeyId,
+		AuthKey:             c.authKey,
+		TokenTimeoutSeconds: int(c.tokenTimeout.Seconds()),
 	})
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	res, err := c.callAPI(&apiParams{
-		method: "POST",
-		path:   "/auth",
-		body:   string(body),
-	})
+	issuedAt := time.Now()
+	// issuing another token is harmless
+	res, err := c.send(ctx, &apiParams{
+		method:     "POST",
+		path:       "/auth",
+		body:       string(body),
+		idempotent: true,
+	}, "", "")
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
 
 	ar := struct {
 		APIKey string `json:"apiKey"`
 		Token  string `json:"token"`
 	}{}
 	if err := json.NewDecoder(res.Body).Decode(&ar); err != nil {
-		return nil, fmt.Errorf("failed to decode auth response: %w", err)
+		return "", "", fmt.Errorf("failed to decode auth response: %w", err)
 	}
 
-	c.apiKey = ar.APIKey
-	c.token = ar.Token
-	return &c, nil
+	c.apiKey, c.token, c.expiresAt = ar.APIKey, ar.Token, issuedAt.Add(c.tokenTimeout)
+	return c.apiKey, c.token, nil
 }
 
 // SetVerbose sets if verbose output is enabled or not.
@@ -145,20 +229,24 @@ func (c *DefaultSoracomClient) Verbose() bool {
 	return c.verbose
 }
 
-// CreateVirtualSim creates new virtual SIM.
-func (c *DefaultSoracomClient) CreateVirtualSim() (*VirtualSim, error) {
+// CreateVirtualSim creates new virtual SIM with the subscription plan, or DefaultSubscription if it is empty. It is
+// never retried unless the server rejects the request with 429, to avoid creating two virtual SIMs.
+func (c *DefaultSoracomClient) CreateVirtualSim(ctx context.Context, subscription string) (*VirtualSim, error) {
+	if subscription == "" {
+		subscription = DefaultSubscription
+	}
 	body, err := json.Marshal(struct {
 		Type         string `json:"type"`
 		Subscription string `json:"subscription"`
 	}{
 		Type:         "virtual",
-		Subscription: "planArc01",
+		Subscription: subscription,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := c.callAPI(&apiParams{
+	res, err := c.callAPI(ctx, &apiParams{
 		method: "POST",
 		path:   "/sims",
 		body:   string(body),
@@ -166,46 +254,238 @@ func (c *DefaultSoracomClient) CreateVirtualSim() (*VirtualSim, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
 
+	// the SIM is created even if the response can't be decoded, so the raw body is returned with the error
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, &invalidResponseError{body: b, err: err}
+	}
 	var subscriber VirtualSim
-	err = json.NewDecoder(res.Body).Decode(&subscriber)
-	return &subscriber, err
+	if err := json.Unmarshal(b, &subscriber); err != nil {
+		return nil, &invalidResponseError{body: b, err: err}
+	}
+	return &subscriber, nil
 }
 
-// CreateArcSession creates new Arc session.
-func (c *DefaultSoracomClient) CreateArcSession(simId, publicKey string) (*ArcSession, error) {
-	// bootstrapped SIM will have attached credential. So we can just sent empty object.
-	res, err := c.callAPI(&apiParams{
-		method: "POST",
-		path:   "/sims/" + simId + "/sessions/arc",
-		body:   "{}",
+// CreateArcSession creates new Arc session with the public key. If publicKey is empty, SORACOM generates a key pair and
+// returns the private key in the session.
+func (c *DefaultSoracomClient) CreateArcSession(ctx context.Context, simId, publicKey string) (*ArcSession, error) {
+	body, err := json.Marshal(struct {
+		ArcClientPeerPublicKey string `json:"arcClientPeerPublicKey,omitempty"`
+	}{
+		ArcClientPeerPublicKey: publicKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a retried request replaces the session, and the last one is returned
+	res, err := c.callAPI(ctx, &apiParams{
+		method:     "POST",
+		path:       "/sims/" + url.PathEscape(simId) + "/sessions/arc",
+		body:       string(body),
+		idempotent: true,
 	})
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
 
 	var session ArcSession
 	err = json.NewDecoder(res.Body).Decode(&session)
 	return &session, err
 }
 
-func (c *DefaultSoracomClient) callAPI(params *apiParams) (*http.Response, error) {
-	req, err := c.makeRequest(params)
+// DeleteArcSession deletes Arc session of the SIM. The device can't connect to SORACOM Arc until a new session is
+// created.
+func (c *DefaultSoracomClient) DeleteArcSession(ctx context.Context, simId string) error {
+	res, err := c.callAPI(ctx, &apiParams{
+		method:     "DELETE",
+		path:       "/sims/" + url.PathEscape(simId) + "/sessions/arc",
+		idempotent: true,
+	})
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// ListSims returns up to limit SIMs after lastEvaluatedKey, and the key to get the next page. The key is empty if
+// there are no more SIMs.
+func (c *DefaultSoracomClient) ListSims(ctx context.Context, limit int, lastEvaluatedKey string) ([]*VirtualSim, string, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if lastEvaluatedKey != "" {
+		q.Set("last_evaluated_key", lastEvaluatedKey)
+	}
+	path := "/sims"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	res, err := c.callAPI(ctx, &apiParams{
+		method:     "GET",
+		path:       path,
+		idempotent: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var sims []*VirtualSim
+	if err := json.NewDecoder(res.Body).Decode(&sims); err != nil {
+		return nil, "", err
+	}
+	return sims, res.Header.Get("X-Soracom-Next-Key"), nil
+}
+
+// GetSim returns the SIM.
+func (c *DefaultSoracomClient) GetSim(ctx context.Context, simId string) (*VirtualSim, error) {
+	return c.callSimAPI(ctx, &apiParams{
+		method:     "GET",
+		path:       "/sims/" + url.PathEscape(simId),
+		idempotent: true,
+	})
+}
+
+// SetSimName sets "name" tag of the SIM.
+func (c *DefaultSoracomClient) SetSimName(ctx context.Context, simId, name string) (*VirtualSim, error) {
+	return c.SetSimTags(ctx, simId, map[string]string{"name": name})
+}
+
+// SetSimTags adds or updates tags of the SIM. Other tags are kept as they are.
+func (c *DefaultSoracomClient) SetSimTags(ctx context.Context, simId string, tags map[string]string) (*VirtualSim, error) {
+	type tag struct {
+		TagName  string `json:"tagName"`
+		TagValue string `json:"tagValue"`
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	body := make([]tag, 0, len(tags))
+	for _, name := range names {
+		body = append(body, tag{TagName: name, TagValue: tags[name]})
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.callSimAPI(ctx, &apiParams{
+		method:     "PUT",
+		path:       "/sims/" + url.PathEscape(simId) + "/tags",
+		body:       string(b),
+		idempotent: true,
+	})
+}
+
+// SetSimGroup moves the SIM to the group, or removes the SIM from its group if groupId is empty.
+func (c *DefaultSoracomClient) SetSimGroup(ctx context.Context, simId, groupId string) (*VirtualSim, error) {
+	if groupId == "" {
+		return c.callSimAPI(ctx, &apiParams{
+			method:     "POST",
+			path:       "/sims/" + url.PathEscape(simId) + "/unset_group",
+			idempotent: true,
+		})
+	}
+
+	body, err := json.Marshal(struct {
+		GroupId string `json:"groupId"`
+	}{
+		GroupId: groupId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.callSimAPI(ctx, &apiParams{
+		method:     "POST",
+		path:       "/sims/" + url.PathEscape(simId) + "/set_group",
+		body:       string(body),
+		idempotent: true,
+	})
+}
+
+// SuspendSim suspends the SIM. It is not retried since the API rejects suspending a suspended SIM.
+func (c *DefaultSoracomClient) SuspendSim(ctx context.Context, simId string) (*VirtualSim, error) {
+	return c.callSimAPI(ctx, &apiParams{
+		method: "POST",
+		path:   "/sims/" + url.PathEscape(simId) + "/suspend",
+	})
+}
+
+// EnableSimTermination disables termination protection of the SIM, so it can be terminated with TerminateSim.
+func (c *DefaultSoracomClient) EnableSimTermination(ctx context.Context, simId string) (*VirtualSim, error) {
+	return c.callSimAPI(ctx, &apiParams{
+		method:     "POST",
+		path:       "/sims/" + url.PathEscape(simId) + "/enable_termination",
+		idempotent: true,
+	})
+}
+
+// TerminateSim terminates the SIM. The SIM can't be used anymore, and termination protection must be disabled
+// beforehand with EnableSimTermination.
+func (c *DefaultSoracomClient) TerminateSim(ctx context.Context, simId string) (*VirtualSim, error) {
+	return c.callSimAPI(ctx, &apiParams{
+		method: "POST",
+		path:   "/sims/" + url.PathEscape(simId) + "/terminate",
+	})
+}
+
+// callSimAPI calls the API which returns a SIM.
+func (c *DefaultSoracomClient) callSimAPI(ctx context.Context, params *apiParams) (*VirtualSim, error) {
+	res, err := c.callAPI(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var sim VirtualSim
+	err = json.NewDecoder(res.Body).Decode(&sim)
+	return &sim, err
+}
+
+// callAPI calls the API with current credentials. If the API responds with 401, e.g. the token was revoked, callAPI
+// re-authenticates and retries once.
+func (c *DefaultSoracomClient) callAPI(ctx context.Context, params *apiParams) (*http.Response, error) {
+	apiKey, token, err := c.credentials(ctx, "")
 	if err != nil {
 		return nil, err
 	}
 
-	if c.Verbose() {
-		fmt.Fprintln(os.Stderr, "--- Request dump ---------------------------------")
-		r, _ := httputil.DumpRequest(req, true)
-		fmt.Fprintln(os.Stderr, string(r))
-		fmt.Fprintln(os.Stderr, "--- End of request dump --------------------------")
+	res, err := c.send(ctx, params, apiKey, token)
+	if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
 	}
-	res, err := c.doRequest(req)
-	return res, err
+
+	if apiKey, token, err = c.credentials(ctx, token); err != nil {
+		return nil, err
+	}
+	return c.send(ctx, params, apiKey, token)
 }
 
-func (c *DefaultSoracomClient) makeRequest(params *apiParams) (*http.Request, error) {
+func (c *DefaultSoracomClient) send(ctx context.Context, params *apiParams, apiKey, token string) (*http.Response, error) {
+	return c.transport.do(ctx, func() (*http.Request, error) {
+		return c.makeRequest(params, apiKey, token)
+	}, params.idempotent, c.Verbose())
+}
+
+func (c *DefaultSoracomClient) makeRequest(params *apiParams, apiKey, token string) (*http.Request, error) {
 	var body io.Reader
 	if params.body != "" {
 		body = strings.NewReader(params.body)
@@ -221,37 +501,11 @@ func (c *DefaultSoracomClient) makeRequest(params *apiParams) (*http.Request, er
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Soracom-Lang", "en")
 	req.Header.Set("User-Agent", internal.UserAgent)
-	if c.apiKey != "" {
-		req.Header.Set("X-Soracom-Api-Key", c.apiKey)
+	if apiKey != "" {
+		req.Header.Set("X-Soracom-Api-Key", apiKey)
 	}
-	if c.token != "" {
-		req.Header.Set("X-Soracom-Token", c.token)
+	if token != "" {
+		req.Header.Set("X-Soracom-Token", token)
 	}
 	return req, nil
 }
-
-func (c *DefaultSoracomClient) doRequest(req *http.Request) (*http.Response, error) {
-	res, err := c.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	if c.Verbose() && res != nil {
-		fmt.Fprintln(os.Stderr, "--- Response dump --------------------------------")
-		r, _ := httputil.DumpResponse(res, true)
-		fmt.Fprintln(os.Stderr, string(r))
-		fmt.Fprintln(os.Stderr, "--- End of response dump -------------------------")
-	}
-
-	if res.StatusCode >= http.StatusBadRequest {
-		defer func() {
-			err := res.Body.Close()
-			if err != nil {
-				fmt.Println("failed to close response", err)
-			}
-		}()
-		r, _ := io.ReadAll(res.Body)
-		return res, fmt.Errorf("%s: %s %s: %s", res.Status, req.Method, req.URL, r)
-	}
-	return res, nil
-}
@@ -0,0 +1,131 @@
+package soratun
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthTestServer returns a server which issues a new token for each /auth request, and accepts only the latest
+// token for other requests.
+func newAuthTestServer(t *testing.T, auths *atomic.Int32) *httptest.Server {
+	var mu sync.Mutex
+	var latest string
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == "/v1/auth" {
+			latest = fmt.Sprintf("token-%d", auths.Add(1))
+			fmt.Fprintf(w, `{"apiKey":"api-key","token":"%s"}`, latest)
+			return
+		}
+		if r.Header.Get("X-Soracom-Token") != latest {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"simId":"8942310022000000000"}`)
+	}))
+}
+
+func Test_DefaultSoracomClient_refresh(t *testing.T) {
+	var auths atomic.Int32
+	s := newAuthTestServer(t, &auths)
+	defer s.Close()
+
+	client, err := NewDefaultSoracomClient(context.Background(), Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, client.TokenTimeout())
+	assert.EqualValues(t, 1, auths.Load())
+
+	c := client.(*DefaultSoracomClient)
+
+	// token is reused until it expires
+	sim, err := c.CreateVirtualSim(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "8942310022000000000", sim.SimId)
+	assert.EqualValues(t, 1, auths.Load())
+
+	// token which expires soon is refreshed once, even with concurrent requests
+	c.expiresAt = time.Now().Add(tokenRefreshMargin / 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.CreateVirtualSim(context.Background(), "")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 2, auths.Load())
+
+	// revoked token is refreshed on 401
+	c.token = "revoked"
+	_, err = c.CreateVirtualSim(context.Background(), "")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, auths.Load())
+
+	_, err = NewDefaultSoracomClient(context.Background(), Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL, TokenTimeoutSeconds: 60})
+	assert.Error(t, err)
+}
+
+func Test_DefaultSoracomClient_ListSims(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/auth":
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+		case r.URL.Path == "/v1/sims" && r.URL.Query().Get("last_evaluated_key") == "":
+			assert.Equal(t, "1", r.URL.Query().Get("limit"))
+			w.Header().Set("X-Soracom-Next-Key", "8942310022000000000")
+			fmt.Fprint(w, `[{"simId":"8942310022000000000","tags":{"name":"sensor-1"}}]`)
+		case r.URL.Path == "/v1/sims":
+			assert.Equal(t, "8942310022000000000", r.URL.Query().Get("last_evaluated_key"))
+			fmt.Fprint(w, `[{"simId":"8942310022000000001","groupId":"group-1"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	client, err := NewDefaultSoracomClient(context.Background(), Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL})
+	assert.NoError(t, err)
+
+	sims, next, err := client.ListSims(context.Background(), 1, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "8942310022000000000", next)
+	assert.Equal(t, "sensor-1", sims[0].Tags["name"])
+
+	sims, next, err = client.ListSims(context.Background(), 1, next)
+	assert.NoError(t, err)
+	assert.Equal(t, "", next)
+	assert.Equal(t, "group-1", sims[0].GroupId)
+}
+
+func Test_DefaultSoracomClient_CreateArcSession(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/v1/auth":
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+		case "/v1/sims/sim%2F1/sessions/arc":
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010","arcClientPeerIpAddress":"10.0.0.1"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	client, err := NewDefaultSoracomClient(context.Background(), Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL})
+	assert.NoError(t, err)
+
+	session, err := client.CreateArcSession(context.Background(), "sim/1", "public-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", session.ArcClientPeerIpAddress.String())
+}
@@ -1,15 +1,30 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/soracom/soratun"
 	"github.com/spf13/cobra"
 )
 
+var (
+	retryMaxAttempts int
+	requestTimeout   time.Duration
+	bootstrapTimeout time.Duration
+	configTemplate   string
+
+	caCertificatePath     string
+	pinnedPublicKeys      []string
+	clientCertificatePath string
+	clientKeyPath         string
+	proxyURL              string
+)
+
 func bootstrapCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "bootstrap",
@@ -28,8 +43,19 @@ sim      current SIM             SIM Authentication   Compatible modem/SIM card
 		Args: cobra.NoArgs,
 	}
 
+	cmd.PersistentFlags().StringVar(&configTemplate, "config-template", "", "Path to configuration file whose fields except keys, SIM ID, profile and SORACOM Arc session, e.g. interface, MTU, hooks and additional allowed IPs, are used for a new configuration. Ignored if the configuration file already exists")
+	cmd.PersistentFlags().DurationVar(&bootstrapTimeout, "timeout", 5*time.Minute, "Give up bootstrap after this duration, including retries. 0 means no timeout")
+	cmd.PersistentFlags().IntVar(&retryMaxAttempts, "max-attempts", soratun.DefaultRetryMaxAttempts, "Maximum number of attempts for each API request, 1 disables retries")
+	cmd.PersistentFlags().DurationVar(&requestTimeout, "request-timeout", soratun.DefaultRequestTimeoutSeconds*time.Second, "Timeout of each API request attempt, 1s or longer, rounded up to seconds")
+	cmd.PersistentFlags().StringVar(&caCertificatePath, "ca-certificate", "", "Path to PEM encoded CA certificates to trust in addition to the system roots")
+	cmd.PersistentFlags().StringSliceVar(&pinnedPublicKeys, "pinned-public-key", nil, "Base64 encoded SHA-256 hash of SubjectPublicKeyInfo which the server certificate chain must have. Can be specified multiple times")
+	cmd.PersistentFlags().StringVar(&clientCertificatePath, "client-certificate", "", "Path to PEM encoded client certificate for mutual TLS")
+	cmd.PersistentFlags().StringVar(&clientKeyPath, "client-key", "", "Path to PEM encoded private key of the client certificate")
+	cmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy URL for API requests, which overrides HTTPS_PROXY environment variable")
+
 	cmd.AddCommand(bootstrapAuthKeyCmd())
 	cmd.AddCommand(bootstrapCellularCmd())
+	cmd.AddCommand(bootstrapResumeCmd())
 	cmd.AddCommand(bootstrapSimCmd())
 
 	return cmd
@@ -40,32 +66,76 @@ func bootstrap(bootstrapper soratun.Bootstrapper) error {
 	var currentConfig *soratun.Config = nil
 
 	if !dumpConfig {
-		// Won't check error from `readConfig` because:
-		//
-		// 1. In the very first run, which means no `arc.json` in the file system, the `readConfig` always fail.
-		//    We should move bootstrapping process forward.
-		// 2. Also bootstrap process—creating a new virtual SIM—should be finished successfully regardless of error
-		//    (read failure, invalid JSON format, etc.) Once failed (= `currentCOnfig` is `nil`), Bootstrapper#Execute
-		//    will create a fresh `soratun.Config` (it will vary on each bootstrap method) and can move the process
-		//    forward. Bootstrapper will update existing configuration.
-		currentConfig, _ = readConfig(configPath)
-	}
-
-	config, err := bootstrapper.Execute(currentConfig)
+		// In the very first run, which means no `arc.json` in the file system, Bootstrapper#Execute will create a fresh
+		// `soratun.Config` (it will vary on each bootstrap method). Any other error, e.g. sealed configuration without
+		// a key, fails here, or the existing configuration would be replaced with a new virtual SIM.
+		var err error
+		currentConfig, err = readExistingConfig(configPath)
+		if err != nil {
+			return err
+		}
+	}
+	if currentConfig == nil {
+		var err error
+		currentConfig, err = readConfigTemplate()
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := bootstrapContext()
+	defer cancel()
+
+	config, err := bootstrapper.Execute(ctx, currentConfig)
 	if err != nil {
 		return err
 	}
 
-	b, err := json.MarshalIndent(config, "", "  ")
+	return saveBootstrappedConfig(config)
+}
+
+// readConfigTemplate returns a new configuration from "--config-template", or nil if it is not specified.
+func readConfigTemplate() (*soratun.Config, error) {
+	if configTemplate == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(configTemplate)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to read config template: %w", err)
+	}
+	config, err := soratun.NewConfigFromTemplate(b)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading config template %s: %w", configTemplate, err)
 	}
+	return config, nil
+}
+
+// bootstrapContext returns a context which is cancelled with Ctrl-C, SIGTERM, or "--timeout", to abort in-flight API
+// requests and krypton-cli, instead of leaving them hung.
+func bootstrapContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signalContext()
+	if bootstrapTimeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
 
+// saveBootstrappedConfig saves the configuration to the path specified with "--config" flag, and removes the bootstrap
+// journal, or prints the configuration with "--dump-config".
+func saveBootstrappedConfig(config *soratun.Config) error {
+	// secrets loaded from references are written back as the references
 	if !dumpConfig {
-		err = writeConfigurationToFile(string(b))
+		err := soratun.WriteConfigFile(configPath, config)
 		if err != nil {
 			return err
 		}
+		if err := soratun.RemoveBootstrapJournal(soratun.BootstrapJournalPath(configPath)); err != nil {
+			return err
+		}
 
 		if config.SimId != "" {
 			fmt.Printf("Virtual subscriber SIM ID: %s\n", config.SimId)
@@ -73,12 +143,51 @@ func bootstrap(bootstrapper soratun.Bootstrapper) error {
 
 		printConfigurationFilePath()
 	} else {
+		b, err := soratun.MarshalConfig(config)
+		if err != nil {
+			return err
+		}
 		fmt.Println(string(b))
 	}
 
 	return nil
 }
 
+// retryConfigFromFlags returns retry configuration if any retry flag is set explicitly, or nil to use configured or
+// default values.
+func retryConfigFromFlags(cmd *cobra.Command) *soratun.RetryConfig {
+	if !cmd.Flags().Changed("max-attempts") && !cmd.Flags().Changed("request-timeout") {
+		return nil
+	}
+	// timeout is configured in seconds, so a shorter one would be truncated to 0 and fall back to the default
+	if requestTimeout < time.Second {
+		log.Fatalf("\"--request-timeout\" should be 1s or longer")
+	}
+	return &soratun.RetryConfig{
+		MaxAttempts:    retryMaxAttempts,
+		TimeoutSeconds: int((requestTimeout + time.Second - 1) / time.Second),
+	}
+}
+
+// transportConfigFromFlags returns transport configuration if any transport flag is set, or nil to use configured or
+// default values.
+func transportConfigFromFlags(cmd *cobra.Command) *soratun.TransportConfig {
+	changed := false
+	for _, name := range []string{"ca-certificate", "pinned-public-key", "client-certificate", "client-key", "proxy"} {
+		changed = changed || cmd.Flags().Changed(name)
+	}
+	if !changed {
+		return nil
+	}
+	return &soratun.TransportConfig{
+		CACertificatePath:     caCertificatePath,
+		PinnedPublicKeys:      pinnedPublicKeys,
+		ClientCertificatePath: clientCertificatePath,
+		ClientKeyPath:         clientKeyPath,
+		ProxyURL:              proxyURL,
+	}
+}
+
 func printConfigurationFilePath() {
 	path, err := filepath.Abs(configPath)
 	if err != nil {
@@ -4,40 +4,75 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/manifoldco/promptui"
 	"github.com/soracom/soratun"
 	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+// environment variables which provide inputs of "bootstrap authkey" instead of flags
+const (
+	envAuthKeyId    = "SORACOM_AUTH_KEY_ID"
+	envAuthKey      = "SORACOM_AUTH_KEY"
+	envCoverageType = "SORACOM_COVERAGE_TYPE"
 )
 
 var (
-	authKeyId string
-	authKey   string
-	coverage  string
-	endpoints = []string{"https://g.api.soracom.io", "https://api.soracom.io"}
+	authKeyId       string
+	authKey         string
+	coverage        string
+	simName         string
+	simTags         map[string]string
+	simGroupId      string
+	simSubscription string
+	bootstrapSimId  string
+	selectSim       bool
+	answersPath     string
+	nonInteractive  bool
+	endpoints       = []string{"https://g.api.soracom.io", "https://api.soracom.io"}
 )
 
 func bootstrapAuthKeyCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "authkey",
 		Short: "Create standalone virtual SIM with SORACOM API AuthKey",
-		Long:  "This command will create a new virtual SIM which is not associated with any physical SIM, then create configuration for soratun. If configuration file (arc.json by default, or specified by --config flag) contains \"profile\", that information will be used. Else if insufficient or no flags is provided, the command will guide your setup through interactive wizard.",
+		Long:  "This command will create a new virtual SIM which is not associated with any physical SIM, then create configuration for soratun. If configuration file (arc.json by default, or specified by --config flag) contains \"profile\", that information will be used. Else inputs are taken from flags, environment variables (SORACOM_AUTH_KEY_ID, SORACOM_AUTH_KEY and SORACOM_COVERAGE_TYPE), or the YAML file specified by --answers flag, in this order. If insufficient inputs are provided, the command will guide your setup through interactive wizard, or fails with the list of missing inputs if stdin is not a terminal or --non-interactive flag is set.",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			var err error
 			var profile *soratun.Profile
 
 			// reuse current profile information
-			currentConfig, err := readConfig(configPath)
-			if err == nil && currentConfig != nil && currentConfig.Profile != nil {
+			currentConfig, err := readExistingConfig(configPath)
+			if err != nil {
+				log.Fatalf("Error while setup: %v\n", err)
+			}
+			if currentConfig != nil && currentConfig.Profile != nil {
 				profile = currentConfig.Profile
 			}
 
+			answers := &bootstrapAnswers{}
+			if answersPath != "" {
+				answers, err = readBootstrapAnswers(answersPath)
+				if err != nil {
+					log.Fatalf("Failed to read answers file: %v", err)
+				}
+			}
+			interactive := !nonInteractive && isTerminal(os.Stdin)
+
 			if profile == nil {
-				if cmd.Flag("auth-key-id").Value.String() == "" ||
-					cmd.Flag("auth-key").Value.String() == "" ||
-					cmd.Flag("coverage-type").Value.String() == "" {
+				resolveInput(cmd, "auth-key-id", &authKeyId, envAuthKeyId, answers.AuthKeyId)
+				resolveInput(cmd, "auth-key", &authKey, envAuthKey, answers.AuthKey)
+				resolveInput(cmd, "coverage-type", &coverage, envCoverageType, answers.CoverageType)
+
+				if missing := missingProfileInputs(); len(missing) > 0 {
+					if !interactive {
+						log.Fatalf("Not enough information to bootstrap without a terminal, missing: %s", strings.Join(missing, ", "))
+					}
 					fmt.Println("Not enough information to bootstrap. Launching wizard.")
 					profile, err = collectProfileInformationInteractive()
 					if err != nil {
@@ -51,20 +86,255 @@ func bootstrapAuthKeyCmd() *cobra.Command {
 				}
 			}
 
-			err = bootstrap(&soratun.AuthKeyBootstrapper{Profile: profile})
+			var options *soratun.SimOptions
+			if currentConfig != nil {
+				options = currentConfig.SimOptions
+			} else {
+				template, err := readConfigTemplate()
+				if err != nil {
+					log.Fatalf("Error while setup: %v\n", err)
+				}
+				if template != nil {
+					options = template.SimOptions
+				}
+			}
+			options = answers.simOptions(options)
+			if !cmd.Flags().Changed("sim-id") && answers.SimId != "" {
+				bootstrapSimId = answers.SimId
+			}
+
+			bootstrapper := &soratun.AuthKeyBootstrapper{
+				Profile:     profile,
+				Retry:       retryConfigFromFlags(cmd),
+				Transport:   transportConfigFromFlags(cmd),
+				JournalPath: soratun.BootstrapJournalPath(configPath),
+				SimOptions:  simOptionsFromFlags(cmd, options),
+				SimId:       bootstrapSimId,
+			}
+
+			if selectSim {
+				if bootstrapSimId != "" {
+					log.Fatalf("Specify either \"--sim-id\" or \"--select-sim\"")
+				}
+				if !interactive {
+					log.Fatalf("\"--select-sim\" requires a terminal, specify \"--sim-id\" instead")
+				}
+				bootstrapper.SimId, err = selectExistingSim(bootstrapper)
+				if err != nil {
+					log.Fatalf("Failed to select SIM: %v", err)
+				}
+			}
+			if bootstrapper.SimId != "" {
+				for _, name := range []string{"name", "tag", "group-id", "subscription"} {
+					if cmd.Flags().Changed(name) {
+						log.Fatalf("\"--%s\" is only for a new virtual SIM, and can't be used with an existing SIM", name)
+					}
+				}
+				bootstrapper.SimOptions = nil
+				if currentConfig != nil && currentConfig.SimId != "" && currentConfig.SimId != bootstrapper.SimId {
+					fmt.Printf("SIM %s in %s will be replaced with %s\n", currentConfig.SimId, configPath, bootstrapper.SimId)
+				}
+			}
+
+			err = bootstrap(bootstrapper)
 			if err != nil {
 				log.Fatalf("failed to bootstrap: %v", err)
 			}
 		},
 	}
 
-	cmd.Flags().StringVar(&authKeyId, "auth-key-id", "", "SORACOM API auth key ID")
-	cmd.Flags().StringVar(&authKey, "auth-key", "", "SORACOM API auth key")
-	cmd.Flags().StringVar(&coverage, "coverage-type", "", "Specify coverage type, \"g\" for Global, \"jp\" for Japan")
+	cmd.Flags().StringVar(&authKeyId, "auth-key-id", "", "SORACOM API auth key ID, or "+envAuthKeyId+" environment variable")
+	cmd.Flags().StringVar(&authKey, "auth-key", "", "SORACOM API auth key, or "+envAuthKey+" environment variable")
+	cmd.Flags().StringVar(&coverage, "coverage-type", "", "Specify coverage type, \"g\" for Global, \"jp\" for Japan, or "+envCoverageType+" environment variable")
+	cmd.Flags().StringVar(&answersPath, "answers", "", "Path to YAML file which provides inputs of this command, overridden by flags and environment variables")
+	cmd.Flags().BoolVar(&nonInteractive, "non-interactive", false, "Fail instead of launching the wizard if inputs are missing. Implied if stdin is not a terminal")
+	cmd.Flags().StringVar(&bootstrapSimId, "sim-id", "", "Create SORACOM Arc session for the existing SIM instead of creating a new virtual SIM, e.g. for a physical SIM or a replacement device")
+	cmd.Flags().BoolVar(&selectSim, "select-sim", false, "Select the existing SIM to create SORACOM Arc session for from the list of SIMs")
+	cmd.Flags().StringVar(&simName, "name", "", "Name of the new virtual SIM, which overrides arc.json#simOptions.name")
+	cmd.Flags().StringToStringVar(&simTags, "tag", nil, "Tag of the new virtual SIM in key=value form, which is merged into arc.json#simOptions.tags. Can be specified multiple times")
+	cmd.Flags().StringVar(&simGroupId, "group-id", "", "Group ID to attach the new virtual SIM to, which overrides arc.json#simOptions.groupId")
+	cmd.Flags().StringVar(&simSubscription, "subscription", "", "Subscription plan of the new virtual SIM, which overrides arc.json#simOptions.subscription. Defaults to \""+soratun.DefaultSubscription+"\"")
 
 	return cmd
 }
 
+// simOptionsFromFlags returns options with flags which are set explicitly applied to a copy of options from the
+// configuration file, or nil if neither is set.
+func simOptionsFromFlags(cmd *cobra.Command, options *soratun.SimOptions) *soratun.SimOptions {
+	o := soratun.SimOptions{}
+	if options != nil {
+		o = *options
+	}
+
+	if cmd.Flags().Changed("name") {
+		o.Name = simName
+	}
+	if len(simTags) > 0 {
+		tags := map[string]string{}
+		for k, v := range o.Tags {
+			tags[k] = v
+		}
+		for k, v := range simTags {
+			tags[k] = v
+		}
+		o.Tags = tags
+	}
+	if cmd.Flags().Changed("group-id") {
+		o.GroupId = simGroupId
+	}
+	if cmd.Flags().Changed("subscription") {
+		o.Subscription = simSubscription
+	}
+
+	if o.Name == "" && len(o.Tags) == 0 && o.GroupId == "" && o.Subscription == "" {
+		return nil
+	}
+	return &o
+}
+
+// bootstrapAnswers holds inputs of "bootstrap authkey" in the answers file, for unattended bootstrap such as
+// cloud-init.
+type bootstrapAnswers struct {
+	AuthKeyId    string            `yaml:"authKeyId"`
+	AuthKey      string            `yaml:"authKey"`
+	CoverageType string            `yaml:"coverageType"`
+	SimId        string            `yaml:"simId"`
+	Name         string            `yaml:"name"`
+	Tags         map[string]string `yaml:"tags"`
+	GroupId      string            `yaml:"groupId"`
+	Subscription string            `yaml:"subscription"`
+}
+
+// readBootstrapAnswers reads the answers file. Unknown keys are rejected, so that a typo doesn't result in the
+// bootstrap with unintended inputs.
+func readBootstrapAnswers(path string) (*bootstrapAnswers, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	answers := &bootstrapAnswers{}
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(answers); err != nil {
+		return nil, fmt.Errorf("error while reading %s: %w", path, err)
+	}
+	return answers, nil
+}
+
+// simOptions returns the answers applied to a copy of options, or options as is if the answers have no SIM options.
+func (a *bootstrapAnswers) simOptions(options *soratun.SimOptions) *soratun.SimOptions {
+	if a.Name == "" && len(a.Tags) == 0 && a.GroupId == "" && a.Subscription == "" {
+		return options
+	}
+
+	o := soratun.SimOptions{}
+	if options != nil {
+		o = *options
+	}
+	if a.Name != "" {
+		o.Name = a.Name
+	}
+	if len(a.Tags) > 0 {
+		tags := map[string]string{}
+		for k, v := range o.Tags {
+			tags[k] = v
+		}
+		for k, v := range a.Tags {
+			tags[k] = v
+		}
+		o.Tags = tags
+	}
+	if a.GroupId != "" {
+		o.GroupId = a.GroupId
+	}
+	if a.Subscription != "" {
+		o.Subscription = a.Subscription
+	}
+	return &o
+}
+
+// resolveInput sets value from the environment variable, or the answer, unless the flag is set explicitly.
+func resolveInput(cmd *cobra.Command, flag string, value *string, env, answer string) {
+	if cmd.Flags().Changed(flag) {
+		return
+	}
+	if v := os.Getenv(env); v != "" {
+		*value = v
+	} else if answer != "" {
+		*value = answer
+	}
+}
+
+// missingProfileInputs returns inputs which are required to create a profile but not provided.
+func missingProfileInputs() []string {
+	var missing []string
+	if authKeyId == "" {
+		missing = append(missing, "\"--auth-key-id\" or "+envAuthKeyId)
+	}
+	if authKey == "" {
+		missing = append(missing, "\"--auth-key\" or "+envAuthKey)
+	}
+	if coverage == "" {
+		missing = append(missing, "\"--coverage-type\" or "+envCoverageType)
+	}
+	return missing
+}
+
+// selectExistingSim lists SIMs which are not terminated, and returns SIM ID selected by the user.
+func selectExistingSim(b *soratun.AuthKeyBootstrapper) (string, error) {
+	ctx, cancel := bootstrapContext()
+	defer cancel()
+
+	profile := *b.Profile
+	if b.Retry != nil {
+		profile.Retry = b.Retry
+	}
+	if b.Transport != nil {
+		profile.Transport = b.Transport
+	}
+	client, err := soratun.NewDefaultSoracomClient(ctx, profile)
+	if err != nil {
+		return "", err
+	}
+
+	list, err := listSims(ctx, client, 0)
+	if err != nil {
+		return "", err
+	}
+	var sims []*simStatus
+	for _, sim := range list {
+		if sim.Status != "terminated" {
+			sims = append(sims, newSimStatus(sim))
+		}
+	}
+	if len(sims) == 0 {
+		return "", errors.New("no SIM found")
+	}
+
+	selected, err := askOne(promptui.Select{
+		Label: "SIM to create SORACOM Arc session for",
+		Items: sims,
+		Size:  10,
+		Templates: &promptui.SelectTemplates{
+			Active:   `▸ {{ .SimId | cyan }} {{ .Name }} ({{ .Status }})`,
+			Inactive: `  {{ .SimId }} {{ .Name }} ({{ .Status }})`,
+			Selected: `SIM: {{ .SimId }}`,
+		},
+		Searcher: func(input string, index int) bool {
+			s := sims[index]
+			return strings.Contains(s.SimId, input) || strings.Contains(strings.ToLower(s.Name), strings.ToLower(input))
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return sims[selected].SimId, nil
+}
+
 func collectProfileInformationInteractive() (*soratun.Profile, error) {
 	authKeyId, err := askInput(promptui.Prompt{
 		Label: "SORACOM API auth key ID (starts with \"keyId-\")",
@@ -133,7 +403,17 @@ func collectProfileInformationFromFlags() (*soratun.Profile, error) {
 	}, nil
 }
 
+// isTerminal returns true if f is a terminal, where the user can answer prompts. /dev/null, which is stdin of
+// cloud-init and systemd units, is a character device but not a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	return err == nil
+}
+
 func askInput(prompt promptui.Prompt) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", errors.New("invalid input: no terminal to ask")
+	}
 	res, err := prompt.Run()
 	if err != nil {
 		return "", fmt.Errorf("invalid input: %s", err)
@@ -142,6 +422,9 @@ func askInput(prompt promptui.Prompt) (string, error) {
 }
 
 func askOne(prompt promptui.Select) (int, error) {
+	if !isTerminal(os.Stdin) {
+		return -1, errors.New("invalid selection: no terminal to ask")
+	}
 	selected, _, err := prompt.Run()
 	if err != nil {
 		return -1, fmt.Errorf("invalid selection: %s", err)
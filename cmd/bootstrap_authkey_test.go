@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readBootstrapAnswers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answers.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`authKeyId: keyId-xxx
+authKey: secret-xxx
+coverageType: jp
+name: sensor-1
+tags:
+  lot: "2026-10"
+`), 0600))
+
+	answers, err := readBootstrapAnswers(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "keyId-xxx", answers.AuthKeyId)
+	assert.Equal(t, "secret-xxx", answers.AuthKey)
+	assert.Equal(t, "jp", answers.CoverageType)
+
+	options := answers.simOptions(&soratun.SimOptions{Tags: map[string]string{"site": "tokyo"}, GroupId: "group-1"})
+	assert.Equal(t, &soratun.SimOptions{
+		Name:    "sensor-1",
+		Tags:    map[string]string{"site": "tokyo", "lot": "2026-10"},
+		GroupId: "group-1",
+	}, options)
+
+	assert.NoError(t, os.WriteFile(path, []byte("authKeyID: keyId-xxx\n"), 0600))
+	_, err = readBootstrapAnswers(path)
+	assert.Error(t, err)
+}
+
+func Test_resolveInput(t *testing.T) {
+	cmd := &cobra.Command{}
+	var value string
+	cmd.Flags().StringVar(&value, "auth-key-id", "", "")
+
+	t.Setenv(envAuthKeyId, "")
+	resolveInput(cmd, "auth-key-id", &value, envAuthKeyId, "keyId-answer")
+	assert.Equal(t, "keyId-answer", value)
+
+	t.Setenv(envAuthKeyId, "keyId-env")
+	resolveInput(cmd, "auth-key-id", &value, envAuthKeyId, "keyId-answer")
+	assert.Equal(t, "keyId-env", value)
+
+	assert.NoError(t, cmd.Flags().Set("auth-key-id", "keyId-flag"))
+	resolveInput(cmd, "auth-key-id", &value, envAuthKeyId, "keyId-answer")
+	assert.Equal(t, "keyId-flag", value)
+}
@@ -16,7 +16,11 @@ func bootstrapCellularCmd() *cobra.Command {
 		Long:  "This command will create a new virtual SIM which is associated with current physical SIM, then create configuration for soratun. Need active SORACOM Air for Cellular connection.",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := bootstrap(&soratun.CellularBootstrapper{Endpoint: kryptonCellularEndpoint})
+			err := bootstrap(&soratun.CellularBootstrapper{
+				Endpoint:  kryptonCellularEndpoint,
+				Retry:     retryConfigFromFlags(cmd),
+				Transport: transportConfigFromFlags(cmd),
+			})
 			if err != nil {
 				log.Fatalf("failed to bootstrap: %v", err)
 			}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+func bootstrapResumeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "resume",
+		Short: "Finish interrupted \"bootstrap authkey\" without creating another virtual SIM",
+		Long:  "This command will finish \"soratun bootstrap authkey\" which created a virtual SIM but failed to configure it, e.g. due to network error or power loss, with the journal next to the configuration file. The SIM in the journal is configured, instead of creating another SIM which is billed separately. Since the auth key is not saved in the journal, \"profile\" in the configuration file is used if any, else it is taken from flags or environment variables (SORACOM_AUTH_KEY_ID and SORACOM_AUTH_KEY), or asked if stdin is a terminal.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := bootstrapContext()
+			defer cancel()
+
+			bootstrapper := &soratun.AuthKeyBootstrapper{
+				Retry:       retryConfigFromFlags(cmd),
+				Transport:   transportConfigFromFlags(cmd),
+				JournalPath: soratun.BootstrapJournalPath(configPath),
+			}
+			// like "bootstrap authkey", current configuration without SIM is a template, if any
+			currentConfig, err := readExistingConfig(configPath)
+			if err != nil {
+				log.Fatalf("failed to resume bootstrap: %v", err)
+			}
+			if currentConfig == nil {
+				currentConfig, err = readConfigTemplate()
+				if err != nil {
+					log.Fatalf("failed to resume bootstrap: %v", err)
+				}
+			}
+
+			if currentConfig != nil && currentConfig.Profile != nil {
+				bootstrapper.Profile = currentConfig.Profile
+			} else {
+				profile, err := resumeProfile(cmd, bootstrapper.JournalPath)
+				if err != nil {
+					log.Fatalf("failed to resume bootstrap: %v", err)
+				}
+				bootstrapper.Profile = profile
+			}
+
+			config, err := bootstrapper.Resume(ctx, currentConfig)
+			if err != nil {
+				log.Fatalf("failed to resume bootstrap: %v", err)
+			}
+
+			if err := saveBootstrappedConfig(config); err != nil {
+				log.Fatalf("failed to save configuration: %v", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&authKeyId, "auth-key-id", "", "SORACOM API auth key ID, or "+envAuthKeyId+" environment variable. Defaults to the one in the journal")
+	cmd.Flags().StringVar(&authKey, "auth-key", "", "SORACOM API auth key, or "+envAuthKey+" environment variable")
+
+	return cmd
+}
+
+// resumeProfile returns the profile in the journal with the auth key from flags, environment variables, or a prompt,
+// since the journal has no auth key.
+func resumeProfile(cmd *cobra.Command, journalPath string) (*soratun.Profile, error) {
+	journal, err := soratun.ReadBootstrapJournal(journalPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("no interrupted bootstrap is found")
+		}
+		return nil, err
+	}
+
+	profile := soratun.Profile{}
+	if journal.Profile != nil {
+		profile = *journal.Profile
+	}
+
+	resolveInput(cmd, "auth-key-id", &authKeyId, envAuthKeyId, "")
+	resolveInput(cmd, "auth-key", &authKey, envAuthKey, "")
+	if authKeyId != "" {
+		profile.AuthKeyID = authKeyId
+	}
+	if profile.AuthKeyID == "" {
+		return nil, errors.New("no auth key ID is found in the journal, specify \"--auth-key-id\" or " + envAuthKeyId)
+	}
+
+	if authKey == "" {
+		if !isTerminal(os.Stdin) {
+			return nil, errors.New("auth key is not saved in the journal, specify \"--auth-key\" or " + envAuthKey)
+		}
+		authKey, err = askInput(promptui.Prompt{
+			Label: "SORACOM API auth key for " + profile.AuthKeyID + " (starts with \"secret-\")",
+			Validate: func(input string) error {
+				if !strings.HasPrefix(input, "secret-") {
+					return errors.New("auth key should start with \"secret-\"")
+				}
+				return nil
+			},
+			Mask: '*',
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	profile.AuthKey = authKey
+
+	return &profile, nil
+}
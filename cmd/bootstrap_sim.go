@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 
 	"github.com/soracom/soratun"
 	"github.com/spf13/cobra"
@@ -23,6 +24,7 @@ var (
 	disableKeyCache            bool
 	clearKeyCache              bool
 	kryptonCliPath             string
+	nativeSimAuth              bool
 	dumpConfig                 bool
 )
 
@@ -30,12 +32,37 @@ func bootstrapSimCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "sim",
 		Short: "Create virtual SIM which is associated with current SIM with SORACOM Krypton SIM authentication",
-		Long:  "This command will create a new virtual SIM which is associated with current physical SIM, then create configuration for soratun. You need working \"krypton-cli\". See https://github.com/soracom/krypton-client-go for how to install.",
-		Args:  cobra.NoArgs,
+		Long: `This command will create a new virtual SIM which is associated with current physical SIM, then create configuration for soratun.
+
+You need working "krypton-cli". See https://github.com/soracom/krypton-client-go for how to install.
+
+With --native, soratun authenticates with the SIM by itself instead, which is experimental. It talks to the SIM through AT+CSIM command of the modem on the serial port specified by --port-name ("comm" interface), or through pcscd for a card reader ("iso7816" interface). "autoDetect" uses the modem if --port-name is specified, else the first card reader with a card. ModemManager ("mmcli" interface) is used only if specified, and it needs to run with --debug to accept AT commands.`,
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := bootstrap(&soratun.SimBootstrapper{
-				KryptonCliPath: kryptonCliPath,
-				Arguments:      buildKryptonCliArguments(),
+			if nativeSimAuth && (disableKeyCache || clearKeyCache) {
+				log.Fatalf("\"--disable-key-cache\" and \"--clear-key-cache\" are supported only with krypton-cli, soratun doesn't cache keys")
+			}
+
+			uicc, closeUICC, err := newUICC()
+			if err != nil {
+				log.Fatalf("failed to open SIM: %v", err)
+			}
+			defer closeUICC()
+
+			if uicc == nil && transportConfigFromFlags(cmd) != nil {
+				log.Fatalf("TLS and proxy flags are not supported with krypton-cli")
+			}
+
+			err = bootstrap(&soratun.SimBootstrapper{
+				UICC:                 uicc,
+				KeysEndpoint:         keysAPIEndpointURL,
+				ProvisioningEndpoint: provisioningAPIEndpointURL,
+				SignatureAlgorithm:   signatureAlgorithm,
+				Params:               requestParameters,
+				Retry:                retryConfigFromFlags(cmd),
+				Transport:            transportConfigFromFlags(cmd),
+				KryptonCliPath:       kryptonCliPath,
+				Arguments:            buildKryptonCliArguments(),
 			})
 			if err != nil {
 				log.Fatalf("failed to bootstrap: %v", err)
@@ -43,24 +70,87 @@ func bootstrapSimCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().StringVar(&provisioningAPIEndpointURL, "provisioning-api-endpoint-url", "", "Use the specified URL as a Provisioning API endpoint.")
+	cmd.Flags().StringVar(&provisioningAPIEndpointURL, "provisioning-api-endpoint-url", "", "Use the specified URL as a Provisioning API endpoint. Defaults to "+soratun.DefaultKryptonProvisioningEndpoint)
 	cmd.Flags().StringVar(&requestParameters, "params", "", "Pass additional JSON parameters to the service request")
-	cmd.Flags().StringVar(&keysAPIEndpointURL, "keys-api-endpoint-url", "", "Use the specified URL as a Keys API endpoint")
+	cmd.Flags().StringVar(&keysAPIEndpointURL, "keys-api-endpoint-url", "", "Use the specified URL as a Keys API endpoint. Defaults to "+soratun.DefaultKryptonKeysEndpoint)
 	cmd.Flags().StringVar(&signatureAlgorithm, "signature-algorithm", "SHA-256", "Algorithm for generating signature.")
-	cmd.Flags().StringVar(&uiccInterfaceType, "interface", "autoDetect", "UICC Interface to use. Valid values are iso7816, comm, mmcli or autoDetect")
+	cmd.Flags().StringVar(&uiccInterfaceType, "interface", "autoDetect", "UICC Interface to use. Valid values are iso7816, comm, mmcli or autoDetect. autoDetect never uses mmcli")
 	cmd.Flags().StringVar(&portName, "port-name", "", "Port name of communication device (e.g. COM1 or /dev/tty1)")
 	cmd.Flags().UintVar(&baudRate, "baud-rate", 57600, "Baud rate for communication device")
 	cmd.Flags().UintVar(&dataBits, "data-bits", 8, "Data bits for communication device")
 	cmd.Flags().UintVar(&stopBits, "stop-bits", 1, "Stop bits for communication device")
 	cmd.Flags().UintVar(&parityMode, "parity-mode", 0, "Parity mode for communication device. 0: None (default), 1: Odd, 2: Even")
-	cmd.Flags().BoolVar(&disableKeyCache, "disable-key-cache", false, "Do not store authentication result to the key cache")
-	cmd.Flags().BoolVar(&clearKeyCache, "clear-key-cache", false, "Remove all items in the key cache")
+	cmd.Flags().BoolVar(&disableKeyCache, "disable-key-cache", false, "Do not store authentication result to the key cache of krypton-cli. Not supported with --native")
+	cmd.Flags().BoolVar(&clearKeyCache, "clear-key-cache", false, "Remove all items in the key cache of krypton-cli. Not supported with --native")
 	cmd.Flags().StringVar(&kryptonCliPath, "krypton-cli-path", "/usr/local/bin/krypton-cli", "Path to krypton-cli")
+	cmd.Flags().BoolVar(&nativeSimAuth, "native", false, "Authenticate with the SIM by soratun itself instead of krypton-cli (experimental)")
 	cmd.Flags().BoolVar(&dumpConfig, "dump-config", false, "dump configuration to stdout, ignoring --config setting")
 
 	return cmd
 }
 
+// newUICC returns the SIM for "--interface", and a function to close it, or nil if krypton-cli is used. soratun talks to
+// the SIM only with "--native", since its Krypton requests are not verified against krypton-cli yet.
+func newUICC() (soratun.UICC, func(), error) {
+	noop := func() {}
+	if !nativeSimAuth {
+		return nil, noop, nil
+	}
+
+	switch uiccInterfaceType {
+	case "autoDetect":
+		// ModemManager may be managing a modem which has another SIM, so it is used only if specified
+		if portName != "" {
+			return newSerialUICC()
+		}
+		uicc, closeUICC, err := newPCSCUICC()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w, specify \"--port-name\" for a modem, or \"--interface mmcli\" for a modem managed by ModemManager", err)
+		}
+		return uicc, closeUICC, nil
+	case "comm":
+		return newSerialUICC()
+	case "mmcli":
+		path, err := exec.LookPath("mmcli")
+		if err != nil {
+			return nil, nil, err
+		}
+		return &soratun.MMCLIUICC{Path: path, Modem: "any"}, noop, nil
+	case "iso7816":
+		return newPCSCUICC()
+	}
+	return nil, nil, fmt.Errorf("unknown interface \"%s\", it should be one of iso7816, comm, mmcli or autoDetect", uiccInterfaceType)
+}
+
+func newPCSCUICC() (soratun.UICC, func(), error) {
+	u, err := soratun.OpenPCSCUICC("")
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, func() {
+		_ = u.Close()
+	}, nil
+}
+
+func newSerialUICC() (soratun.UICC, func(), error) {
+	if portName == "" {
+		return nil, nil, fmt.Errorf("\"--port-name\" is required for \"comm\" interface")
+	}
+	f, err := soratun.OpenSerialPort(&soratun.SerialConfig{
+		PortName:   portName,
+		BaudRate:   baudRate,
+		DataBits:   dataBits,
+		StopBits:   stopBits,
+		ParityMode: parityMode,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return soratun.NewATCommandUICC(f), func() {
+		_ = f.Close()
+	}, nil
+}
+
 func buildKryptonCliArguments() []string {
 	var args []string
 	args = append(args, []string{"-operation", "bootstrapArc"}...)
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	captureInterface   string
+	captureFile        string
+	captureProtocol    string
+	captureHost        string
+	capturePort        uint16
+	captureMaxFileSize int64
+	captureMaxFiles    int
+	captureDuration    time.Duration
+)
+
+func captureCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capture",
+		Short: "Capture packets on running SORACOM Arc interface",
+		Long:  "This command will ask running \"soratun up\" process to write decrypted packets on the interface to a pcapng file in " + soratun.CaptureDirectory + ", until interrupted or --duration elapsed. No tcpdump is required.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := soratun.ValidateCaptureName(captureFile); err != nil {
+				log.Fatalf("Invalid \"--write\", files are written to %s: %v", soratun.CaptureDirectory, err)
+			}
+			path := filepath.Join(soratun.CaptureDirectory, captureFile)
+
+			options := soratun.CaptureOptions{
+				Name: captureFile,
+				Filter: soratun.CaptureFilter{
+					Protocol: strings.ToLower(captureProtocol),
+					Port:     capturePort,
+				},
+				MaxFileSize: captureMaxFileSize * 1000 * 1000,
+				MaxFiles:    captureMaxFiles,
+			}
+
+			if captureHost != "" {
+				options.Filter.Host = net.ParseIP(captureHost)
+				if options.Filter.Host == nil {
+					log.Fatalf("Invalid IP address is set for \"--host\": %s", captureHost)
+				}
+			}
+
+			if err := options.Filter.Validate(); err != nil {
+				log.Fatalf("Invalid filter: %v", err)
+			}
+
+			if err := soratun.CallControl(captureInterface, "capture-start", options, nil); err != nil {
+				log.Fatalf("Failed to start capture: %v", err)
+			}
+
+			fmt.Fprintf(os.Stderr, "Capturing packets on %s to %s. Press Ctrl-C to stop.\n", captureInterface, path)
+
+			term := make(chan os.Signal, 1)
+			signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+
+			var timeout <-chan time.Time
+			if captureDuration > 0 {
+				timeout = time.After(captureDuration)
+			}
+
+			select {
+			case <-term:
+			case <-timeout:
+			}
+
+			stopCapture()
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&captureInterface, "interface", soratun.DefaultInterfaceName(), "Interface name to capture packets")
+	cmd.Flags().StringVarP(&captureFile, "write", "w", "", "Name of pcapng file to write packets in "+soratun.CaptureDirectory)
+	cmd.Flags().StringVar(&captureProtocol, "protocol", "", "Capture only the protocol, \"tcp\", \"udp\", or \"icmp\"")
+	cmd.Flags().StringVar(&captureHost, "host", "", "Capture only packets from or to the IP address")
+	cmd.Flags().Uint16Var(&capturePort, "port", 0, "Capture only TCP or UDP packets from or to the port")
+	cmd.Flags().Int64Var(&captureMaxFileSize, "max-file-size", 0, "Rotate the file when it exceeds the size in millions of bytes, 0 means no rotation")
+	cmd.Flags().IntVar(&captureMaxFiles, "max-files", 10, "Keep the number of files on rotation, removing the oldest one, 0 means no limit")
+	cmd.Flags().DurationVar(&captureDuration, "duration", 0, "Stop capturing after the duration, e.g. \"30s\" or \"5m\", 0 means until interrupted")
+	_ = cmd.MarkFlagRequired("write")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stop",
+		Short: "Stop ongoing packet capture",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			stopCapture()
+		},
+	})
+
+	return cmd
+}
+
+func stopCapture() {
+	var stats soratun.CaptureStats
+	if err := soratun.CallControl(captureInterface, "capture-stop", nil, &stats); err != nil {
+		log.Fatalf("Failed to stop capture: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d packets (%d bytes) captured, written to: %s\n", stats.Packets, stats.Bytes, strings.Join(stats.Files, ", "))
+}
@@ -5,14 +5,13 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"os"
 
 	"github.com/soracom/soratun"
 	"github.com/spf13/cobra"
 )
 
 func configCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "config",
 		Short: "Create initial soratun configuration file without bootstrapping",
 		Args:  cobra.NoArgs,
@@ -50,29 +49,9 @@ func configCmd() *cobra.Command {
 			fmt.Println(string(b))
 		},
 	}
-}
-
-func writeConfigurationToFile(conf string) error {
-	f, err := os.Create(configPath)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		err := f.Close()
-		if err != nil {
-			log.Fatal("failed to close file", err)
-		}
-	}()
 
-	err = os.Chmod(f.Name(), 0600)
-	if err != nil {
-		return err
-	}
-
-	_, err = f.WriteString(conf + "\n")
-	if err != nil {
-		return err
-	}
+	cmd.AddCommand(configDecryptCmd())
+	cmd.AddCommand(configEncryptCmd())
 
-	return nil
+	return cmd
 }
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/manifoldco/promptui"
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+func configEncryptCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "encrypt",
+		Short: "Seal secret fields of the configuration file",
+		Long:  "This command will encrypt privateKey, profile.authKeyId and profile.authKey in the configuration file with a key derived from a passphrase or keyfile, which is supplied with --config-passphrase, --config-key-file, " + configPassphraseEnv + " or " + configKeyFileEnv + ". If none of them is set, the passphrase is asked interactively. soratun decrypts the fields when it reads the configuration with the same passphrase or keyfile. Fields which refer to secret references are kept as they are.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := readConfig(configPath)
+			if err != nil {
+				log.Fatalf("Error: %s\n", err)
+			}
+
+			key, err := configSealKey()
+			if err != nil {
+				log.Fatalf("Error: %s\n", err)
+			}
+			if key == nil {
+				if key, err = askPassphrase(); err != nil {
+					log.Fatalf("Error: %s\n", err)
+				}
+			}
+
+			config.Seal(key)
+			if err := saveConfig(config); err != nil {
+				log.Fatalf("Failed to save configuration: %v\n", err)
+			}
+		},
+	}
+}
+
+func configDecryptCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "decrypt",
+		Short: "Unseal secret fields of the configuration file",
+		Long:  "This command will decrypt sealed fields in the configuration file with the passphrase or keyfile supplied with --config-passphrase, --config-key-file, " + configPassphraseEnv + " or " + configKeyFileEnv + ", and write them in plaintext.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := readConfig(configPath)
+			if err != nil {
+				log.Fatalf("Error: %s\n", err)
+			}
+			if !config.Sealed() {
+				log.Fatalf("Error: %s is not sealed\n", configPath)
+			}
+
+			config.Unseal()
+			if err := saveConfig(config); err != nil {
+				log.Fatalf("Failed to save configuration: %v\n", err)
+			}
+		},
+	}
+}
+
+// saveConfig writes the configuration to the path specified with "--config" flag.
+func saveConfig(config *soratun.Config) error {
+	if err := soratun.WriteConfigFile(configPath, config); err != nil {
+		return err
+	}
+	printConfigurationFilePath()
+	return nil
+}
+
+func askPassphrase() ([]byte, error) {
+	passphrase, err := askInput(promptui.Prompt{
+		Label: "Passphrase",
+		Validate: func(input string) error {
+			if len(input) < 8 {
+				return errors.New("passphrase should be at least 8 characters")
+			}
+			return nil
+		},
+		Mask: '*',
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	confirmation, err := askInput(promptui.Prompt{
+		Label: "Confirm passphrase",
+		Mask:  '*',
+	})
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != confirmation {
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+	return []byte(passphrase), nil
+}
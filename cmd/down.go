@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	downInterface string
+	downTimeout   time.Duration
+)
+
+func downCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Stop running SORACOM Arc interface",
+		Long:  "This command will ask \"soratun up\" process which manages the interface to shut down gracefully, so PostDown hooks are executed, then wait until the process exits. If no process is found, the interface left behind will be removed.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			pid, running := soratun.InterfaceOwner(downInterface)
+
+			err := soratun.CallControl(downInterface, "shutdown", nil, nil)
+			if err != nil && running {
+				// the process is alive but its control socket is unreachable, e.g. the socket file was removed
+				p, err := os.FindProcess(pid)
+				if err == nil {
+					err = p.Signal(syscall.SIGTERM)
+				}
+				if err != nil {
+					log.Fatalf("Failed to stop soratun process (pid %d) for %s: %v", pid, downInterface, err)
+				}
+			}
+
+			if err == nil || running {
+				fmt.Printf("Stopping soratun process for %s...\n", downInterface)
+				if !waitForShutdown(downInterface, downTimeout) {
+					log.Fatalf("soratun process (pid %d) for %s did not stop within %s", pid, downInterface, downTimeout)
+				}
+				fmt.Printf("%s is down\n", downInterface)
+				return
+			}
+
+			if err := soratun.DeleteInterface(downInterface); err != nil {
+				log.Fatalf("No soratun process found for %s, and failed to remove the interface: %v", downInterface, err)
+			}
+			_ = os.Remove(soratun.ControlSocketPath(downInterface))
+			_ = os.Remove(soratun.PidFilePath(downInterface))
+			fmt.Printf("No soratun process found for %s, removed the interface. PostDown hooks were not executed.\n", downInterface)
+		},
+	}
+
+	cmd.Flags().StringVar(&downInterface, "interface", soratun.DefaultInterfaceName(), "Interface name to stop")
+	cmd.Flags().DurationVar(&downTimeout, "timeout", 30*time.Second, "Time to wait for the process to exit, including PostDown hooks")
+
+	return cmd
+}
+
+// waitForShutdown waits until no process manages the interface, and returns false on timeout.
+func waitForShutdown(iname string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, running := soratun.InterfaceOwner(iname); !running {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/soracom/soratun"
 	"github.com/spf13/cobra"
 )
 
@@ -33,6 +34,12 @@ func dumpWireGuardConfig(mask bool, w io.Writer) {
 		privateKey = "(hidden)"
 	}
 
+	// wg-quick discovers MTU by itself if MTU is omitted
+	mtu := ""
+	if Config.Mtu != soratun.MTUAuto {
+		mtu = fmt.Sprintf("MTU = %d\n", Config.Mtu)
+	}
+
 	postUp := ""
 	postDown := ""
 	if len(Config.PostUp) > 0 {
@@ -55,8 +62,7 @@ func dumpWireGuardConfig(mask bool, w io.Writer) {
 	fmt.Fprintf(w, `[Interface]
 Address = %s/32
 PrivateKey = %s
-MTU = %d
-%s%s
+%s%s%s
 [Peer]
 PublicKey = %s
 AllowedIPs = %s
@@ -65,7 +71,7 @@ PersistentKeepalive = %d
 `,
 		Config.ArcSession.ArcClientPeerIpAddress,
 		privateKey,
-		Config.Mtu,
+		mtu,
 		postUp,
 		postDown,
 		Config.ArcSession.ArcServerPeerPublicKey,
@@ -0,0 +1,378 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+// fleetManifestHeader is the header of CSV manifest.
+var fleetManifestHeader = []string{"index", "name", "simId", "imsi", "clientIp", "config"}
+
+var (
+	fleetCount          int
+	fleetStartIndex     int
+	fleetNameTemplate   string
+	fleetOut            string
+	fleetConcurrency    int
+	fleetManifestFormat string
+	fleetIncludeProfile bool
+	fleetTags           map[string]string
+	fleetGroupId        string
+	fleetSubscription   string
+)
+
+// fleetDevice is a provisioned device in the manifest.
+type fleetDevice struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name,omitempty"`
+	SimId    string `json:"simId"`
+	Imsi     string `json:"imsi,omitempty"`
+	ClientIp string `json:"clientIp,omitempty"`
+	Config   string `json:"config"`
+}
+
+func fleetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fleet",
+		Short: "Provision virtual SIMs for many devices",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.AddCommand(fleetProvisionCmd())
+
+	return cmd
+}
+
+func fleetProvisionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "provision",
+		Short: "Create virtual SIMs and write a configuration file for each device",
+		Long: `This command will create virtual SIMs with SORACOM API AuthKey, and write a configuration file for each device, e.g. "arc-1.json", and a manifest of provisioned devices to the output directory.
+
+Each device is bootstrapped in the same way as "soratun bootstrap authkey", with a bootstrap journal next to its configuration file. If some devices fail, run the same command again: devices in the manifest are skipped, and interrupted ones are resumed from their journals, so no extra SIM is created.
+
+Configuration files don't have "profile" unless "--include-profile" is set, so the auth key is not copied to each device.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if fleetCount <= 0 {
+				log.Fatalf("\"--count\" should be greater than 0")
+			}
+			if fleetConcurrency <= 0 {
+				log.Fatalf("\"--concurrency\" should be greater than 0")
+			}
+			if fleetManifestFormat != "csv" && fleetManifestFormat != "json" {
+				log.Fatalf("Unknown manifest format \"%s\", it should be one of csv or json", fleetManifestFormat)
+			}
+			nameTemplate, err := template.New("name").Option("missingkey=error").Parse(fleetNameTemplate)
+			if err != nil {
+				log.Fatalf("Invalid \"--name-template\": %v", err)
+			}
+
+			profile, err := fleetProfile(cmd)
+			if err != nil {
+				log.Fatalf("Error while setup: %v", err)
+			}
+
+			if err := os.MkdirAll(fleetOut, 0700); err != nil {
+				log.Fatalf("Failed to create output directory: %v", err)
+			}
+
+			manifestPath := filepath.Join(fleetOut, "manifest."+fleetManifestFormat)
+			devices, err := readFleetManifest(manifestPath, fleetManifestFormat)
+			if err != nil {
+				log.Fatalf("Failed to read manifest: %v", err)
+			}
+			done := map[int]bool{}
+			for _, d := range devices {
+				done[d.Index] = true
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			client, err := soratun.NewDefaultSoracomClient(ctx, *profile)
+			if err != nil {
+				log.Fatalf("Failed to create SORACOM API client: %v", err)
+			}
+
+			var mu sync.Mutex
+			var failed int
+			var manifestErr error
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, fleetConcurrency)
+			for i := fleetStartIndex; i < fleetStartIndex+fleetCount; i++ {
+				if done[i] {
+					continue
+				}
+
+				name, err := fleetDeviceName(nameTemplate, i)
+				if err != nil {
+					wg.Wait()
+					log.Fatalf("Invalid \"--name-template\": %v", err)
+				}
+
+				sem <- struct{}{}
+				// devices in progress are waited for, so their results are not lost
+				mu.Lock()
+				stopped := manifestErr != nil
+				mu.Unlock()
+				if stopped {
+					<-sem
+					break
+				}
+
+				wg.Add(1)
+				go func(index int, name string) {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+
+					d, err := provisionFleetDevice(ctx, client, profile, index, name)
+
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						failed++
+						log.Printf("Failed to provision device %d: %v", index, err)
+						return
+					}
+					devices = append(devices, d)
+					fmt.Printf("Provisioned device %d: SIM ID %s, %s\n", d.Index, d.SimId, d.Config)
+					if err := writeFleetManifest(manifestPath, fleetManifestFormat, devices); err != nil {
+						log.Printf("Failed to write manifest: %v", err)
+						manifestErr = err
+					}
+				}(i, name)
+			}
+			wg.Wait()
+
+			if manifestErr != nil {
+				log.Fatalf("Stopped provisioning since the manifest can't be written: %v", manifestErr)
+			}
+			if failed > 0 {
+				log.Fatalf("Failed to provision %d of %d devices. Run the same command again to retry them", failed, fleetCount)
+			}
+			fmt.Printf("Provisioned %d devices, manifest: %s\n", fleetCount, manifestPath)
+		},
+	}
+
+	cmd.Flags().IntVar(&fleetCount, "count", 0, "Number of devices to provision")
+	cmd.Flags().IntVar(&fleetStartIndex, "start-index", 1, "Index of the first device")
+	cmd.Flags().StringVar(&fleetNameTemplate, "name-template", "", "Go template of SIM name, with .Index of the device, e.g. \"dev-{{.Index}}\" or \"dev-{{printf \\\"%03d\\\" .Index}}\"")
+	cmd.Flags().StringVar(&fleetOut, "out", ".", "Directory to write configuration files and the manifest to")
+	cmd.Flags().IntVar(&fleetConcurrency, "concurrency", 4, "Number of devices to provision concurrently")
+	cmd.Flags().StringVar(&fleetManifestFormat, "manifest-format", "csv", "Format of the manifest, one of csv or json")
+	cmd.Flags().BoolVar(&fleetIncludeProfile, "include-profile", false, "Include \"profile\" with the auth key in configuration files")
+	cmd.Flags().StringToStringVar(&fleetTags, "tag", nil, "Tag of the virtual SIMs in key=value form. Can be specified multiple times")
+	cmd.Flags().StringVar(&fleetGroupId, "group-id", "", "Group ID to attach the virtual SIMs to")
+	cmd.Flags().StringVar(&fleetSubscription, "subscription", "", "Subscription plan of the virtual SIMs. Defaults to \""+soratun.DefaultSubscription+"\"")
+	cmd.Flags().StringVar(&authKeyId, "auth-key-id", "", "SORACOM API auth key ID, or \"profile\" in the configuration file is used")
+	cmd.Flags().StringVar(&authKey, "auth-key", "", "SORACOM API auth key")
+	cmd.Flags().StringVar(&coverage, "coverage-type", "", "Specify coverage type, \"g\" for Global, \"jp\" for Japan")
+
+	return cmd
+}
+
+// fleetProfile returns the profile from flags, or the configuration file.
+func fleetProfile(cmd *cobra.Command) (*soratun.Profile, error) {
+	if cmd.Flags().Changed("auth-key-id") || cmd.Flags().Changed("auth-key") {
+		return collectProfileInformationFromFlags()
+	}
+
+	config, err := readConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("specify \"--auth-key-id\", \"--auth-key\" and \"--coverage-type\", or save profile in the configuration file: %w", err)
+	}
+	if config.Profile == nil {
+		return nil, fmt.Errorf("no profile is found in %s", configPath)
+	}
+	return config.Profile, nil
+}
+
+// fleetDeviceName returns SIM name of the device with the template.
+func fleetDeviceName(t *template.Template, index int) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, struct{ Index int }{Index: index}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// provisionFleetDevice bootstraps a device, resuming from its journal if a previous run was interrupted, and writes its
+// configuration file.
+func provisionFleetDevice(ctx context.Context, client soratun.SoracomClient, profile *soratun.Profile, index int, name string) (*fleetDevice, error) {
+	path := filepath.Join(fleetOut, fmt.Sprintf("arc-%d.json", index))
+	journalPath := soratun.BootstrapJournalPath(path)
+
+	b := &soratun.AuthKeyBootstrapper{
+		Profile:     profile,
+		JournalPath: journalPath,
+		SimOptions: &soratun.SimOptions{
+			Name:         name,
+			Tags:         fleetTags,
+			GroupId:      fleetGroupId,
+			Subscription: fleetSubscription,
+		},
+	}
+
+	var config *soratun.Config
+	var err error
+	if _, statErr := os.Stat(journalPath); statErr == nil {
+		config, err = b.Resume(ctx, nil)
+	} else if c, readErr := readExistingConfig(path); readErr != nil {
+		// don't replace the configuration file we couldn't read with a new virtual SIM
+		err = readErr
+	} else if c != nil && c.SimId != "" {
+		// the previous run was interrupted after writing the configuration file but before updating the manifest
+		config = c
+	} else {
+		config, err = b.Execute(ctx, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !fleetIncludeProfile {
+		config.Profile = nil
+	}
+	if err := soratun.WriteConfigFile(path, config); err != nil {
+		return nil, err
+	}
+	if err := soratun.RemoveBootstrapJournal(journalPath); err != nil {
+		return nil, err
+	}
+
+	d := &fleetDevice{
+		Index:  index,
+		Name:   name,
+		SimId:  config.SimId,
+		Config: path,
+	}
+	if config.ArcSession != nil && config.ArcSession.ArcClientPeerIpAddress != nil {
+		d.ClientIp = config.ArcSession.ArcClientPeerIpAddress.String()
+	}
+	// IMSI is only for the manifest, so the device is provisioned even if it can't be retrieved
+	if sim, err := client.GetSim(ctx, config.SimId); err != nil {
+		log.Printf("Failed to get IMSI of device %d: %v", index, err)
+	} else if p, ok := sim.Profiles[sim.SimId]; ok {
+		d.Imsi = p.PrimaryImsi
+	}
+	return d, nil
+}
+
+// readFleetManifest returns devices in the manifest, or no devices if it does not exist.
+func readFleetManifest(path, format string) ([]*fleetDevice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return decodeFleetManifest(f, format)
+}
+
+func decodeFleetManifest(r io.Reader, format string) ([]*fleetDevice, error) {
+	var devices []*fleetDevice
+	if format == "json" {
+		if err := json.NewDecoder(r).Decode(&devices); err != nil {
+			return nil, err
+		}
+		return devices, nil
+	}
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if i == 0 {
+			// header
+			continue
+		}
+		if len(record) != len(fleetManifestHeader) {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", i+1, len(fleetManifestHeader), len(record))
+		}
+		index, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid index %q", i+1, record[0])
+		}
+		devices = append(devices, &fleetDevice{
+			Index:    index,
+			Name:     record[1],
+			SimId:    record[2],
+			Imsi:     record[3],
+			ClientIp: record[4],
+			Config:   record[5],
+		})
+	}
+	return devices, nil
+}
+
+// writeFleetManifest writes devices sorted by index to the manifest atomically, so it is consistent with configuration
+// files even if the command is interrupted.
+func writeFleetManifest(path, format string, devices []*fleetDevice) error {
+	var b bytes.Buffer
+	if err := encodeFleetManifest(&b, format, devices); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err := f.Write(b.Bytes()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func encodeFleetManifest(w io.Writer, format string, devices []*fleetDevice) error {
+	sorted := append([]*fleetDevice{}, devices...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Index < sorted[j].Index })
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sorted)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fleetManifestHeader); err != nil {
+		return err
+	}
+	for _, d := range sorted {
+		if err := cw.Write([]string{strconv.Itoa(d.Index), d.Name, d.SimId, d.Imsi, d.ClientIp, d.Config}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
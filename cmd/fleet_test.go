@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_fleetDeviceName(t *testing.T) {
+	tmpl := template.Must(template.New("name").Option("missingkey=error").Parse(`dev-{{printf "%03d" .Index}}`))
+	name, err := fleetDeviceName(tmpl, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev-007", name)
+
+	tmpl = template.Must(template.New("name").Parse(`dev-{{.Serial}}`))
+	_, err = fleetDeviceName(tmpl, 7)
+	assert.Error(t, err)
+}
+
+func Test_fleetManifest(t *testing.T) {
+	devices := []*fleetDevice{
+		{Index: 2, Name: "dev-2", SimId: "8942310022000000002", Imsi: "295050000000002", ClientIp: "10.0.0.2", Config: "configs/arc-2.json"},
+		{Index: 1, Name: "dev-1", SimId: "8942310022000000001", Config: "configs/arc-1.json"},
+	}
+
+	for _, format := range []string{"csv", "json"} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "manifest."+format)
+
+			read, err := readFleetManifest(path, format)
+			assert.NoError(t, err)
+			assert.Empty(t, read)
+
+			assert.NoError(t, writeFleetManifest(path, format, devices))
+			read, err = readFleetManifest(path, format)
+			assert.NoError(t, err)
+			assert.Equal(t, []*fleetDevice{devices[1], devices[0]}, read)
+		})
+	}
+}
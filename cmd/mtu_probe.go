@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+var mtuProbeEndpoint string
+
+func mtuProbeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mtu-probe",
+		Short: "Discover path MTU to the SORACOM Arc server",
+		Long:  "This command will probe the path to the SORACOM Arc server with ICMP echo requests which are not allowed to be fragmented, then show the path MTU and the MTU for the tunnel interface. The endpoint in the configuration file is used unless \"--endpoint\" is specified. Raw socket requires root privilege or CAP_NET_RAW; otherwise unprivileged ICMP socket is used, which should be allowed with net.ipv4.ping_group_range sysctl on Linux.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var ip net.IP
+			if mtuProbeEndpoint != "" {
+				var endpoint soratun.UDPAddr
+				if err := endpoint.UnmarshalText([]byte(mtuProbeEndpoint)); err != nil {
+					log.Fatalf("Invalid \"--endpoint\": %v", err)
+				}
+				ip = endpoint.IP
+			} else {
+				initSoratun(cmd, args)
+				if Config.ArcSession == nil {
+					log.Fatal("Failed to determine the SORACOM Arc server endpoint. Please bootstrap or specify \"--endpoint\".")
+				}
+				ip = Config.ArcSession.ArcServerEndpoint.IP
+			}
+
+			pathMTU, err := soratun.ProbePathMTU(ip)
+			if err != nil {
+				log.Fatalf("Failed to discover path MTU: %v", err)
+			}
+
+			fmt.Printf("endpoint: %s\n  path MTU: %d\n  tunnel MTU: %d\n", ip, pathMTU, soratun.TunnelMTU(pathMTU, ip))
+		},
+	}
+
+	cmd.Flags().StringVar(&mtuProbeEndpoint, "endpoint", "", "Host or IP address to probe instead of the SORACOM Arc server in the configuration file")
+
+	return cmd
+}
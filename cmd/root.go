@@ -1,11 +1,13 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/soracom/soratun"
 	"github.com/spf13/cobra"
@@ -16,10 +18,19 @@ var (
 	Config *soratun.Config
 	// configPath holds path to SORACOM Arc client configuration file.
 	configPath string
+	// configPassphrase and configKeyFile supply the key for sealed configuration.
+	configPassphrase string
+	configKeyFile    string
 	// ctx is a context object for internal use to prove (default: Background()).
 	ctx = context.Background()
 )
 
+// environment variables to supply the key for sealed configuration.
+const (
+	configPassphraseEnv = "SORATUN_CONFIG_PASSPHRASE"
+	configKeyFileEnv    = "SORATUN_CONFIG_KEY_FILE"
+)
+
 // RootCmd defines soratun, a top level command.
 var RootCmd = &cobra.Command{
 	Use:   "soratun [command]",
@@ -28,12 +39,23 @@ var RootCmd = &cobra.Command{
 
 func init() {
 	RootCmd.PersistentFlags().StringVar(&configPath, "config", "arc.json", "Specify path to SORACOM Arc client configuration file")
+	RootCmd.PersistentFlags().StringVar(&configPassphrase, "config-passphrase", "", "Passphrase for sealed configuration. Prefer "+configPassphraseEnv+" or --config-key-file, since flags are visible to other users")
+	RootCmd.PersistentFlags().StringVar(&configKeyFile, "config-key-file", "", "Keyfile or file containing passphrase for sealed configuration. Also set with "+configKeyFileEnv)
 
 	RootCmd.AddCommand(bootstrapCmd())
+	RootCmd.AddCommand(captureCmd())
 	RootCmd.AddCommand(completionCmd())
 	RootCmd.AddCommand(configCmd())
+	RootCmd.AddCommand(downCmd())
 	RootCmd.AddCommand(dumpWireGuardConfigCmd())
+	RootCmd.AddCommand(fleetCmd())
+	RootCmd.AddCommand(mtuProbeCmd())
+	RootCmd.AddCommand(rotateKeyCmd())
+	RootCmd.AddCommand(sessionCmd())
+	RootCmd.AddCommand(shapeCmd())
+	RootCmd.AddCommand(simCmd())
 	RootCmd.AddCommand(statusCmd())
+	RootCmd.AddCommand(topCmd())
 	RootCmd.AddCommand(upCmd())
 	RootCmd.AddCommand(versionCmd())
 }
@@ -44,38 +66,92 @@ func initSoratun(_ *cobra.Command, _ []string) {
 		log.Fatalf("Error: %s\n", err)
 	}
 	Config = config
+	applyConfigDefaults(Config)
 
-	if Config.Mtu == 0 {
-		Config.Mtu = soratun.DefaultMTU
+	if os.Getenv("__SORACOM_NO_DYNAMIC_CLIENT_SETUP_FOR_TEST") != "" {
+		// NOTE:
+		// This is for WireGuard integration testing purpose. It would inject the mocked client statically.
+		fmt.Println("@@@@ DEVELOPMENT MODE @@@@ => dynamic client setup is suppressed for testing purpose")
+		return
 	}
+}
 
-	if Config.PersistentKeepalive == 0 {
-		Config.PersistentKeepalive = soratun.DefaultPersistentKeepaliveInterval
+// applyConfigDefaults fills unset values with defaults, then merges AdditionalAllowedIPs into ArcAllowedIPs.
+func applyConfigDefaults(config *soratun.Config) {
+	if config.Mtu == 0 {
+		config.Mtu = soratun.DefaultMTU
 	}
 
-	if len(Config.AdditionalAllowedIPs) > 0 {
-		Config.ArcSession.ArcAllowedIPs = append(Config.ArcSession.ArcAllowedIPs, Config.AdditionalAllowedIPs...)
+	if config.PersistentKeepalive == 0 {
+		config.PersistentKeepalive = soratun.DefaultPersistentKeepaliveInterval
 	}
 
-	if os.Getenv("__SORACOM_NO_DYNAMIC_CLIENT_SETUP_FOR_TEST") != "" {
-		// NOTE:
-		// This is for WireGuard integration testing purpose. It would inject the mocked client statically.
-		fmt.Println("@@@@ DEVELOPMENT MODE @@@@ => dynamic client setup is suppressed for testing purpose")
-		return
+	if len(config.AdditionalAllowedIPs) > 0 && config.ArcSession != nil {
+		config.ArcSession.ArcAllowedIPs = append(config.ArcSession.ArcAllowedIPs, config.AdditionalAllowedIPs...)
 	}
 }
 
 func readConfig(path string) (*soratun.Config, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %s", path)
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+
+	sealKey, err := configSealKey()
+	if err != nil {
+		return nil, err
 	}
 
-	var config soratun.Config
-	err = json.Unmarshal(b, &config)
+	// secret references and sealed values are resolved here, so the rest of soratun deals only with actual values
+	config, err := soratun.UnmarshalConfig(b, sealKey)
 	if err != nil {
-		return nil, fmt.Errorf("error while reading config file: %s", err)
+		return nil, fmt.Errorf("error while reading config file: %w", err)
 	}
 
-	return &config, nil
+	if config.ConfigPath, err = filepath.Abs(path); err != nil {
+		config.ConfigPath = path
+	}
+
+	return config, nil
+}
+
+// readExistingConfig is like readConfig, but returns nil without error if the file doesn't exist. Other errors such as
+// sealed configuration without a key or unresolvable secret references are returned, so that bootstrap never replaces
+// the existing configuration it couldn't read.
+func readExistingConfig(path string) (*soratun.Config, error) {
+	config, err := readConfig(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return config, err
+}
+
+// configSealKey returns the key for sealed configuration from --config-passphrase, --config-key-file,
+// SORATUN_CONFIG_PASSPHRASE or SORATUN_CONFIG_KEY_FILE in this order, or nil if none of them is set.
+func configSealKey() ([]byte, error) {
+	if configPassphrase != "" {
+		return []byte(configPassphrase), nil
+	}
+
+	path := configKeyFile
+	if path == "" {
+		if v := os.Getenv(configPassphraseEnv); v != "" {
+			return []byte(v), nil
+		}
+		path = os.Getenv(configKeyFileEnv)
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	// a passphrase file usually ends with a newline
+	b = bytes.TrimSuffix(bytes.TrimSuffix(b, []byte("\n")), []byte("\r"))
+	if len(b) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", path)
+	}
+	return b, nil
 }
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/soracom/soratun"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readExistingConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	config, err := readExistingConfig(filepath.Join(dir, "missing.json"))
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	// an unresolvable secret reference must not be mistaken for the first run
+	unresolved := filepath.Join(dir, "unresolved.json")
+	assert.NoError(t, os.WriteFile(unresolved, []byte(`{"simId": "8942310022000000000", "profile": {"authKeyId": "keyId-xxx", "authKey": "env:SORATUN_TEST_UNSET_AUTH_KEY"}}`), 0o600))
+	_, err = readExistingConfig(unresolved)
+	assert.Error(t, err)
+
+	config, err = soratun.UnmarshalConfig([]byte(`{"privateKey": "mPsgZYoFcWX6wpSkH6QgqKuQaoEGdqU6lbNNG0S2ZXs=", "simId": "8942310022000000000"}`), nil)
+	assert.NoError(t, err)
+	config.Seal([]byte("passphrase"))
+	sealed := filepath.Join(dir, "sealed.json")
+	assert.NoError(t, soratun.WriteConfigFile(sealed, config))
+	_, err = readExistingConfig(sealed)
+	assert.True(t, errors.Is(err, soratun.ErrConfigSealed))
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rotateKeyApply     bool
+	rotateKeyInterface string
+)
+
+func rotateKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Rotate WireGuard key pair of the SIM in the configuration file",
+		Long:  "This command will generate a new WireGuard key pair, create a new SORACOM Arc session with the public key, and save them to the configuration file. The private key never leaves the device. The previous key pair stops working immediately, so apply the new key to running \"soratun up\" process with \"--apply\", or restart it. Set \"keyRotationIntervalHours\" in the configuration file to rotate keys periodically while \"soratun up\" is running.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := signalContext()
+			defer stop()
+			client, config := newSimClient(ctx)
+			if config.SimId == "" {
+				log.Fatalf("No SIM is found in %s. Run \"soratun bootstrap authkey\" to create it", configPath)
+			}
+
+			if err := soratun.CheckKeyRotation(config); err != nil {
+				log.Fatalf("Failed to rotate key: %v", err)
+			}
+
+			privateKey, publicKey, session, err := soratun.CreateArcSessionWithNewKey(ctx, client, config.SimId)
+			if err != nil {
+				log.Fatalf("Failed to create SORACOM Arc session with new key: %v", err)
+			}
+			config.PublicKey, config.ArcSession = publicKey, session
+
+			if err := soratun.SetPrivateKey(config, privateKey); err != nil {
+				log.Fatalf("Failed to save new key, SORACOM Arc session accepts only public key %s: %v", publicKey, err)
+			}
+			if err := saveConfig(config); err != nil {
+				log.Fatalf("Failed to save new key, SORACOM Arc session accepts only public key %s: %v", publicKey, err)
+			}
+			fmt.Printf("New public key: %s\n", publicKey)
+
+			if !rotateKeyApply {
+				return
+			}
+
+			iname := rotateKeyInterface
+			if iname == "" {
+				iname = config.Interface
+			}
+			if err := soratun.CallControl(iname, "arc-session-set", &soratun.ArcSessionUpdate{ArcSession: session, PrivateKey: &privateKey}, nil); err != nil {
+				log.Fatalf("Failed to apply the new key to %s: %v", iname, err)
+			}
+			fmt.Printf("Applied the new key to %s\n", iname)
+		},
+	}
+
+	cmd.Flags().BoolVar(&rotateKeyApply, "apply", false, "Apply the new key to running \"soratun up\" process")
+	cmd.Flags().StringVar(&rotateKeyInterface, "interface", "", "Interface name of running \"soratun up\" process, defaults to \"interface\" in the configuration file")
+
+	return cmd
+}
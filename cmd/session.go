@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/manifoldco/promptui"
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sessionOutput    string
+	sessionApply     bool
+	sessionInterface string
+	sessionYes       bool
+)
+
+func sessionCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Manage SORACOM Arc session of the SIM in the configuration file",
+		Long:  "This command will manage SORACOM Arc session of the SIM in the configuration file, with \"profile\" in the configuration file. Unlike \"soratun bootstrap authkey\", it never creates a new SIM.",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.AddCommand(sessionDeleteCmd())
+	cmd.AddCommand(sessionRenewCmd())
+	cmd.AddCommand(sessionShowCmd())
+
+	return cmd
+}
+
+func sessionShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Display SORACOM Arc session in the configuration file",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := readConfig(configPath)
+			if err != nil {
+				log.Fatalf("Error: %s\n", err)
+			}
+
+			switch sessionOutput {
+			case "json":
+				printJSON(newArcSessionStatus(config.ArcSession))
+			case "text":
+				fmt.Printf("sim id: %s\n", config.SimId)
+				printArcSession(os.Stdout, newArcSessionStatus(config.ArcSession))
+			default:
+				log.Fatalf("Unknown output format \"%s\", it should be one of text or json", sessionOutput)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&sessionOutput, "output", "o", "text", "Output format, one of text or json")
+
+	return cmd
+}
+
+func sessionRenewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "renew",
+		Short: "Create a new SORACOM Arc session and update the configuration file",
+		Long:  "This command will create a new SORACOM Arc session for the SIM and the public key in the configuration file, then update only \"arcSessionStatus\" in the file. With \"--apply\", the new session is also applied to running \"soratun up\" process.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := signalContext()
+			defer stop()
+			client, config := newSimClient(ctx)
+			if config.SimId == "" {
+				log.Fatalf("No SIM is found in %s. Run \"soratun bootstrap authkey\" to create it", configPath)
+			}
+
+			session, err := client.CreateArcSession(ctx, config.SimId, config.PublicKey.AsWgKey().String())
+			if err != nil {
+				log.Fatalf("Failed to create SORACOM Arc session: %v", err)
+			}
+			config.ArcSession = session
+
+			if err := soratun.SaveArcSession(config, session); err != nil {
+				log.Fatalf("Failed to save configuration: %v", err)
+			}
+			printConfigurationFilePath()
+			fmt.Printf("sim id: %s\n", config.SimId)
+			printArcSession(os.Stdout, newArcSessionStatus(session))
+
+			if !sessionApply {
+				return
+			}
+
+			iname := sessionInterface
+			if iname == "" {
+				iname = config.Interface
+			}
+			if err := soratun.CallControl(iname, "arc-session-set", &soratun.ArcSessionUpdate{ArcSession: config.ArcSession}, nil); err != nil {
+				log.Fatalf("Failed to apply the session to %s: %v", iname, err)
+			}
+			fmt.Printf("Applied the session to %s\n", iname)
+		},
+	}
+
+	cmd.Flags().BoolVar(&sessionApply, "apply", false, "Apply the new session to running \"soratun up\" process")
+	cmd.Flags().StringVar(&sessionInterface, "interface", "", "Interface name of running \"soratun up\" process, defaults to \"interface\" in the configuration file")
+
+	return cmd
+}
+
+func sessionDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete SORACOM Arc session on the server",
+		Long:  "This command will delete SORACOM Arc session of the SIM on the server, and the device will be disconnected. The configuration file is not changed. Run \"soratun session renew\" to create a new session.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := signalContext()
+			defer stop()
+			client, config := newSimClient(ctx)
+			if config.SimId == "" {
+				log.Fatalf("No SIM is found in %s", configPath)
+			}
+
+			if !sessionYes {
+				if _, err := askInput(promptui.Prompt{
+					Label:     fmt.Sprintf("Delete SORACOM Arc session of %s", config.SimId),
+					IsConfirm: true,
+				}); err != nil {
+					log.Fatalf("Aborted")
+				}
+			}
+
+			if err := client.DeleteArcSession(ctx, config.SimId); err != nil {
+				log.Fatalf("Failed to delete SORACOM Arc session: %v", err)
+			}
+			fmt.Printf("Deleted SORACOM Arc session of %s\n", config.SimId)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&sessionYes, "yes", "y", false, "Do not ask for confirmation")
+
+	return cmd
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shapeInterface    string
+	shapeIngress      string
+	shapeEgress       string
+	shapeIngressBurst string
+	shapeEgressBurst  string
+)
+
+func shapeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shape",
+		Short: "Show or update rate limits of running SORACOM Arc interface",
+		Long:  "This command will show or update ingress (from SORACOM Arc) and egress (to SORACOM Arc) rate limits of running \"soratun up\" process. Rates are in bits per second, and burst sizes are in bytes. Both accept \"k\", \"M\", and \"G\" suffixes in SI units, e.g. \"512k\" or \"1M\". Set rate to \"0\" to remove the limit. Changes are not saved to the configuration file.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var config soratun.ShapingConfig
+			var err error
+
+			if cmd.Flags().Changed("ingress-burst") && !cmd.Flags().Changed("ingress") {
+				log.Fatalf("\"--ingress-burst\" requires \"--ingress\"")
+			}
+			if cmd.Flags().Changed("egress-burst") && !cmd.Flags().Changed("egress") {
+				log.Fatalf("\"--egress-burst\" requires \"--egress\"")
+			}
+
+			if cmd.Flags().Changed("ingress") {
+				config.Ingress, err = parseRateLimit(shapeIngress, shapeIngressBurst)
+				if err != nil {
+					log.Fatalf("Invalid ingress rate limit: %v", err)
+				}
+			}
+			if cmd.Flags().Changed("egress") {
+				config.Egress, err = parseRateLimit(shapeEgress, shapeEgressBurst)
+				if err != nil {
+					log.Fatalf("Invalid egress rate limit: %v", err)
+				}
+			}
+
+			var current soratun.ShapingConfig
+			if config.Ingress == nil && config.Egress == nil {
+				err = soratun.CallControl(shapeInterface, "shaping-get", nil, &current)
+			} else {
+				err = soratun.CallControl(shapeInterface, "shaping-set", &config, &current)
+			}
+			if err != nil {
+				log.Fatalf("Failed to update rate limits: %v", err)
+			}
+
+			fmt.Printf("interface: %s\n  ingress: %s\n  egress: %s\n", shapeInterface, current.Ingress, current.Egress)
+		},
+	}
+
+	cmd.Flags().StringVar(&shapeInterface, "interface", soratun.DefaultInterfaceName(), "Interface name to update rate limits")
+	cmd.Flags().StringVar(&shapeIngress, "ingress", "", "Ingress rate in bits per second, e.g. \"1M\"")
+	cmd.Flags().StringVar(&shapeEgress, "egress", "", "Egress rate in bits per second, e.g. \"512k\"")
+	cmd.Flags().StringVar(&shapeIngressBurst, "ingress-burst", "0", "Ingress burst size in bytes, used with \"--ingress\". 0 means 100 milliseconds worth of the rate")
+	cmd.Flags().StringVar(&shapeEgressBurst, "egress-burst", "0", "Egress burst size in bytes, used with \"--egress\". 0 means 100 milliseconds worth of the rate")
+
+	return cmd
+}
+
+func parseRateLimit(rate, burst string) (*soratun.RateLimit, error) {
+	bitsPerSecond, err := parseSIQuantity(rate)
+	if err != nil {
+		return nil, err
+	}
+	burstBytes, err := parseSIQuantity(burst)
+	if err != nil {
+		return nil, err
+	}
+	return &soratun.RateLimit{
+		BitsPerSecond: bitsPerSecond,
+		BurstBytes:    burstBytes,
+	}, nil
+}
+
+// parseSIQuantity parses a number with optional "k", "M", or "G" suffix in SI units.
+func parseSIQuantity(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := uint64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1000
+		case 'M':
+			multiplier = 1000 * 1000
+		case 'G':
+			multiplier = 1000 * 1000 * 1000
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid quantity \"%s\"", s)
+	}
+	return uint64(f * float64(multiplier)), nil
+}
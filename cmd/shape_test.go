@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/soracom/soratun"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSIQuantity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint64
+		wantErr  bool
+	}{
+		{"0", 0, false},
+		{"1500", 1500, false},
+		{" 64k ", 64000, false},
+		{"512K", 512000, false},
+		{"1M", 1000000, false},
+		{"1.5G", 1500000000, false},
+		{"0.5k", 500, false},
+		{"", 0, true},
+		{"k", 0, true},
+		{"-1", 0, true},
+		{"-1M", 0, true},
+		{"1m", 0, true},
+		{"abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSIQuantity(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func Test_parseRateLimit(t *testing.T) {
+	l, err := parseRateLimit("512k", "64k")
+	assert.NoError(t, err)
+	assert.Equal(t, &soratun.RateLimit{BitsPerSecond: 512000, BurstBytes: 64000}, l)
+
+	l, err = parseRateLimit("0", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, &soratun.RateLimit{}, l)
+
+	_, err = parseRateLimit("fast", "0")
+	assert.Error(t, err)
+
+	_, err = parseRateLimit("1M", "big")
+	assert.Error(t, err)
+}
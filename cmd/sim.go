@@ -0,0 +1,416 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/manifoldco/promptui"
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+)
+
+// simListPageSize is the number of SIMs to get with a single API request.
+const simListPageSize = 100
+
+var (
+	simOutput     string
+	simLimit      int
+	simUnsetGroup bool
+	simYes        bool
+)
+
+// simStatus is a SIM displayed by `soratun sim`. Unlike soratun.VirtualSim, it has no private key.
+type simStatus struct {
+	SimId      string            `json:"simId"`
+	Name       string            `json:"name,omitempty"`
+	Status     string            `json:"status"`
+	GroupId    string            `json:"groupId,omitempty"`
+	Imsi       string            `json:"imsi,omitempty"`
+	ArcSession *arcSessionStatus `json:"arcSessionStatus,omitempty"`
+}
+
+// arcSessionStatus is a SORACOM Arc session displayed by `soratun sim` and `soratun session`. Unlike
+// soratun.ArcSession, it has no private key.
+type arcSessionStatus struct {
+	ArcServerPeerPublicKey soratun.Key      `json:"arcServerPeerPublicKey"`
+	ArcServerEndpoint      *soratun.UDPAddr `json:"arcServerEndpoint"`
+	ArcAllowedIPs          []*soratun.IPNet `json:"arcAllowedIPs"`
+	ArcClientPeerIpAddress net.IP           `json:"arcClientPeerIpAddress,omitempty"`
+}
+
+func simCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sim",
+		Short: "Manage virtual SIMs with SORACOM API",
+		Long:  "This command will manage virtual SIMs with SORACOM API, with \"profile\" in the configuration file. Run \"soratun bootstrap authkey\" to save the profile.",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.AddCommand(simListCmd())
+	cmd.AddCommand(simSetGroupCmd())
+	cmd.AddCommand(simSetNameCmd())
+	cmd.AddCommand(simShowCmd())
+	cmd.AddCommand(simSuspendCmd())
+	cmd.AddCommand(simTerminateCmd())
+
+	return cmd
+}
+
+func simListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List SIMs",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkSimOutput()
+			ctx, stop := signalContext()
+			defer stop()
+			client, _ := newSimClient(ctx)
+
+			list, err := listSims(ctx, client, simLimit)
+			if err != nil {
+				log.Fatalf("Failed to list SIMs: %v", err)
+			}
+			sims := make([]*simStatus, 0, len(list))
+			for _, sim := range list {
+				sims = append(sims, newSimStatus(sim))
+			}
+
+			if simOutput == "json" {
+				printJSON(sims)
+				return
+			}
+			printSims(os.Stdout, sims)
+		},
+	}
+
+	cmd.Flags().StringVarP(&simOutput, "output", "o", "text", "Output format, one of text or json")
+	cmd.Flags().IntVar(&simLimit, "limit", 0, "Maximum number of SIMs to list, 0 to list all")
+
+	return cmd
+}
+
+func simShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show [sim-id]",
+		Short: "Display SIM and its SORACOM Arc session",
+		Long:  "This command will display the SIM and its SORACOM Arc session status. If SIM ID is omitted, the SIM in the configuration file is displayed.",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			checkSimOutput()
+			ctx, stop := signalContext()
+			defer stop()
+			client, config := newSimClient(ctx)
+
+			simId := ""
+			if len(args) > 0 {
+				simId = args[0]
+			} else {
+				simId = config.SimId
+			}
+			if simId == "" {
+				log.Fatalf("No SIM ID is specified, and no SIM is found in %s", configPath)
+			}
+
+			sim, err := client.GetSim(ctx, simId)
+			if err != nil {
+				log.Fatalf("Failed to get SIM: %v", err)
+			}
+
+			s := newSimStatus(sim)
+			if simOutput == "json" {
+				printJSON(s)
+				return
+			}
+			printSim(os.Stdout, s)
+		},
+	}
+
+	cmd.Flags().StringVarP(&simOutput, "output", "o", "text", "Output format, one of text or json")
+
+	return cmd
+}
+
+func simSetNameCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set-name <sim-id> <name>",
+		Short: "Set name of the SIM",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := signalContext()
+			defer stop()
+			client, _ := newSimClient(ctx)
+
+			sim, err := client.SetSimName(ctx, args[0], args[1])
+			if err != nil {
+				log.Fatalf("Failed to set name: %v", err)
+			}
+			printSim(os.Stdout, newSimStatus(sim))
+		},
+	}
+}
+
+func simSetGroupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-group <sim-id> [group-id]",
+		Short: "Move the SIM to the group",
+		Long:  "This command will move the SIM to the group, or remove the SIM from its group with \"--unset\".",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			groupId := ""
+			if len(args) == 2 {
+				groupId = args[1]
+			}
+			if (groupId == "") != simUnsetGroup {
+				log.Fatalf("Specify either group ID or \"--unset\"")
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+			client, _ := newSimClient(ctx)
+
+			sim, err := client.SetSimGroup(ctx, args[0], groupId)
+			if err != nil {
+				log.Fatalf("Failed to set group: %v", err)
+			}
+			printSim(os.Stdout, newSimStatus(sim))
+		},
+	}
+
+	cmd.Flags().BoolVar(&simUnsetGroup, "unset", false, "Remove the SIM from its group")
+
+	return cmd
+}
+
+func simSuspendCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "suspend <sim-id>",
+		Short: "Suspend the SIM",
+		Long:  "This command will suspend the SIM. The device can't connect to SORACOM Arc until the SIM is activated again, e.g. from SORACOM User Console.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if !simYes {
+				if _, err := askInput(promptui.Prompt{
+					Label:     fmt.Sprintf("Suspend %s", args[0]),
+					IsConfirm: true,
+				}); err != nil {
+					log.Fatalf("Aborted")
+				}
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+			client, _ := newSimClient(ctx)
+
+			sim, err := client.SuspendSim(ctx, args[0])
+			if err != nil {
+				log.Fatalf("Failed to suspend SIM: %v", err)
+			}
+			printSim(os.Stdout, newSimStatus(sim))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&simYes, "yes", "y", false, "Do not ask for confirmation")
+
+	return cmd
+}
+
+func simTerminateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "terminate <sim-id>",
+		Short: "Terminate the SIM",
+		Long:  "This command will disable termination protection of the SIM, and terminate it. It can't be undone.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			simId := args[0]
+			if !simYes {
+				// a y/N prompt is too easy to accept by mistake for what can't be undone
+				if _, err := askInput(promptui.Prompt{
+					Label: fmt.Sprintf("Terminating %s can't be undone. Type the SIM ID to confirm", simId),
+					Validate: func(input string) error {
+						if input != simId {
+							return errors.New("SIM ID does not match")
+						}
+						return nil
+					},
+				}); err != nil {
+					log.Fatalf("Aborted")
+				}
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+			client, _ := newSimClient(ctx)
+
+			sim, err := terminateSim(ctx, client, simId)
+			if err != nil {
+				log.Fatalf("Failed to terminate SIM: %v", err)
+			}
+			printSim(os.Stdout, newSimStatus(sim))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&simYes, "yes", "y", false, "Do not ask for confirmation")
+
+	return cmd
+}
+
+// terminateSim disables termination protection of the SIM, which is enabled by default, and terminates it.
+func terminateSim(ctx context.Context, client soratun.SoracomClient, simId string) (*soratun.VirtualSim, error) {
+	if _, err := client.EnableSimTermination(ctx, simId); err != nil {
+		return nil, fmt.Errorf("failed to disable termination protection: %w", err)
+	}
+	return client.TerminateSim(ctx, simId)
+}
+
+// signalContext returns a context which is cancelled with Ctrl-C or SIGTERM, to abort in-flight API requests.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// newSimClient returns SORACOM API client with the profile in the configuration file, and the configuration.
+func newSimClient(ctx context.Context) (soratun.SoracomClient, *soratun.Config) {
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error: %s\n", err)
+	}
+	if config.Profile == nil {
+		log.Fatalf("No profile is found in %s. Run \"soratun bootstrap authkey\" to save it", configPath)
+	}
+
+	client, err := soratun.NewDefaultSoracomClient(ctx, *config.Profile)
+	if err != nil {
+		log.Fatalf("Failed to create SORACOM API client: %v", err)
+	}
+
+	if v := os.Getenv("SORACOM_VERBOSE"); v != "" {
+		client.SetVerbose(true)
+	}
+
+	return client, config
+}
+
+// listSims returns up to limit SIMs, or all SIMs if limit is 0, requesting them page by page.
+func listSims(ctx context.Context, client soratun.SoracomClient, limit int) ([]*soratun.VirtualSim, error) {
+	var sims []*soratun.VirtualSim
+	key := ""
+	for {
+		pageSize := simListPageSize
+		if limit > 0 && limit-len(sims) < pageSize {
+			pageSize = limit - len(sims)
+		}
+
+		page, next, err := client.ListSims(ctx, pageSize, key)
+		if err != nil {
+			return nil, err
+		}
+		sims = append(sims, page...)
+
+		if next == "" || (limit > 0 && len(sims) >= limit) {
+			return sims, nil
+		}
+		key = next
+	}
+}
+
+func checkSimOutput() {
+	switch simOutput {
+	case "text", "json":
+	default:
+		log.Fatalf("Unknown output format \"%s\", it should be one of text or json", simOutput)
+	}
+}
+
+func newSimStatus(sim *soratun.VirtualSim) *simStatus {
+	s := &simStatus{
+		SimId:   sim.SimId,
+		Name:    sim.Tags["name"],
+		Status:  sim.Status,
+		GroupId: sim.GroupId,
+	}
+	if p, ok := sim.Profiles[sim.SimId]; ok {
+		s.Imsi = p.PrimaryImsi
+	}
+	s.ArcSession = newArcSessionStatus(&sim.ArcSession)
+	return s
+}
+
+// newArcSessionStatus returns the session without the private key, or nil if the SIM has no session.
+func newArcSessionStatus(a *soratun.ArcSession) *arcSessionStatus {
+	if a == nil || a.ArcServerEndpoint == nil {
+		return nil
+	}
+	return &arcSessionStatus{
+		ArcServerPeerPublicKey: a.ArcServerPeerPublicKey,
+		ArcServerEndpoint:      a.ArcServerEndpoint,
+		ArcAllowedIPs:          a.ArcAllowedIPs,
+		ArcClientPeerIpAddress: a.ArcClientPeerIpAddress,
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("Failed to print: %v", err)
+	}
+}
+
+func printSims(w io.Writer, sims []*simStatus) {
+	if len(sims) == 0 {
+		fmt.Fprintln(w, "no SIM found")
+		return
+	}
+
+	const row = "%-20s %-24s %-12s %-38s %s\n"
+	fmt.Fprintf(w, row, "SIM ID", "NAME", "STATUS", "GROUP", "CLIENT IP")
+	for _, s := range sims {
+		ip := ""
+		if s.ArcSession != nil && s.ArcSession.ArcClientPeerIpAddress != nil {
+			ip = s.ArcSession.ArcClientPeerIpAddress.String()
+		}
+		fmt.Fprintf(w, row, s.SimId, dash(s.Name), s.Status, dash(s.GroupId), dash(ip))
+	}
+}
+
+func printSim(w io.Writer, s *simStatus) {
+	fmt.Fprintf(w, "sim id: %s\n", s.SimId)
+	fmt.Fprintf(w, "  name: %s\n", dash(s.Name))
+	fmt.Fprintf(w, "  status: %s\n", s.Status)
+	fmt.Fprintf(w, "  group: %s\n", dash(s.GroupId))
+	if s.Imsi != "" {
+		fmt.Fprintf(w, "  imsi: %s\n", s.Imsi)
+	}
+
+	printArcSession(w, s.ArcSession)
+}
+
+// printArcSession writes the session indented under a SIM.
+func printArcSession(w io.Writer, a *arcSessionStatus) {
+	if a == nil {
+		fmt.Fprintln(w, "  arc session: none")
+		return
+	}
+
+	allowedIPs := make([]string, 0, len(a.ArcAllowedIPs))
+	for _, n := range a.ArcAllowedIPs {
+		b, _ := n.MarshalText()
+		allowedIPs = append(allowedIPs, string(b))
+	}
+	endpoint, _ := a.ArcServerEndpoint.MarshalText()
+
+	fmt.Fprintln(w, "  arc session:")
+	fmt.Fprintf(w, "    server endpoint: %s\n", endpoint)
+	fmt.Fprintf(w, "    server public key: %s\n", a.ArcServerPeerPublicKey)
+	fmt.Fprintf(w, "    allowed ips: %s\n", strings.Join(allowedIPs, ", "))
+	fmt.Fprintf(w, "    client ip: %s\n", a.ArcClientPeerIpAddress)
+}
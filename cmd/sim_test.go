@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/soracom/soratun"
+	mock_soratun "github.com/soracom/soratun/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func Test_printSims(t *testing.T) {
+	var b bytes.Buffer
+	printSims(&b, nil)
+	assert.Equal(t, "no SIM found\n", b.String())
+
+	b.Reset()
+	printSims(&b, []*simStatus{
+		newSimStatus(&soratun.VirtualSim{
+			SimId:  "8942310022000000000",
+			Status: "active",
+			Tags:   map[string]string{"name": "sensor-1"},
+			ArcSession: soratun.ArcSession{
+				ArcServerEndpoint:      &soratun.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 11010},
+				ArcClientPeerIpAddress: net.ParseIP("10.0.0.1"),
+			},
+		}),
+		newSimStatus(&soratun.VirtualSim{SimId: "8942310022000000001", Status: "suspended", GroupId: "group-1"}),
+	})
+	out := b.String()
+	assert.Contains(t, out, "SIM ID")
+	assert.Regexp(t, `8942310022000000000 +sensor-1 +active +- +10\.0\.0\.1`, out)
+	assert.Regexp(t, `8942310022000000001 +- +suspended +group-1 +-`, out)
+}
+
+func Test_printSim(t *testing.T) {
+	var b bytes.Buffer
+	printSim(&b, newSimStatus(&soratun.VirtualSim{
+		SimId:    "8942310022000000000",
+		Status:   "active",
+		Profiles: map[string]soratun.SimProfile{"8942310022000000000": {PrimaryImsi: "001010000000000"}},
+	}))
+	out := b.String()
+	assert.Contains(t, out, "sim id: 8942310022000000000\n")
+	assert.Contains(t, out, "  name: -\n")
+	assert.Contains(t, out, "  imsi: 001010000000000\n")
+	assert.Contains(t, out, "  arc session: none\n")
+}
+
+func Test_newSimStatus_noPrivateKey(t *testing.T) {
+	sim := &soratun.VirtualSim{
+		SimId:  "8942310022000000000",
+		Status: "active",
+		ArcSession: soratun.ArcSession{
+			ArcServerEndpoint:       &soratun.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 11010},
+			ArcClientPeerPrivateKey: soratun.Key{1, 2, 3},
+			ArcClientPeerIpAddress:  net.ParseIP("10.0.0.1"),
+		},
+	}
+
+	b, err := json.Marshal(newSimStatus(sim))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "arcClientPeerPrivateKey")
+	assert.Contains(t, string(b), `"arcClientPeerIpAddress":"10.0.0.1"`)
+
+	assert.Nil(t, newSimStatus(&soratun.VirtualSim{SimId: "8942310022000000001"}).ArcSession)
+}
+
+func Test_terminateSim(t *testing.T) {
+	ctx := context.Background()
+	simId := "8942310022000000000"
+
+	t.Run("ok", func(t *testing.T) {
+		client := mock_soratun.NewMockSoracomClient(gomock.NewController(t))
+		gomock.InOrder(
+			client.EXPECT().EnableSimTermination(ctx, simId).Return(&soratun.VirtualSim{SimId: simId}, nil),
+			client.EXPECT().TerminateSim(ctx, simId).Return(&soratun.VirtualSim{SimId: simId, Status: "terminated"}, nil),
+		)
+
+		sim, err := terminateSim(ctx, client, simId)
+		assert.NoError(t, err)
+		assert.Equal(t, "terminated", sim.Status)
+	})
+
+	t.Run("protection not disabled", func(t *testing.T) {
+		client := mock_soratun.NewMockSoracomClient(gomock.NewController(t))
+		client.EXPECT().EnableSimTermination(ctx, simId).Return(nil, errors.New("forbidden"))
+
+		_, err := terminateSim(ctx, client, simId)
+		assert.ErrorContains(t, err, "forbidden")
+	})
+}
@@ -1,22 +1,92 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/soracom/soratun"
 	"github.com/spf13/cobra"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
 )
 
+var (
+	statusOutput   string
+	statusAll      bool
+	statusInterval time.Duration
+)
+
+// statusReport is the output of `soratun status`.
+type statusReport struct {
+	Interfaces []*interfaceStatus `json:"interfaces" yaml:"interfaces"`
+}
+
+// interfaceStatus holds WireGuard device status, and soratun process information if the interface is managed by soratun.
+type interfaceStatus struct {
+	Interface              string        `json:"interface" yaml:"interface"`
+	Type                   string        `json:"type" yaml:"type"`
+	Managed                bool          `json:"managed" yaml:"managed"`
+	SimId                  string        `json:"simId,omitempty" yaml:"simId,omitempty"`
+	ArcClientPeerIpAddress string        `json:"arcClientPeerIpAddress,omitempty" yaml:"arcClientPeerIpAddress,omitempty"`
+	ConfigPath             string        `json:"configPath,omitempty" yaml:"configPath,omitempty"`
+	Pid                    int           `json:"pid,omitempty" yaml:"pid,omitempty"`
+	StartedAt              *time.Time    `json:"startedAt,omitempty" yaml:"startedAt,omitempty"`
+	Uptime                 string        `json:"uptime,omitempty" yaml:"uptime,omitempty"`
+	UptimeSeconds          int64         `json:"uptimeSeconds,omitempty" yaml:"uptimeSeconds,omitempty"`
+	PublicKey              string        `json:"publicKey" yaml:"publicKey"`
+	ListenPort             int           `json:"listenPort" yaml:"listenPort"`
+	Mtu                    int           `json:"mtu,omitempty" yaml:"mtu,omitempty"`
+	Peers                  []*peerStatus `json:"peers" yaml:"peers"`
+	// DataUsage is data usage of the SIM, if the configuration file of the interface has data usage accounting enabled.
+	DataUsage *dataUsageStatus `json:"dataUsage,omitempty" yaml:"dataUsage,omitempty"`
+}
+
+// peerStatus holds WireGuard peer status. Rates are calculated from two samples, and are 0 if sampling is disabled.
+type peerStatus struct {
+	PublicKey             string     `json:"publicKey" yaml:"publicKey"`
+	Endpoint              string     `json:"endpoint" yaml:"endpoint"`
+	AllowedIPs            []string   `json:"allowedIPs" yaml:"allowedIPs"`
+	LatestHandshake       *time.Time `json:"latestHandshake,omitempty" yaml:"latestHandshake,omitempty"`
+	LatestHandshakeAge    string     `json:"latestHandshakeAge" yaml:"latestHandshakeAge"`
+	ReceivedBytes         int64      `json:"receivedBytes" yaml:"receivedBytes"`
+	SentBytes             int64      `json:"sentBytes" yaml:"sentBytes"`
+	ReceiveBitsPerSecond  uint64     `json:"receiveBitsPerSecond" yaml:"receiveBitsPerSecond"`
+	TransmitBitsPerSecond uint64     `json:"transmitBitsPerSecond" yaml:"transmitBitsPerSecond"`
+}
+
+// dataUsageStatus holds data usage of the SIM of an interface.
+type dataUsageStatus struct {
+	SimId        string           `json:"simId" yaml:"simId"`
+	Day          string           `json:"day" yaml:"day"`
+	DailyBytes   uint64           `json:"dailyBytes" yaml:"dailyBytes"`
+	DailyCap     *soratun.DataCap `json:"dailyCap,omitempty" yaml:"dailyCap,omitempty"`
+	Month        string           `json:"month" yaml:"month"`
+	MonthlyBytes uint64           `json:"monthlyBytes" yaml:"monthlyBytes"`
+	MonthlyCap   *soratun.DataCap `json:"monthlyCap,omitempty" yaml:"monthlyCap,omitempty"`
+	StatePath    string           `json:"statePath" yaml:"statePath"`
+}
+
 func statusCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:     "status",
 		Aliases: []string{"s"},
 		Short:   "Display SORACOM Arc interface status",
+		Long:    "This command will display status of WireGuard interfaces managed by running \"soratun up\" processes, which are found through their control sockets. Use \"--all\" to include other WireGuard interfaces. Throughput rates are calculated from two samples taken \"--interval\" apart.",
 		Args:    cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
+			switch statusOutput {
+			case "text", "json", "yaml":
+			default:
+				log.Fatalf("Unknown output format \"%s\", it should be one of text, json, or yaml", statusOutput)
+			}
+
 			c, err := wgctrl.New()
 			if err != nil {
 				log.Fatalf("failed to open wgctrl: %v", err)
@@ -29,63 +99,348 @@ func statusCmd() *cobra.Command {
 				}
 			}()
 
-			var devices []*wgtypes.Device
-			devices, err = c.Devices()
+			report, err := collectStatus(c, statusAll, statusInterval)
 			if err != nil {
 				log.Fatalf("failed to get devices: %v", err)
 			}
 
-			if len(devices) == 0 {
-				fmt.Println("no SORACOM Arc device found")
+			switch statusOutput {
+			case "json":
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(report)
+			case "yaml":
+				enc := yaml.NewEncoder(os.Stdout)
+				enc.SetIndent(2)
+				err = enc.Encode(report)
+			default:
+				printStatus(os.Stdout, report)
 			}
-			for _, d := range devices {
-				printDevice(d)
-
-				for _, p := range d.Peers {
-					printPeer(p)
-				}
+			if err != nil {
+				log.Fatalf("failed to write status: %v", err)
 			}
 		},
 	}
+
+	cmd.Flags().StringVarP(&statusOutput, "output", "o", "text", "Output format, one of text, json, or yaml")
+	cmd.Flags().BoolVar(&statusAll, "all", false, "Include WireGuard interfaces which are not managed by soratun")
+	cmd.Flags().DurationVar(&statusInterval, "interval", time.Second, "Interval between two samples to calculate throughput rates, 0 to skip")
+
+	return cmd
+}
+
+// collectStatus returns status of soratun managed interfaces, or all WireGuard interfaces if all is true.
+func collectStatus(c *wgctrl.Client, all bool, interval time.Duration) (*statusReport, error) {
+	devices, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &statusReport{Interfaces: []*interfaceStatus{}}
+	now := time.Now()
+	for _, d := range devices {
+		var info soratun.TunnelInfo
+		if err := soratun.CallControl(d.Name, "info", nil, &info); err == nil {
+			report.Interfaces = append(report.Interfaces, newInterfaceStatus(d, &info, now))
+		} else if all {
+			report.Interfaces = append(report.Interfaces, newInterfaceStatus(d, nil, now))
+		}
+	}
+
+	if interval <= 0 || len(report.Interfaces) == 0 {
+		return report, nil
+	}
+
+	time.Sleep(interval)
+	for _, s := range report.Interfaces {
+		d, err := c.Device(s.Interface)
+		if err != nil {
+			continue
+		}
+		s.updateRates(d, interval)
+	}
+	return report, nil
 }
 
-func printDevice(d *wgtypes.Device) {
-	const f = `interface: %s (%s)
-  public key: %s
+func newInterfaceStatus(d *wgtypes.Device, info *soratun.TunnelInfo, now time.Time) *interfaceStatus {
+	s := &interfaceStatus{
+		Interface:  d.Name,
+		Type:       d.Type.String(),
+		PublicKey:  d.PublicKey.String(),
+		ListenPort: d.ListenPort,
+		Peers:      []*peerStatus{},
+	}
+
+	if info != nil {
+		s.Managed = true
+		s.SimId = info.SimId
+		if info.ArcClientPeerIpAddress != nil {
+			s.ArcClientPeerIpAddress = info.ArcClientPeerIpAddress.String()
+		}
+		s.ConfigPath = info.ConfigPath
+		s.DataUsage = collectDataUsage(info.ConfigPath, info.SimId)
+		s.Pid = info.Pid
+		s.StartedAt = &info.StartedAt
+		uptime := now.Sub(info.StartedAt).Truncate(time.Second)
+		s.Uptime = humanizeDuration(uptime)
+		s.UptimeSeconds = int64(uptime.Seconds())
+		s.Mtu = info.Mtu
+	} else if iface, err := net.InterfaceByName(d.Name); err == nil {
+		s.Mtu = iface.MTU
+	}
+
+	for _, p := range d.Peers {
+		s.Peers = append(s.Peers, newPeerStatus(p, now))
+	}
+	return s
+}
+
+func newPeerStatus(p wgtypes.Peer, now time.Time) *peerStatus {
+	ips := make([]string, 0, len(p.AllowedIPs))
+	for _, ip := range p.AllowedIPs {
+		ips = append(ips, ip.String())
+	}
+
+	s := &peerStatus{
+		PublicKey:          p.PublicKey.String(),
+		AllowedIPs:         ips,
+		LatestHandshakeAge: "never",
+		ReceivedBytes:      p.ReceiveBytes,
+		SentBytes:          p.TransmitBytes,
+	}
+	if p.Endpoint != nil {
+		s.Endpoint = p.Endpoint.String()
+	}
+	if !p.LastHandshakeTime.IsZero() {
+		handshake := p.LastHandshakeTime
+		s.LatestHandshake = &handshake
+		s.LatestHandshakeAge = humanizeDuration(now.Sub(handshake).Truncate(time.Second)) + " ago"
+	}
+	return s
+}
+
+// updateRates calculates throughput rates from the second sample d, which was taken interval after the first one.
+func (s *interfaceStatus) updateRates(d *wgtypes.Device, interval time.Duration) {
+	for _, p := range d.Peers {
+		for _, ps := range s.Peers {
+			if ps.PublicKey != p.PublicKey.String() {
+				continue
+			}
+			ps.ReceiveBitsPerSecond = bitsPerSecond(p.ReceiveBytes-ps.ReceivedBytes, interval)
+			ps.TransmitBitsPerSecond = bitsPerSecond(p.TransmitBytes-ps.SentBytes, interval)
+			ps.ReceivedBytes, ps.SentBytes = p.ReceiveBytes, p.TransmitBytes
+		}
+	}
+}
+
+func bitsPerSecond(bytes int64, interval time.Duration) uint64 {
+	if bytes <= 0 {
+		return 0
+	}
+	return uint64(float64(bytes*8) / interval.Seconds())
+}
+
+// collectDataUsage returns data usage against each cap, if the configuration file has data usage accounting enabled.
+// Only "simId" and "dataUsage" are read from the configuration file, so secrets are never resolved, e.g. "exec:"
+// helpers are not executed, just to display counters. simId of the running tunnel is used if not empty.
+func collectDataUsage(configPath, simId string) *dataUsageStatus {
+	if configPath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil
+	}
+	var config struct {
+		SimId     string                   `json:"simId"`
+		DataUsage *soratun.DataUsageConfig `json:"dataUsage"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil || config.DataUsage == nil {
+		return nil
+	}
+	if simId == "" {
+		simId = config.SimId
+	}
+
+	state, err := soratun.ReadDataUsageState(config.DataUsage.Path())
+	if err != nil {
+		log.Printf("failed to read data usage: %v", err)
+		return nil
+	}
+
+	var usage soratun.DataUsage
+	if u, ok := state[simId]; ok {
+		usage = *u
+	}
+	usage = usage.Current(time.Now())
+
+	return &dataUsageStatus{
+		SimId:        simId,
+		Day:          usage.Day,
+		DailyBytes:   usage.DailyBytes(),
+		DailyCap:     config.DataUsage.Daily,
+		Month:        usage.Month,
+		MonthlyBytes: usage.MonthlyBytes(),
+		MonthlyCap:   config.DataUsage.Monthly,
+		StatePath:    config.DataUsage.Path(),
+	}
+}
+
+// printStatus writes the report in human-readable text.
+func printStatus(w io.Writer, report *statusReport) {
+	if len(report.Interfaces) == 0 {
+		fmt.Fprintln(w, "no SORACOM Arc device found")
+	}
+	for _, s := range report.Interfaces {
+		printInterface(w, s)
+
+		for _, p := range s.Peers {
+			printPeer(w, p)
+		}
+
+		if s.DataUsage != nil {
+			printDataUsage(w, s.DataUsage)
+		}
+	}
+}
+
+func printInterface(w io.Writer, s *interfaceStatus) {
+	fmt.Fprintf(w, "interface: %s (%s)\n", s.Interface, s.Type)
+	if s.Managed {
+		fmt.Fprintf(w, "  sim id: %s\n", s.SimId)
+		fmt.Fprintf(w, "  client ip: %s\n", s.ArcClientPeerIpAddress)
+		if s.ConfigPath != "" {
+			fmt.Fprintf(w, "  config: %s\n", s.ConfigPath)
+		}
+		fmt.Fprintf(w, "  pid: %d\n", s.Pid)
+		fmt.Fprintf(w, "  uptime: %s\n", s.Uptime)
+	}
+
+	const f = `  public key: %s
   private key: (hidden)
   listening port: %d
+  mtu: %s
 
 `
 
-	fmt.Printf(
+	mtu := "unknown"
+	if s.Mtu > 0 {
+		mtu = fmt.Sprint(s.Mtu)
+	}
+
+	fmt.Fprintf(
+		w,
 		f,
-		d.Name,
-		d.Type.String(),
-		d.PublicKey.String(),
-		d.ListenPort)
+		s.PublicKey,
+		s.ListenPort,
+		mtu)
 }
 
-func printPeer(p wgtypes.Peer) {
+func printPeer(w io.Writer, p *peerStatus) {
 	const f = `peer: %s
   endpoint: %s
   allowed ips: %s
   latest handshake: %s
   transfer: %d B received, %d B sent
+  rate: %s received, %s sent
 
 `
 
-	ips := make([]string, 0, len(p.AllowedIPs))
-	for _, ip := range p.AllowedIPs {
-		ips = append(ips, ip.String())
-	}
+	fmt.Fprintf(
+		w,
+		f,
+		p.PublicKey,
+		p.Endpoint,
+		strings.Join(p.AllowedIPs, ", "),
+		p.LatestHandshakeAge,
+		p.ReceivedBytes,
+		p.SentBytes,
+		formatSI(p.ReceiveBitsPerSecond, "bit/s"),
+		formatSI(p.TransmitBitsPerSecond, "bit/s"),
+	)
+}
+
+// printDataUsage prints data usage against each cap.
+func printDataUsage(w io.Writer, u *dataUsageStatus) {
+	const f = `data usage: %s
+  daily (%s): %s
+  monthly (%s): %s
+  state file: %s
+
+`
 
-	fmt.Printf(
+	fmt.Fprintf(
+		w,
 		f,
-		p.PublicKey.String(),
-		p.Endpoint.String(),
-		strings.Join(ips, ", "),
-		p.LastHandshakeTime.String(),
-		p.ReceiveBytes,
-		p.TransmitBytes,
+		u.SimId,
+		u.Day,
+		formatDataCap(u.DailyBytes, u.DailyCap),
+		u.Month,
+		formatDataCap(u.MonthlyBytes, u.MonthlyCap),
+		u.StatePath,
 	)
 }
+
+func formatDataCap(used uint64, dataCap *soratun.DataCap) string {
+	if dataCap == nil {
+		return fmt.Sprintf("%s (no cap)", formatBytes(used))
+	}
+
+	var percentage float64
+	if dataCap.Bytes > 0 {
+		percentage = float64(used) / float64(dataCap.Bytes) * 100
+	}
+	return fmt.Sprintf("%s / %s (%.1f%%), action: %s", formatBytes(used), formatBytes(dataCap.Bytes), percentage, dataCap.Action)
+}
+
+// formatBytes returns human-readable bytes in SI units, e.g. "1.5 MB".
+func formatBytes(b uint64) string {
+	return formatSI(b, "B")
+}
+
+// formatSI returns human-readable value with SI prefix, e.g. "1.5 Mbit/s".
+func formatSI(v uint64, unit string) string {
+	const base = 1000
+	if v < base {
+		return fmt.Sprintf("%d %s", v, unit)
+	}
+	div, exp := uint64(base), 0
+	for n := v / base; n >= base; n /= base {
+		div *= base
+		exp++
+	}
+	return fmt.Sprintf("%.1f %c%s", float64(v)/float64(div), "kMGTPE"[exp], unit)
+}
+
+// humanizeDuration returns a duration like "1 hour, 2 minutes, 3 seconds", in the same manner as `wg show`.
+func humanizeDuration(d time.Duration) string {
+	seconds := int64(d.Seconds())
+	if seconds <= 0 {
+		return "0 seconds"
+	}
+
+	units := []struct {
+		name    string
+		seconds int64
+	}{
+		{"day", 24 * 60 * 60},
+		{"hour", 60 * 60},
+		{"minute", 60},
+		{"second", 1},
+	}
+
+	var parts []string
+	for _, u := range units {
+		n := seconds / u.seconds
+		seconds %= u.seconds
+		if n == 0 {
+			continue
+		}
+		if n == 1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, u.name))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d %ss", n, u.name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
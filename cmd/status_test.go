@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_humanizeDuration(t *testing.T) {
+	assert.Equal(t, "0 seconds", humanizeDuration(0))
+	assert.Equal(t, "1 second", humanizeDuration(time.Second))
+	assert.Equal(t, "1 minute, 5 seconds", humanizeDuration(65*time.Second))
+	assert.Equal(t, "2 days, 1 hour", humanizeDuration(49*time.Hour))
+}
+
+func Test_printStatus(t *testing.T) {
+	startedAt := time.Now().Add(-time.Hour)
+	report := &statusReport{
+		Interfaces: []*interfaceStatus{
+			{
+				Interface:              "soratun0",
+				Type:                   "Userspace",
+				Managed:                true,
+				SimId:                  "8942310022000000000",
+				ArcClientPeerIpAddress: "10.0.0.1",
+				ConfigPath:             "/etc/soratun/arc.json",
+				Pid:                    1234,
+				StartedAt:              &startedAt,
+				Uptime:                 "1 hour",
+				Mtu:                    1380,
+				Peers: []*peerStatus{
+					{
+						PublicKey:             "server",
+						Endpoint:              "192.0.2.1:11010",
+						AllowedIPs:            []string{"100.127.0.0/16"},
+						LatestHandshakeAge:    "5 seconds ago",
+						ReceivedBytes:         100,
+						SentBytes:             200,
+						ReceiveBitsPerSecond:  1500,
+						TransmitBitsPerSecond: 800,
+					},
+				},
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	printStatus(&b, report)
+	out := b.String()
+	assert.Contains(t, out, "interface: soratun0 (Userspace)")
+	assert.Contains(t, out, "  sim id: 8942310022000000000")
+	assert.Contains(t, out, "  uptime: 1 hour")
+	assert.Contains(t, out, "  mtu: 1380")
+	assert.Contains(t, out, "  latest handshake: 5 seconds ago")
+	assert.Contains(t, out, "  rate: 1.5 kbit/s received, 800 bit/s sent")
+
+	b.Reset()
+	printStatus(&b, &statusReport{})
+	assert.Equal(t, "no SORACOM Arc device found\n", b.String())
+}
+
+func Test_collectDataUsage(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "usage.json")
+	month := time.Now().Format("2006-01")
+	assert.NoError(t, os.WriteFile(statePath, []byte(fmt.Sprintf(`{"8942310022000000001":{"month":"%s","monthlyTransmitBytes":100,"monthlyReceiveBytes":200}}`, month)), 0o600))
+
+	// secrets are not resolved, so the helper is never executed
+	executed := filepath.Join(dir, "executed")
+	configPath := filepath.Join(dir, "arc.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{
+  "simId": "8942310022000000000",
+  "privateKey": "exec:touch `+executed+`",
+  "dataUsage": {"statePath": "`+statePath+`", "monthly": {"bytes": 1000, "action": "block"}}
+}`), 0o600))
+
+	// SIM ID of the running tunnel takes precedence over the configuration file
+	u := collectDataUsage(configPath, "8942310022000000001")
+	assert.NotNil(t, u)
+	assert.Equal(t, "8942310022000000001", u.SimId)
+	assert.EqualValues(t, 300, u.MonthlyBytes)
+	assert.EqualValues(t, 1000, u.MonthlyCap.Bytes)
+	assert.NoFileExists(t, executed)
+
+	u = collectDataUsage(configPath, "")
+	assert.Equal(t, "8942310022000000000", u.SimId)
+	assert.Zero(t, u.MonthlyBytes)
+
+	assert.Nil(t, collectDataUsage("", "8942310022000000000"))
+	assert.Nil(t, collectDataUsage(filepath.Join(dir, "missing.json"), "8942310022000000000"))
+}
@@ -0,0 +1,306 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/soracom/soratun"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+// ANSI escape sequences for the dashboard.
+const (
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiBold       = "\x1b[1m"
+	ansiRed        = "\x1b[31m"
+	ansiGreen      = "\x1b[32m"
+	ansiYellow     = "\x1b[33m"
+	ansiReset      = "\x1b[0m"
+)
+
+// handshake ages to change colors. WireGuard renews the session every 2 minutes while traffic flows, and persistent
+// keepalive keeps handshakes within a few minutes on an idle tunnel.
+const (
+	handshakeHealthy = 3 * time.Minute
+	handshakeStale   = 5 * time.Minute
+)
+
+var sparklineBlocks = []rune("▁▂▃▄▅▆▇█")
+
+var (
+	topInterval time.Duration
+	topLogs     int
+	topAll      bool
+)
+
+// topTunnel holds the latest status of an interface, and its history since `soratun top` started.
+type topTunnel struct {
+	status    *interfaceStatus
+	sampledAt time.Time
+	rx        []uint64
+	tx        []uint64
+	logs      []soratun.LogEvent
+}
+
+func topCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Display live dashboard of SORACOM Arc interfaces",
+		Long:  "This command will display a refreshing dashboard of interfaces managed by running \"soratun up\" processes, with throughput rates, latest handshake, sparkline of recent throughput, and recent log events from each process. Press Ctrl-C to quit.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			c, err := wgctrl.New()
+			if err != nil {
+				log.Fatalf("failed to open wgctrl: %v", err)
+			}
+
+			defer func() {
+				err := c.Close()
+				if err != nil {
+					log.Printf("failed to close wgctrl: %v ", err)
+				}
+			}()
+
+			quit := make(chan os.Signal, 1)
+			signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+			fmt.Print(ansiHideCursor)
+			defer fmt.Print(ansiShowCursor)
+
+			ticker := time.NewTicker(topInterval)
+			defer ticker.Stop()
+
+			tunnels := map[string]*topTunnel{}
+			for {
+				width := terminalWidth()
+				if err := sampleTop(c, tunnels, width); err != nil {
+					fmt.Print(ansiShowCursor)
+					log.Fatalf("failed to get devices: %v", err)
+				}
+				fmt.Print(ansiClear)
+				renderTop(os.Stdout, tunnels, time.Now(), width)
+
+				select {
+				case <-ticker.C:
+				case <-quit:
+					return
+				}
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&topInterval, "interval", time.Second, "Refresh interval")
+	cmd.Flags().IntVar(&topLogs, "logs", 10, "Number of recent log events to display for each interface")
+	cmd.Flags().BoolVar(&topAll, "all", false, "Include WireGuard interfaces which are not managed by soratun")
+
+	return cmd
+}
+
+// sampleTop updates tunnels with current status of the devices. Interfaces which disappeared are removed, and history
+// which does not fit in width with the label and the current rate is dropped.
+func sampleTop(c *wgctrl.Client, tunnels map[string]*topTunnel, width int) error {
+	devices, err := c.Devices()
+	if err != nil {
+		return err
+	}
+
+	historySize := width - 20
+	now := time.Now()
+	seen := map[string]bool{}
+	for _, d := range devices {
+		var info *soratun.TunnelInfo
+		var i soratun.TunnelInfo
+		if err := soratun.CallControl(d.Name, "info", nil, &i); err == nil {
+			info = &i
+		} else if !topAll {
+			continue
+		}
+		seen[d.Name] = true
+
+		s := newInterfaceStatus(d, info, now)
+		t, ok := tunnels[d.Name]
+		if !ok {
+			t = &topTunnel{}
+			tunnels[d.Name] = t
+		} else {
+			s.setRates(t.status, now.Sub(t.sampledAt))
+			var rx, tx uint64
+			for _, p := range s.Peers {
+				rx += p.ReceiveBitsPerSecond
+				tx += p.TransmitBitsPerSecond
+			}
+			t.rx = appendHistory(t.rx, rx, historySize)
+			t.tx = appendHistory(t.tx, tx, historySize)
+		}
+		t.status, t.sampledAt = s, now
+
+		t.logs = nil
+		if info != nil && topLogs > 0 {
+			_ = soratun.CallControl(d.Name, "logs", &soratun.LogsArgs{Count: topLogs}, &t.logs)
+		}
+	}
+
+	for name := range tunnels {
+		if !seen[name] {
+			delete(tunnels, name)
+		}
+	}
+	return nil
+}
+
+// setRates calculates throughput rates from the previous status which was sampled interval before.
+func (s *interfaceStatus) setRates(prev *interfaceStatus, interval time.Duration) {
+	for _, p := range s.Peers {
+		for _, pp := range prev.Peers {
+			if p.PublicKey != pp.PublicKey {
+				continue
+			}
+			p.ReceiveBitsPerSecond = bitsPerSecond(p.ReceivedBytes-pp.ReceivedBytes, interval)
+			p.TransmitBitsPerSecond = bitsPerSecond(p.SentBytes-pp.SentBytes, interval)
+		}
+	}
+}
+
+func appendHistory(history []uint64, v uint64, max int) []uint64 {
+	history = append(history, v)
+	if max > 0 && len(history) > max {
+		history = history[len(history)-max:]
+	}
+	return history
+}
+
+// renderTop writes a frame of the dashboard.
+func renderTop(w io.Writer, tunnels map[string]*topTunnel, now time.Time, width int) {
+	names := make([]string, 0, len(tunnels))
+	for name := range tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%ssoratun top%s  %s  refresh %s, Ctrl-C to quit\n\n", ansiBold, ansiReset, now.Format("2006-01-02 15:04:05"), topInterval)
+
+	if len(names) == 0 {
+		fmt.Fprintln(w, "no SORACOM Arc device found")
+		return
+	}
+
+	const row = "%-12s %-20s %-16s %-22s %-24s %-14s %-14s"
+	fmt.Fprintf(w, ansiBold+row+ansiReset+"\n", "INTERFACE", "SIM ID", "CLIENT IP", "ENDPOINT", "HANDSHAKE", "RX", "TX")
+	for _, name := range names {
+		s := tunnels[name].status
+		if len(s.Peers) == 0 {
+			fmt.Fprintf(w, row+"\n", s.Interface, dash(s.SimId), dash(s.ArcClientPeerIpAddress), "-", "-", "-", "-")
+		}
+		for _, p := range s.Peers {
+			handshake := colorize(fmt.Sprintf("%-24s", p.LatestHandshakeAge), handshakeColor(p, now))
+			fmt.Fprintf(w, "%-12s %-20s %-16s %-22s %s %-14s %-14s\n",
+				s.Interface,
+				dash(s.SimId),
+				dash(s.ArcClientPeerIpAddress),
+				dash(p.Endpoint),
+				handshake,
+				formatSI(p.ReceiveBitsPerSecond, "bit/s"),
+				formatSI(p.TransmitBitsPerSecond, "bit/s"),
+			)
+		}
+	}
+
+	for _, name := range names {
+		t := tunnels[name]
+		fmt.Fprintf(w, "\n%sTHROUGHPUT %s%s\n", ansiBold, name, ansiReset)
+		fmt.Fprintf(w, "rx %s %s\n", sparkline(t.rx), formatSI(last(t.rx), "bit/s"))
+		fmt.Fprintf(w, "tx %s %s\n", sparkline(t.tx), formatSI(last(t.tx), "bit/s"))
+
+		if len(t.logs) > 0 {
+			fmt.Fprintf(w, "\n%sLOGS %s%s\n", ansiBold, name, ansiReset)
+			for _, e := range t.logs {
+				line := truncate(fmt.Sprintf("%s %-7s %s", e.Time.Format("15:04:05"), e.Level, e.Message), width)
+				if e.Level == soratun.LogEventError {
+					line = colorize(line, ansiRed)
+				}
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+}
+
+func handshakeColor(p *peerStatus, now time.Time) string {
+	if p.LatestHandshake == nil {
+		return ansiRed
+	}
+	age := now.Sub(*p.LatestHandshake)
+	switch {
+	case age < handshakeHealthy:
+		return ansiGreen
+	case age < handshakeStale:
+		return ansiYellow
+	default:
+		return ansiRed
+	}
+}
+
+// sparkline returns a bar chart of values scaled to the maximum value.
+func sparkline(values []uint64) string {
+	var max uint64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if max > 0 {
+			i = int(v * uint64(len(sparklineBlocks)-1) / max)
+		}
+		b.WriteRune(sparklineBlocks[i])
+	}
+	return b.String()
+}
+
+// terminalWidth returns the number of columns of the terminal, or 80 if stdout is not a terminal.
+func terminalWidth() int {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 80
+	}
+	return int(ws.Col)
+}
+
+func colorize(s, color string) string {
+	return color + s + ansiReset
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width > 0 && len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func last(values []uint64) uint64 {
+	if len(values) == 0 {
+		return 0
+	}
+	return values[len(values)-1]
+}
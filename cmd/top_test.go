@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/soracom/soratun"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sparkline(t *testing.T) {
+	assert.Equal(t, "", sparkline(nil))
+	assert.Equal(t, "▁▁▁", sparkline([]uint64{0, 0, 0}))
+	assert.Equal(t, "▁▄█", sparkline([]uint64{0, 50, 100}))
+}
+
+func Test_renderTop(t *testing.T) {
+	now := time.Now()
+	handshake := now.Add(-10 * time.Second)
+	tunnels := map[string]*topTunnel{
+		"soratun0": {
+			status: &interfaceStatus{
+				Interface:              "soratun0",
+				Managed:                true,
+				SimId:                  "8942310022000000000",
+				ArcClientPeerIpAddress: "10.0.0.1",
+				Peers: []*peerStatus{
+					{
+						PublicKey:            "server",
+						Endpoint:             "192.0.2.1:11010",
+						LatestHandshake:      &handshake,
+						LatestHandshakeAge:   "10 seconds ago",
+						ReceiveBitsPerSecond: 2000,
+					},
+				},
+			},
+			rx:   []uint64{1000, 2000},
+			tx:   []uint64{0, 0},
+			logs: []soratun.LogEvent{{Time: now, Level: soratun.LogEventError, Message: "failed to update watchdog timer to systemd"}},
+		},
+	}
+
+	var b bytes.Buffer
+	renderTop(&b, tunnels, now, 120)
+	out := b.String()
+	assert.Contains(t, out, "8942310022000000000")
+	assert.Contains(t, out, ansiGreen+"10 seconds ago")
+	assert.Contains(t, out, "rx ▄█ 2.0 kbit/s")
+	assert.Contains(t, out, ansiRed+now.Format("15:04:05")+" error   failed to update watchdog timer to systemd")
+}
@@ -1,12 +1,12 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/soracom/soratun"
@@ -14,10 +14,11 @@ import (
 )
 
 var (
-	mtu                  int
+	mtu                  string
 	persistentKeepalive  int
 	additionalAllowedIPs string
 	readStdin            bool
+	dryRun               bool
 )
 
 func upCmd() *cobra.Command {
@@ -33,19 +34,31 @@ func upCmd() *cobra.Command {
 					log.Fatalf("Failed to read configuration from stdin: %v", err)
 				}
 
-				var config soratun.Config
-				err = json.Unmarshal(b, &config)
+				sealKey, err := configSealKey()
 				if err != nil {
 					log.Fatalf("Failed to read configuration from stdin: %v", err)
 				}
-				Config = &config
+
+				config, err := soratun.UnmarshalConfig(b, sealKey)
+				if err != nil {
+					log.Fatalf("Failed to read configuration from stdin: %v", err)
+				}
+				Config = config
+				applyConfigDefaults(Config)
 			} else {
 				initSoratun(cmd, args)
 			}
 
 			// override only if the flag was explicitly set
 			if cmd.Flags().Changed("mtu") {
-				Config.Mtu = mtu
+				m, err := soratun.ParseMTU(mtu)
+				if err != nil {
+					log.Fatalf("Invalid \"--mtu\": %v", err)
+				}
+				if m == 0 {
+					m = soratun.DefaultMTU
+				}
+				Config.Mtu = m
 			}
 
 			if cmd.Flags().Changed("persistent-keepalive") {
@@ -69,6 +82,11 @@ func upCmd() *cobra.Command {
 				}
 			}
 
+			if dryRun {
+				soratun.DryRun(os.Stdout, Config)
+				return
+			}
+
 			if v := os.Getenv("SORACOM_VERBOSE"); v != "" {
 				fmt.Fprintln(os.Stderr, "--- WireGuard configuration ----------------------")
 				dumpWireGuardConfig(true, os.Stderr)
@@ -79,10 +97,11 @@ func upCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().IntVar(&mtu, "mtu", soratun.DefaultMTU, "MTU for the interface, which will override arc.json#mtu value")
+	cmd.Flags().StringVar(&mtu, "mtu", strconv.Itoa(soratun.DefaultMTU), "MTU for the interface, or \"auto\" to discover it from the path to the SORACOM Arc server, which will override arc.json#mtu value")
 	cmd.Flags().IntVar(&persistentKeepalive, "persistent-keepalive", soratun.DefaultPersistentKeepaliveInterval, "WireGuard \"PersistentKeepalive\" for the SORACOM Arc server, which will override arc.json#persistentKeepalive value")
 	cmd.Flags().StringVar(&additionalAllowedIPs, "additional-allowed-ips", "", "Comma separated string of additional WireGuard allowed CIDRs, which will be added to arc.json#additionalAllowedIPs array")
 	cmd.Flags().BoolVar(&readStdin, "read-stdin", false, "read configuration from stdin, ignoring --config setting")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print actions which would be taken with the effective configuration, without creating the interface")
 
 	return cmd
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"testing"
@@ -128,3 +129,63 @@ func setNoDynamicClientSetupEnvVar(t *testing.T) {
 		_ = os.Setenv(noDynamicClientSetupEnvVarName, "")
 	})
 }
+
+func Test_upCmd_dryRun(t *testing.T) {
+	t.Cleanup(func() {
+		dryRun = false
+		additionalAllowedIPs = ""
+	})
+
+	wgClientPrivateKey, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+	wgServerPrivateKey, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	arcConf, err := os.CreateTemp("", "arc.conf")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = os.Remove(arcConf.Name()) })
+
+	confJSON, err := json.Marshal(map[string]interface{}{
+		"privateKey":           wgClientPrivateKey.String(),
+		"publicKey":            wgClientPrivateKey.PublicKey().String(),
+		"interface":            "soratun0",
+		"logLevel":             2,
+		"additionalAllowedIPs": []string{"192.0.2.0/24"},
+		"postUp":               [][]string{{"/bin/echo", "up", "%i"}},
+		"arcSessionStatus": map[string]interface{}{
+			"arcServerPeerPublicKey": wgServerPrivateKey.PublicKey().String(),
+			"arcServerEndpoint":      "192.0.2.2:11010",
+			"arcAllowedIPs":          []string{"100.127.0.0/16"},
+			"arcClientPeerIpAddress": "198.51.100.2",
+		},
+	})
+	assert.NoError(t, err)
+	_, err = arcConf.Write(confJSON)
+	assert.NoError(t, err)
+
+	originalStdout := os.Stdout
+	r, w, _ := os.Pipe()
+
+	func() {
+		os.Stdout = w
+		defer func() {
+			_ = w.Close()
+			os.Stdout = originalStdout
+		}()
+
+		os.Args = []string{"__soratun__", "up", "--config", arcConf.Name(), "--dry-run", "--mtu", "1380", "--additional-allowed-ips", "203.0.113.0/24"}
+		err := RootCmd.Execute()
+		assert.NoError(t, err)
+	}()
+
+	captured, err := io.ReadAll(r)
+	assert.NoError(t, err)
+
+	out := string(captured)
+	assert.Contains(t, out, "create TUN device: soratun0 (MTU 1380)")
+	assert.Contains(t, out, "private key: (hidden)")
+	assert.NotContains(t, out, wgClientPrivateKey.String())
+	assert.Contains(t, out, "peer: "+wgServerPrivateKey.PublicKey().String())
+	assert.Contains(t, out, "allowed ips: 100.127.0.0/16, 192.0.2.0/24, 203.0.113.0/24")
+	assert.Contains(t, out, "PostUp(0): [/bin/echo up soratun0]")
+}
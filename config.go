@@ -43,18 +43,37 @@ type Config struct {
 	Interface string `json:"interface"`
 	// AdditionalAllowedIPs holds a set of WireGuard allowed IPs in addition to the list which will get while creating Arc session.
 	AdditionalAllowedIPs []*IPNet `json:"additionalAllowedIPs,omitempty"`
-	// Mtu of the interface.
-	Mtu int `json:"mtu,omitempty"`
+	// Mtu of the interface. MTUAuto discovers it from the path to the SORACOM Arc server.
+	Mtu MTU `json:"mtu,omitempty"`
+	// MssClamping rewrites TCP MSS option of SYN packets through the tunnel to fit in Mtu.
+	MssClamping bool `json:"mssClamping,omitempty"`
 	// WireGuard PersistentKeepalive parameter.
 	PersistentKeepalive int `json:"persistentKeepalive,omitempty"`
+	// KeyRotationIntervalHours rotates WireGuard key pair of the running tunnel at the interval. It requires Profile,
+	// and the new key pair is saved to the configuration file.
+	KeyRotationIntervalHours int `json:"keyRotationIntervalHours,omitempty"`
 	// PostUp is array of commands which will be executed after the interface is up successfully.
 	PostUp [][]string `json:"postUp,omitempty"`
 	// PostDown is array of commands which will be executed after the interface is removed successfully.
 	PostDown [][]string `json:"postDown,omitempty"`
+	// DataUsage configures data usage accounting and caps.
+	DataUsage *DataUsageConfig `json:"dataUsage,omitempty"`
+	// Shaping configures ingress and egress rate limits on the tunnel.
+	Shaping *ShapingConfig `json:"shaping,omitempty"`
+	// Acl is a stateful packet filter for traffic through the tunnel.
+	Acl *AclConfig `json:"acl,omitempty"`
+	// SimOptions are applied to a virtual SIM created by `soratun bootstrap authkey`.
+	SimOptions *SimOptions `json:"simOptions,omitempty"`
 	// Profile is for SORACOM API access.
 	Profile *Profile `json:"profile,omitempty"`
 	// ArcSession holds connection information provided from SORACOM Arc server.
 	ArcSession *ArcSession `json:"arcSessionStatus,omitempty"`
+	// ConfigPath is the file which the configuration was read from, if any.
+	ConfigPath string `json:"-"`
+	// secretRefs holds secret references which secret fields were loaded from, keyed by JSON path.
+	secretRefs map[string]secretRef
+	// sealer encrypts secret fields when the configuration is written, if set.
+	sealer *sealer
 }
 
 // ArcSession holds SORACOM Arc configurations received from the server.
@@ -71,6 +90,65 @@ type ArcSession struct {
 	ArcClientPeerIpAddress net.IP `json:"arcClientPeerIpAddress,omitempty"`
 }
 
+// MTU is an MTU of the interface, which is a number or "auto" in JSON.
+type MTU int
+
+// MTUAuto is an MTU to be discovered from the path to the SORACOM Arc server.
+const MTUAuto MTU = -1
+
+// ParseMTU parses a number or "auto" into MTU. 0 means the MTU is not set, and DefaultMTU is used.
+func ParseMTU(s string) (MTU, error) {
+	if s == "auto" {
+		return MTUAuto, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || !validMTU(n) {
+		return 0, fmt.Errorf("invalid MTU \"%s\", it should be \"auto\" or a number larger than or equal to %d", s, minMTU)
+	}
+	return MTU(n), nil
+}
+
+// validMTU returns true if n is 0 for the default, or large enough for a tunnel.
+func validMTU(n int) bool {
+	return n == 0 || n >= minMTU
+}
+
+// UnmarshalJSON converts a number or "auto" into MTU.
+func (m *MTU) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := ParseMTU(s)
+		if err != nil {
+			return err
+		}
+		*m = v
+		return nil
+	}
+
+	var n int
+	if err := json.Unmarshal(b, &n); err != nil || !validMTU(n) {
+		return fmt.Errorf("invalid MTU %s, it should be \"auto\" or a number larger than or equal to %d", b, minMTU)
+	}
+	*m = MTU(n)
+	return nil
+}
+
+// MarshalJSON converts MTU to a number, or "auto" for MTUAuto.
+func (m MTU) MarshalJSON() ([]byte, error) {
+	if m == MTUAuto {
+		return json.Marshal("auto")
+	}
+	return json.Marshal(int(m))
+}
+
+// String returns string representation of MTU.
+func (m MTU) String() string {
+	if m == MTUAuto {
+		return "auto"
+	}
+	return strconv.Itoa(int(m))
+}
+
 // NewKey returns a Key from a base64-encoded string.
 func NewKey(s string) (Key, error) {
 	key, err := wgtypes.ParseKey(s)
@@ -178,3 +256,36 @@ func (a *ArcSession) MarshalJSON() ([]byte, error) {
 	tmp.ArcAllowedIPs = a.ArcAllowedIPs
 	return json.Marshal(&tmp)
 }
+
+// templateDroppedFields are JSON keys of the configuration which are specific to a device, and are not taken from a
+// configuration template.
+var templateDroppedFields = []string{"privateKey", "publicKey", "simId", "profile", "arcSessionStatus"}
+
+// NewConfigFromTemplate returns a new configuration with the default values, overridden by fields in the template,
+// e.g. interface, MTU, hooks and additional allowed IPs. Keys, SIM ID, profile and SORACOM Arc session in the template
+// are ignored, since bootstrap generates them for each device.
+func NewConfigFromTemplate(b []byte) (*Config, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	for _, field := range templateDroppedFields {
+		delete(raw, field)
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{
+		LogLevel:            LogLevelVerbose,
+		EnableMetrics:       true,
+		Interface:           DefaultInterfaceName(),
+		Mtu:                 DefaultMTU,
+		PersistentKeepalive: DefaultPersistentKeepaliveInterval,
+	}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,62 @@
+package soratun
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MTU(t *testing.T) {
+	var config Config
+	assert.NoError(t, json.Unmarshal([]byte(`{"mtu":"auto"}`), &config))
+	assert.Equal(t, MTUAuto, config.Mtu)
+	b, _ := json.Marshal(config.Mtu)
+	assert.Equal(t, `"auto"`, string(b))
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"mtu":1380}`), &config))
+	assert.EqualValues(t, 1380, config.Mtu)
+	b, _ = json.Marshal(config.Mtu)
+	assert.Equal(t, `1380`, string(b))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"mtu":"large"}`), &config))
+	assert.Error(t, json.Unmarshal([]byte(`{"mtu":-1}`), &config))
+	assert.Error(t, json.Unmarshal([]byte(`{"mtu":100}`), &config))
+	_, err := ParseMTU("100")
+	assert.Error(t, err)
+
+	// 0 is unset, and the default is used
+	assert.NoError(t, json.Unmarshal([]byte(`{"mtu":0}`), &config))
+	assert.EqualValues(t, 0, config.Mtu)
+	m, err := ParseMTU("0")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, m)
+	assert.Error(t, json.Unmarshal([]byte(`{"mtu":1}`), &config))
+}
+
+func Test_NewConfigFromTemplate(t *testing.T) {
+	config, err := NewConfigFromTemplate([]byte(`{
+  "interface": "arc1",
+  "mtu": 1380,
+  "postUp": [["/usr/local/bin/arc-up"]],
+  "additionalAllowedIPs": ["192.0.2.0/24"],
+  "privateKey": "env:ARC_PRIVATE_KEY",
+  "simId": "8942310022000000000",
+  "profile": {"authKeyId": "keyId-xxx", "authKey": "secret-xxx"},
+  "arcSessionStatus": {"arcServerEndpoint": "192.0.2.1:11010", "arcClientPeerIpAddress": "10.0.0.1"}
+}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "arc1", config.Interface)
+	assert.EqualValues(t, 1380, config.Mtu)
+	assert.Equal(t, [][]string{{"/usr/local/bin/arc-up"}}, config.PostUp)
+	assert.Len(t, config.AdditionalAllowedIPs, 1)
+	assert.Equal(t, DefaultPersistentKeepaliveInterval, config.PersistentKeepalive)
+	assert.True(t, config.EnableMetrics)
+	assert.Empty(t, config.SimId)
+	assert.Nil(t, config.Profile)
+	assert.Nil(t, config.ArcSession)
+	assert.Equal(t, Key{}, config.PrivateKey)
+
+	_, err = NewConfigFromTemplate([]byte(`{"mtu": "large"}`))
+	assert.Error(t, err)
+}
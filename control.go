@@ -0,0 +1,209 @@
+//go:build !windows
+
+package soratun
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// ControlSocketDirectory is a directory where soratun control sockets persist, next to wireguard-go UAPI sockets.
+const ControlSocketDirectory = "/var/run/soratun"
+
+// controlTimeout is a deadline for a single control request and response.
+const controlTimeout = 10 * time.Second
+
+type controlRequest struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+type controlResponse struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// controlHandler handles a control command with JSON encoded args, and returns a result which will be encoded as JSON.
+type controlHandler func(args json.RawMessage) (interface{}, error)
+
+// controlServer accepts commands from other soratun processes, e.g. `soratun capture`, through a UNIX domain socket.
+type controlServer struct {
+	path     string
+	listener net.Listener
+	logger   *device.Logger
+
+	mu       sync.RWMutex
+	handlers map[string]controlHandler
+	quiet    map[string]bool
+}
+
+// ControlSocketPath returns path to the control socket of the soratun process which manages the interface.
+func ControlSocketPath(iname string) string {
+	return filepath.Join(ControlSocketDirectory, iname+".sock")
+}
+
+// listenControl starts listening on the control socket for the interface. If a stale socket file is left, it will be
+// removed. If another process is still listening on the socket, listenControl returns an error.
+func listenControl(iname string, logger *device.Logger) (*controlServer, error) {
+	path := ControlSocketPath(iname)
+
+	if err := os.MkdirAll(ControlSocketDirectory, 0755); err != nil {
+		return nil, err
+	}
+
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("another soratun process is listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0660); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	s := &controlServer{
+		path:     path,
+		listener: l,
+		logger:   logger,
+		handlers: map[string]controlHandler{},
+		quiet:    map[string]bool{},
+	}
+	go s.serve()
+	return s, nil
+}
+
+// handle registers a handler for the command. It does nothing on nil, which means the control socket is not available.
+func (s *controlServer) handle(command string, h controlHandler) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// handleQuiet registers a handler for the command which is polled frequently, e.g. by `soratun top`, without logging
+// each request.
+func (s *controlServer) handleQuiet(command string, h controlHandler) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+	s.quiet[command] = true
+}
+
+// Close stops listening and removes the socket file.
+func (s *controlServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	_ = os.Remove(s.path)
+	return err
+}
+
+func (s *controlServer) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Errorf("failed to accept control connection: %v", err)
+			}
+			return
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *controlServer) serveConn(c net.Conn) {
+	defer func() {
+		_ = c.Close()
+	}()
+	_ = c.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req controlRequest
+	var res controlResponse
+	if err := json.NewDecoder(bufio.NewReader(c)).Decode(&req); err != nil {
+		res.Error = fmt.Sprintf("invalid control request: %v", err)
+	} else {
+		s.mu.RLock()
+		h, ok := s.handlers[req.Command]
+		quiet := s.quiet[req.Command]
+		s.mu.RUnlock()
+
+		if !ok {
+			res.Error = fmt.Sprintf("unknown control command: %s", req.Command)
+		} else {
+			if !quiet {
+				s.logger.Verbosef("control command received: %s", req.Command)
+			}
+			result, err := h(req.Args)
+			if err != nil {
+				res.Error = err.Error()
+			} else if res.Result, err = json.Marshal(result); err != nil {
+				res.Error = fmt.Sprintf("failed to encode control response: %v", err)
+			}
+		}
+	}
+
+	if err := json.NewEncoder(c).Encode(&res); err != nil {
+		s.logger.Errorf("failed to send control response: %v", err)
+	}
+}
+
+// CallControl sends a command with args to the soratun process which manages the interface, and decodes the result
+// into result if it is not nil.
+func CallControl(iname, command string, args, result interface{}) error {
+	c, err := net.DialTimeout("unix", ControlSocketPath(iname), controlTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to soratun process for %s: %w", iname, err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	_ = c.SetDeadline(time.Now().Add(controlTimeout))
+
+	req := controlRequest{Command: command}
+	if args != nil {
+		if req.Args, err = json.Marshal(args); err != nil {
+			return err
+		}
+	}
+
+	if err := json.NewEncoder(c).Encode(&req); err != nil {
+		return err
+	}
+
+	var res controlResponse
+	if err := json.NewDecoder(c).Decode(&res); err != nil {
+		return fmt.Errorf("invalid control response: %w", err)
+	}
+
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+
+	if result != nil && len(res.Result) > 0 {
+		return json.Unmarshal(res.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package soratun
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// DefaultDataUsageStatePath is a file to persist data usage counters, if DataUsageConfig.StatePath is not set.
+	DefaultDataUsageStatePath = "/var/lib/soratun/usage.json"
+	// DataCapActionWarn logs an error when the cap is exceeded.
+	DataCapActionWarn = "warn"
+	// DataCapActionHook runs DataCap.Hook when the cap is exceeded.
+	DataCapActionHook = "hook"
+	// DataCapActionBlock blocks traffic except DataUsageConfig.Allowlist until the period ends.
+	DataCapActionBlock = "block"
+
+	// dataUsageInterval is an interval to sample counters of the device.
+	dataUsageInterval = 10 * time.Second
+)
+
+// DataUsageConfig holds data usage accounting configurations.
+type DataUsageConfig struct {
+	// StatePath is a file to persist counters across restarts. Tunnels of different SIMs may share the file, which is
+	// locked with "<StatePath>.lock" while updated.
+	StatePath string `json:"statePath,omitempty"`
+	// Daily is a cap for transmitted and received bytes per day, in local time.
+	Daily *DataCap `json:"daily,omitempty"`
+	// Monthly is a cap for transmitted and received bytes per calendar month, in local time.
+	Monthly *DataCap `json:"monthly,omitempty"`
+	// Allowlist holds CIDRs which are still reachable while traffic is blocked with "block" action.
+	Allowlist []*IPNet `json:"allowlist,omitempty"`
+}
+
+// DataCap defines a cap of transmitted and received bytes, and the action when exceeded.
+type DataCap struct {
+	// Bytes is a sum of transmitted and received bytes allowed in the period.
+	Bytes uint64 `json:"bytes"`
+	// Action is one of "warn", "hook", or "block".
+	Action string `json:"action"`
+	// Hook is a command executed once per period when the cap is exceeded with "hook" action. The special string
+	// `%i` is expanded to interface name.
+	Hook []string `json:"hook,omitempty"`
+}
+
+// DataUsage holds transmitted and received bytes of a SIM in current day and month.
+type DataUsage struct {
+	// Day is the current day in "2006-01-02" format.
+	Day string `json:"day"`
+	// DailyTransmitBytes is transmitted bytes in the day.
+	DailyTransmitBytes uint64 `json:"dailyTransmitBytes"`
+	// DailyReceiveBytes is received bytes in the day.
+	DailyReceiveBytes uint64 `json:"dailyReceiveBytes"`
+	// Month is the current month in "2006-01" format.
+	Month string `json:"month"`
+	// MonthlyTransmitBytes is transmitted bytes in the month.
+	MonthlyTransmitBytes uint64 `json:"monthlyTransmitBytes"`
+	// MonthlyReceiveBytes is received bytes in the month.
+	MonthlyReceiveBytes uint64 `json:"monthlyReceiveBytes"`
+	// UpdatedAt is the time when the counters were updated.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DataUsageState is a content of the state file, which holds DataUsage by SIM ID.
+type DataUsageState map[string]*DataUsage
+
+// Path returns StatePath, or DefaultDataUsageStatePath if not set.
+func (c *DataUsageConfig) Path() string {
+	if c.StatePath == "" {
+		return DefaultDataUsageStatePath
+	}
+	return c.StatePath
+}
+
+// Validate returns an error if a cap has unknown action, or "hook" action without a command.
+func (c *DataUsageConfig) Validate() error {
+	for name, dataCap := range map[string]*DataCap{"daily": c.Daily, "monthly": c.Monthly} {
+		if dataCap == nil {
+			continue
+		}
+		switch dataCap.Action {
+		case DataCapActionWarn, DataCapActionBlock:
+		case DataCapActionHook:
+			if len(dataCap.Hook) == 0 || dataCap.Hook[0] == "" {
+				return fmt.Errorf("%s data cap has \"hook\" action without hook command", name)
+			}
+		default:
+			return fmt.Errorf("%s data cap has unknown action \"%s\", it should be one of warn, hook, or block", name, dataCap.Action)
+		}
+	}
+	return nil
+}
+
+// DailyBytes returns sum of transmitted and received bytes in the day.
+func (u *DataUsage) DailyBytes() uint64 {
+	return u.DailyTransmitBytes + u.DailyReceiveBytes
+}
+
+// MonthlyBytes returns sum of transmitted and received bytes in the month.
+func (u *DataUsage) MonthlyBytes() uint64 {
+	return u.MonthlyTransmitBytes + u.MonthlyReceiveBytes
+}
+
+// Current returns a copy of the usage for the day and month of now. Counters of the past period will be zero.
+func (u DataUsage) Current(now time.Time) DataUsage {
+	u.add(now, 0, 0)
+	return u
+}
+
+// add adds transmitted and received bytes, resetting counters if the day or month has changed.
+func (u *DataUsage) add(now time.Time, tx, rx uint64) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DailyTransmitBytes, u.DailyReceiveBytes = day, 0, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthlyTransmitBytes, u.MonthlyReceiveBytes = month, 0, 0
+	}
+	u.DailyTransmitBytes += tx
+	u.DailyReceiveBytes += rx
+	u.MonthlyTransmitBytes += tx
+	u.MonthlyReceiveBytes += rx
+	u.UpdatedAt = now
+}
+
+// ReadDataUsageState reads the state file. If the file does not exist, ReadDataUsageState returns an empty state.
+func ReadDataUsageState(path string) (DataUsageState, error) {
+	state := DataUsageState{}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("error while reading data usage state file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// lockDataUsageState locks the state file exclusively, and returns a function to unlock it. The lock is taken on a
+// separate file, since the state file is replaced on each write.
+func lockDataUsageState(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock data usage state file %s: %w", path, err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+// write writes the state to the file atomically.
+func (s DataUsageState) write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// dataUsageMeter accumulates counters of the device to the state file, and takes actions when caps are exceeded.
+type dataUsageMeter struct {
+	config *DataUsageConfig
+	simId  string
+	iname  string
+	logger *device.Logger
+
+	blocked atomic.Bool
+
+	mu               sync.Mutex
+	lastTransmitted  int64
+	lastReceived     int64
+	triggeredPeriods map[string]string
+}
+
+func newDataUsageMeter(config *Config, iname string, logger *device.Logger) *dataUsageMeter {
+	return &dataUsageMeter{
+		config:           config.DataUsage,
+		simId:            config.SimId,
+		iname:            iname,
+		logger:           logger,
+		triggeredPeriods: map[string]string{},
+	}
+}
+
+// tun wraps t to drop packets while traffic is blocked with "block" action.
+func (m *dataUsageMeter) tun(t tun.Device) tun.Device {
+	return &filterTun{
+		Device: t,
+		outbound: func(packet []byte) bool {
+			if !m.blocked.Load() {
+				return true
+			}
+			p, ok := parsePacket(packet)
+			return ok && m.isAllowlisted(p.dst)
+		},
+		inbound: func(packet []byte) bool {
+			if !m.blocked.Load() {
+				return true
+			}
+			p, ok := parsePacket(packet)
+			return ok && m.isAllowlisted(p.src)
+		},
+	}
+}
+
+func (m *dataUsageMeter) isAllowlisted(ip net.IP) bool {
+	for _, n := range m.config.Allowlist {
+		if (*net.IPNet)(n).Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sample reads counters of the device, then updates data usage. Errors are logged since sampling is periodic.
+func (m *dataUsageMeter) sample(client *wgctrl.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, err := client.Device(m.iname)
+	if err == nil {
+		err = m.update(d, time.Now())
+	}
+	if err != nil {
+		m.logger.Errorf("failed to update data usage: %v", err)
+	}
+}
+
+// update adds the difference of the device counters since last update to the state file, then takes actions for
+// exceeded caps.
+func (m *dataUsageMeter) update(d *wgtypes.Device, now time.Time) error {
+	var transmitted, received int64
+	for _, p := range d.Peers {
+		transmitted += p.TransmitBytes
+		received += p.ReceiveBytes
+	}
+
+	// counters will be reset when the peer is replaced
+	tx, rx := transmitted-m.lastTransmitted, received-m.lastReceived
+	if tx < 0 || rx < 0 {
+		tx, rx = transmitted, received
+	}
+	m.lastTransmitted, m.lastReceived = transmitted, received
+
+	usage, err := m.accumulate(now, uint64(tx), uint64(rx))
+	if err != nil {
+		return err
+	}
+
+	blocked := m.check("daily", usage.Day, m.config.Daily, usage.DailyBytes())
+	blocked = m.check("monthly", usage.Month, m.config.Monthly, usage.MonthlyBytes()) || blocked
+	if blocked != m.blocked.Swap(blocked) {
+		if blocked {
+			m.logger.Errorf("data cap exceeded, blocking traffic except allowlist")
+		} else {
+			m.logger.Verbosef("data cap period renewed, unblocking traffic")
+		}
+	}
+	return nil
+}
+
+// accumulate adds transmitted and received bytes to the usage of the SIM in the state file, and returns the usage of
+// the current period. The state file is locked while it is read and written, so that counters of other SIMs which are
+// updated at the same time are not lost. It is not written without traffic, to save writes to flash storage.
+func (m *dataUsageMeter) accumulate(now time.Time, tx, rx uint64) (DataUsage, error) {
+	path := m.config.Path()
+	unlock, err := lockDataUsageState(path)
+	if err != nil {
+		return DataUsage{}, err
+	}
+	defer unlock()
+
+	state, err := ReadDataUsageState(path)
+	if err != nil {
+		return DataUsage{}, err
+	}
+	usage, ok := state[m.simId]
+	if !ok {
+		usage = &DataUsage{}
+		state[m.simId] = usage
+	}
+	if tx == 0 && rx == 0 {
+		return usage.Current(now), nil
+	}
+
+	usage.add(now, tx, rx)
+	if err := state.write(path); err != nil {
+		return DataUsage{}, err
+	}
+	return *usage, nil
+}
+
+// check takes the action once per period if the cap is exceeded, and returns true if the traffic should be blocked.
+func (m *dataUsageMeter) check(name, period string, dataCap *DataCap, used uint64) bool {
+	if dataCap == nil || used < dataCap.Bytes {
+		return false
+	}
+
+	if m.triggeredPeriods[name] != period {
+		m.triggeredPeriods[name] = period
+		m.logger.Errorf("%s data cap exceeded: %d bytes used in %s, cap is %d bytes", name, used, period, dataCap.Bytes)
+
+		if dataCap.Action == DataCapActionHook {
+			command := replaceInterfaceName(dataCap.Hook, m.iname)
+			m.logger.Verbosef("executing %s data cap hook: %s", name, command)
+			result, err := runCommand(command)
+			if err != nil {
+				m.logger.Errorf("failed to do %s data cap hook: %s", name, err)
+			} else {
+				m.logger.Verbosef("%s data cap hook response: %s", name, result)
+			}
+		}
+	}
+	return dataCap.Action == DataCapActionBlock
+}
@@ -0,0 +1,216 @@
+package soratun
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_DataUsage_add(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		assert.NoError(t, err)
+		return v
+	}
+
+	tests := []struct {
+		name    string
+		now     string
+		tx, rx  uint64
+		daily   uint64
+		monthly uint64
+	}{
+		{"first sample", "2026-01-31 10:00", 100, 200, 300, 300},
+		{"same day", "2026-01-31 23:59", 10, 20, 330, 330},
+		{"next month resets both", "2026-02-01 00:00", 1, 2, 3, 3},
+		{"next day resets only daily", "2026-02-02 09:00", 4, 5, 9, 12},
+	}
+
+	var u DataUsage
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u.add(at(tt.now), tt.tx, tt.rx)
+			assert.Equal(t, tt.daily, u.DailyBytes())
+			assert.Equal(t, tt.monthly, u.MonthlyBytes())
+		})
+	}
+
+	// Current doesn't modify the usage
+	current := u.Current(at("2026-03-01 00:00"))
+	assert.Zero(t, current.DailyBytes())
+	assert.Zero(t, current.MonthlyBytes())
+	assert.Equal(t, "2026-03-01", current.Day)
+	assert.EqualValues(t, 12, u.MonthlyBytes())
+}
+
+func Test_DataUsageConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DataUsageConfig
+		invalid bool
+	}{
+		{"no caps", DataUsageConfig{}, false},
+		{"warn", DataUsageConfig{Daily: &DataCap{Bytes: 1, Action: DataCapActionWarn}}, false},
+		{"block", DataUsageConfig{Monthly: &DataCap{Bytes: 1, Action: DataCapActionBlock}}, false},
+		{"hook", DataUsageConfig{Daily: &DataCap{Bytes: 1, Action: DataCapActionHook, Hook: []string{"true"}}}, false},
+		{"hook without command", DataUsageConfig{Daily: &DataCap{Bytes: 1, Action: DataCapActionHook}}, true},
+		{"unknown action", DataUsageConfig{Monthly: &DataCap{Bytes: 1, Action: "throttle"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.invalid {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func newTestDataUsageMeter(t *testing.T, config *DataUsageConfig) *dataUsageMeter {
+	config.StatePath = filepath.Join(t.TempDir(), "usage.json")
+	return newDataUsageMeter(&Config{SimId: "8942310022000000000", DataUsage: config}, "soratun0", device.NewLogger(device.LogLevelSilent, ""))
+}
+
+func wgDevice(tx, rx int64) *wgtypes.Device {
+	return &wgtypes.Device{Peers: []wgtypes.Peer{{TransmitBytes: tx, ReceiveBytes: rx}}}
+}
+
+func Test_dataUsageMeter_update(t *testing.T) {
+	now := time.Date(2026, 1, 10, 10, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		tx, rx  int64
+		now     time.Time
+		daily   uint64
+		blocked bool
+	}{
+		{"first sample counts all bytes", 100, 100, now, 200, false},
+		{"difference since last sample", 150, 200, now, 350, false},
+		{"counters reset by replaced peer", 10, 0, now, 360, false},
+		{"cap exceeded", 500, 0, now, 850, true},
+		{"still blocked without traffic", 500, 0, now, 850, true},
+		{"next day unblocks", 500, 0, now.Add(24 * time.Hour), 0, false},
+	}
+
+	m := newTestDataUsageMeter(t, &DataUsageConfig{Daily: &DataCap{Bytes: 800, Action: DataCapActionBlock}})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, m.update(wgDevice(tt.tx, tt.rx), tt.now))
+			assert.Equal(t, tt.blocked, m.blocked.Load())
+
+			state, err := ReadDataUsageState(m.config.Path())
+			assert.NoError(t, err)
+			// the file isn't written without traffic, so the past day remains until the next write
+			usage := state[m.simId].Current(tt.now)
+			assert.Equal(t, tt.daily, usage.DailyBytes())
+		})
+	}
+
+	// monthly counter is kept across days, and persisted for the next process
+	m = newDataUsageMeter(&Config{SimId: m.simId, DataUsage: m.config}, "soratun0", m.logger)
+	assert.NoError(t, m.update(wgDevice(0, 0), now.Add(24*time.Hour)))
+	state, err := ReadDataUsageState(m.config.Path())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 850, state[m.simId].MonthlyBytes())
+}
+
+func Test_dataUsageMeter_update_shared(t *testing.T) {
+	now := time.Date(2026, 1, 10, 10, 0, 0, 0, time.Local)
+	config := &DataUsageConfig{}
+	m1 := newTestDataUsageMeter(t, config)
+	m2 := newDataUsageMeter(&Config{SimId: "8942310022000000001", DataUsage: config}, "soratun1", m1.logger)
+
+	// tunnels of two SIMs update the same state file at the same time
+	var wg sync.WaitGroup
+	for _, m := range []*dataUsageMeter{m1, m2} {
+		wg.Add(1)
+		go func(m *dataUsageMeter) {
+			defer wg.Done()
+			for i := int64(1); i <= 50; i++ {
+				assert.NoError(t, m.update(wgDevice(i*10, i*10), now))
+			}
+		}(m)
+	}
+	wg.Wait()
+
+	state, err := ReadDataUsageState(config.Path())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1000, state[m1.simId].DailyBytes())
+	assert.EqualValues(t, 1000, state[m2.simId].DailyBytes())
+
+	// the state file is not rewritten without traffic
+	before, err := os.Stat(config.Path())
+	assert.NoError(t, err)
+	assert.NoError(t, m1.update(wgDevice(500, 500), now.Add(time.Minute)))
+	after, err := os.Stat(config.Path())
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(before, after))
+}
+
+func Test_dataUsageMeter_check(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.log")
+	hook := []string{"sh", "-c", "echo %i >> " + out}
+
+	tests := []struct {
+		name    string
+		dataCap *DataCap
+		used    uint64
+		period  string
+		blocked bool
+		hooks   int
+	}{
+		{"no cap", nil, 1000, "2026-01", false, 0},
+		{"under cap", &DataCap{Bytes: 100, Action: DataCapActionBlock}, 99, "2026-01", false, 0},
+		{"block at cap", &DataCap{Bytes: 100, Action: DataCapActionBlock}, 100, "2026-01", true, 0},
+		{"block stays in the period", &DataCap{Bytes: 100, Action: DataCapActionBlock}, 200, "2026-01", true, 0},
+		{"warn doesn't block", &DataCap{Bytes: 100, Action: DataCapActionWarn}, 200, "2026-02", false, 0},
+		{"hook runs", &DataCap{Bytes: 100, Action: DataCapActionHook, Hook: hook}, 200, "2026-03", false, 1},
+		{"hook runs once per period", &DataCap{Bytes: 100, Action: DataCapActionHook, Hook: hook}, 300, "2026-03", false, 1},
+		{"hook runs again in next period", &DataCap{Bytes: 100, Action: DataCapActionHook, Hook: hook}, 200, "2026-04", false, 2},
+	}
+
+	m := newTestDataUsageMeter(t, &DataUsageConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.blocked, m.check("monthly", tt.period, tt.dataCap, tt.used))
+
+			b, err := os.ReadFile(out)
+			if tt.hooks == 0 {
+				assert.True(t, os.IsNotExist(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Repeat("soratun0\n", tt.hooks), string(b))
+		})
+	}
+}
+
+func Test_dataUsageMeter_tun(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("100.127.10.0/24")
+	m := newTestDataUsageMeter(t, &DataUsageConfig{Allowlist: []*IPNet{(*IPNet)(allowed)}})
+	ft := m.tun(nil).(*filterTun)
+
+	toAllowed := ipv4Packet(protocolTCP, "10.0.0.1", "100.127.10.1", 40000, 443)
+	toOther := ipv4Packet(protocolTCP, "10.0.0.1", "100.127.0.1", 40000, 443)
+
+	assert.True(t, ft.outbound(toOther))
+	assert.True(t, ft.inbound(ipv4Packet(protocolTCP, "100.127.0.1", "10.0.0.1", 443, 40000)))
+
+	m.blocked.Store(true)
+	assert.True(t, ft.outbound(toAllowed))
+	assert.False(t, ft.outbound(toOther))
+	assert.True(t, ft.inbound(ipv4Packet(protocolTCP, "100.127.10.1", "10.0.0.1", 443, 40000)))
+	assert.False(t, ft.inbound(ipv4Packet(protocolTCP, "100.127.0.1", "10.0.0.1", 443, 40000)))
+	assert.False(t, ft.outbound([]byte{0x45}))
+}
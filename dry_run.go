@@ -0,0 +1,62 @@
+//go:build !windows
+
+package soratun
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DryRun writes every action Up would take with given configuration to w, without creating the tunnel device or
+// touching the routing table.
+func DryRun(w io.Writer, config *Config) {
+	iname := config.Interface
+
+	fmt.Fprintln(w, "--- Tunnel device ----------------------------------")
+	fmt.Fprintf(w, "create TUN device: %s (MTU %s)\n", iname, config.Mtu)
+	if config.Mtu == MTUAuto {
+		fmt.Fprintf(w, "discover MTU for the path to %s every %s, falling back to %d\n", config.ArcSession.ArcServerEndpoint.IP, pmtuProbeInterval, DefaultMTU)
+	}
+	if config.MssClamping {
+		fmt.Fprintln(w, "clamp TCP MSS to fit in MTU")
+	}
+
+	wgConfig := wireGuardConfig(config)
+	fmt.Fprintln(w, "--- WireGuard configuration ------------------------")
+	fmt.Fprintf(w, "interface: %s\n", iname)
+	fmt.Fprintln(w, "  private key: (hidden)")
+	fmt.Fprintf(w, "  public key: %s\n", wgConfig.PrivateKey.PublicKey())
+	fmt.Fprintf(w, "  replace peers: %t\n", wgConfig.ReplacePeers)
+	for _, p := range wgConfig.Peers {
+		ips := make([]string, 0, len(p.AllowedIPs))
+		for _, ip := range p.AllowedIPs {
+			ips = append(ips, ip.String())
+		}
+		fmt.Fprintf(w, "peer: %s\n", p.PublicKey)
+		fmt.Fprintf(w, "  endpoint: %s\n", p.Endpoint)
+		fmt.Fprintf(w, "  persistent keepalive: %s\n", p.PersistentKeepaliveInterval)
+		fmt.Fprintf(w, "  replace allowed ips: %t\n", p.ReplaceAllowedIPs)
+		fmt.Fprintf(w, "  allowed ips: %s\n", strings.Join(ips, ", "))
+	}
+
+	fmt.Fprintln(w, "--- Interface configuration ------------------------")
+	for _, op := range interfaceOperations(iname, config) {
+		fmt.Fprintln(w, op)
+	}
+
+	fmt.Fprintln(w, "--- PostUp -----------------------------------------")
+	printHookCommands(w, "PostUp", config.PostUp, iname)
+
+	fmt.Fprintln(w, "--- PostDown ---------------------------------------")
+	printHookCommands(w, "PostDown", config.PostDown, iname)
+}
+
+func printHookCommands(w io.Writer, kind string, commands [][]string, iname string) {
+	for i, com := range commands {
+		if len(com) == 0 || com[0] == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%s(%d): %s\n", kind, i, replaceInterfaceName(com, iname))
+	}
+}
@@ -0,0 +1,320 @@
+package soratun
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default retry behavior of SORACOM API requests.
+const (
+	DefaultRetryMaxAttempts                = 4
+	DefaultRetryInitialBackoffMilliseconds = 500
+	DefaultRetryMaxBackoffSeconds          = 30
+	DefaultRequestTimeoutSeconds           = 30
+)
+
+// RetryConfig controls timeouts and retries of SORACOM API requests. Zero values mean defaults.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of attempts including the first one. 1 disables retries.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// InitialBackoffMilliseconds is the wait before the first retry, which doubles for each retry.
+	InitialBackoffMilliseconds int `json:"initialBackoffMilliseconds,omitempty"`
+	// MaxBackoffSeconds caps the wait between retries, unless the server asks for longer with Retry-After header.
+	MaxBackoffSeconds int `json:"maxBackoffSeconds,omitempty"`
+	// TimeoutSeconds is the timeout of each attempt, including reading the response body.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// TransportConfig controls how to connect to SORACOM API endpoints, e.g. on a network which intercepts TLS.
+type TransportConfig struct {
+	// CACertificatePath is a path to PEM encoded CA certificates which are trusted in addition to the system roots.
+	CACertificatePath string `json:"caCertificatePath,omitempty"`
+	// PinnedPublicKeys are base64 encoded SHA-256 hashes of SubjectPublicKeyInfo, optionally prefixed with "sha256/".
+	// If set, one of certificates in the verified chain must have one of the keys.
+	PinnedPublicKeys []string `json:"pinnedPublicKeys,omitempty"`
+	// ClientCertificatePath and ClientKeyPath are paths to PEM encoded client certificate and its private key for
+	// mutual TLS.
+	ClientCertificatePath string `json:"clientCertificatePath,omitempty"`
+	ClientKeyPath         string `json:"clientKeyPath,omitempty"`
+	// ProxyURL is a proxy for all requests, which overrides HTTPS_PROXY and other environment variables.
+	ProxyURL string `json:"proxyUrl,omitempty"`
+}
+
+// apiTransport sends API requests with timeouts, and retries failed requests with exponential backoff. Requests are
+// retried on 5xx responses and network errors only if they are idempotent, since the server might have executed them.
+// 429 responses are always retried since the server rejected the request without executing it.
+type apiTransport struct {
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	sleep          func(ctx context.Context, d time.Duration) error
+}
+
+func newAPITransport(config *RetryConfig, transportConfig *TransportConfig) (*apiTransport, error) {
+	rt, err := newRoundTripper(transportConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	c := RetryConfig{}
+	if config != nil {
+		c = *config
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if c.InitialBackoffMilliseconds <= 0 {
+		c.InitialBackoffMilliseconds = DefaultRetryInitialBackoffMilliseconds
+	}
+	if c.MaxBackoffSeconds <= 0 {
+		c.MaxBackoffSeconds = DefaultRetryMaxBackoffSeconds
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = DefaultRequestTimeoutSeconds
+	}
+
+	return &apiTransport{
+		client:         &http.Client{Transport: rt, Timeout: time.Duration(c.TimeoutSeconds) * time.Second},
+		maxAttempts:    c.MaxAttempts,
+		initialBackoff: time.Duration(c.InitialBackoffMilliseconds) * time.Millisecond,
+		maxBackoff:     time.Duration(c.MaxBackoffSeconds) * time.Second,
+		sleep:          sleepContext,
+	}, nil
+}
+
+// newRoundTripper returns http.Transport configured with config, or http.DefaultTransport if config is nil.
+func newRoundTripper(config *TransportConfig) (http.RoundTripper, error) {
+	if config == nil {
+		return http.DefaultTransport, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.CACertificatePath != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := os.ReadFile(config.CACertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %s", config.CACertificatePath)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+
+	if len(config.PinnedPublicKeys) > 0 {
+		pins := map[string]bool{}
+		for _, p := range config.PinnedPublicKeys {
+			p = strings.TrimPrefix(p, "sha256/")
+			if b, err := base64.StdEncoding.DecodeString(p); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid pinned public key %q: must be base64 encoded SHA-256 hash", p)
+			}
+			pins[p] = true
+		}
+		t.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPinnedPublicKeys(cs.VerifiedChains, pins)
+		}
+	}
+
+	if config.ClientCertificatePath != "" || config.ClientKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertificatePath, config.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.ProxyURL != "" {
+		u, err := url.Parse(config.ProxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", config.ProxyURL)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	return t, nil
+}
+
+// verifyPinnedPublicKeys returns an error unless a certificate in one of the verified chains has a pinned key.
+func verifyPinnedPublicKeys(chains [][]*x509.Certificate, pins map[string]bool) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if pins[base64.StdEncoding.EncodeToString(h[:])] {
+				return nil
+			}
+		}
+	}
+	return errors.New("no pinned public key found in the server certificate chain")
+}
+
+// do sends a request built with newRequest, which is called for each attempt. If the final response has 4xx or 5xx
+// status, do returns the response with its body consumed, and an error. Cancelling ctx aborts the current attempt and
+// the wait before the next one.
+func (t *apiTransport) do(ctx context.Context, newRequest func() (*http.Request, error), idempotent, verbose bool) (*http.Response, error) {
+	// verbose output is shared by all attempts
+	var w io.Writer
+	if verbose {
+		var closeOutput func()
+		w, closeOutput = openVerboseOutput()
+		defer closeOutput()
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+
+		var tracer *httpTracer
+		if verbose {
+			tracer = newHTTPTracer(w)
+			tracer.dumpRequest(req)
+			req = tracer.trace(req)
+		}
+
+		res, err := t.client.Do(req)
+		if tracer != nil {
+			if err != nil {
+				fmt.Fprintf(tracer.w, "--- Request failed: %v ---\n", err)
+				tracer.printTimings()
+			} else {
+				tracer.dumpResponse(res)
+			}
+		}
+
+		retryable := false
+		var wait time.Duration
+		if err != nil {
+			retryable = idempotent && ctx.Err() == nil
+		} else if res.StatusCode == http.StatusTooManyRequests || (res.StatusCode >= http.StatusInternalServerError && idempotent) {
+			retryable = true
+			wait = retryAfter(res.Header.Get("Retry-After"), time.Now())
+		}
+
+		if !retryable || attempt >= t.maxAttempts {
+			if err != nil {
+				return nil, err
+			}
+			return res, checkResponse(req, res)
+		}
+
+		if wait == 0 {
+			wait = t.backoff(attempt)
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = res.Status
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+		if tracer != nil {
+			fmt.Fprintf(tracer.w, "--- Retrying %s %s in %s (attempt %d of %d): %s ---\n", req.Method, req.URL, wait, attempt+1, t.maxAttempts, reason)
+		}
+		if err := t.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for d, or returns an error if ctx is done before that.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns a randomized exponential backoff before the next attempt.
+func (t *apiTransport) backoff(attempt int) time.Duration {
+	d := t.initialBackoff << (attempt - 1)
+	if d <= 0 || d > t.maxBackoff {
+		d = t.maxBackoff
+	}
+	// randomize between half and full backoff to spread retries from many devices
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter parses Retry-After header in seconds or HTTP-date, and returns 0 if it is absent or invalid.
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// checkResponse returns an error with the response body, with credentials redacted, if the status is 4xx or 5xx.
+func checkResponse(req *http.Request, res *http.Response) error {
+	if res.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			fmt.Println("failed to close response", err)
+		}
+	}()
+	r, _ := io.ReadAll(res.Body)
+	return &apiError{
+		StatusCode: res.StatusCode,
+		message:    fmt.Sprintf("%s: %s %s: %s", res.Status, req.Method, req.URL, redactBody(r)),
+	}
+}
+
+// apiError is an error response from the API.
+type apiError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// invalidResponseError is a successful response whose body can't be read or decoded. The raw body is kept, since the
+// request has taken effect anyway.
+type invalidResponseError struct {
+	body []byte
+	err  error
+}
+
+func (e *invalidResponseError) Error() string {
+	return fmt.Sprintf("invalid response: %s", e.err)
+}
+
+func (e *invalidResponseError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,213 @@
+package soratun
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_apiTransport_do(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		idempotent bool
+		wantStatus int
+		wantCalls  int32
+		wantSleeps []time.Duration
+		wantErr    bool
+	}{
+		{
+			name:       "success",
+			statuses:   []int{http.StatusOK},
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+		{
+			name:       "non-idempotent request is not retried on 5xx",
+			statuses:   []int{http.StatusInternalServerError, http.StatusOK},
+			wantStatus: http.StatusInternalServerError,
+			wantCalls:  1,
+			wantErr:    true,
+		},
+		{
+			name:       "non-idempotent request is retried on 429",
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantSleeps: []time.Duration{2 * time.Second},
+		},
+		{
+			name:       "idempotent request is retried on 5xx up to max attempts",
+			statuses:   []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			idempotent: true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  3,
+			wantErr:    true,
+		},
+		{
+			name:       "4xx is not retried",
+			statuses:   []int{http.StatusBadRequest, http.StatusOK},
+			idempotent: true,
+			wantStatus: http.StatusBadRequest,
+			wantCalls:  1,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[calls.Add(1)-1]
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "2")
+				}
+				w.WriteHeader(status)
+			}))
+			defer ts.Close()
+
+			tr, err := newAPITransport(&RetryConfig{MaxAttempts: 3}, nil)
+			assert.NoError(t, err)
+			var sleeps []time.Duration
+			tr.sleep = func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			res, err := tr.do(context.Background(), func() (*http.Request, error) {
+				return http.NewRequest(http.MethodPost, ts.URL, nil)
+			}, tt.idempotent, false)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, tt.wantCalls, calls.Load())
+			if tt.wantSleeps != nil {
+				assert.Equal(t, tt.wantSleeps, sleeps)
+			} else {
+				assert.Len(t, sleeps, int(tt.wantCalls)-1)
+			}
+		})
+	}
+}
+
+func Test_apiTransport_do_cancel(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	tr, err := newAPITransport(&RetryConfig{MaxAttempts: 3, InitialBackoffMilliseconds: 60 * 1000}, nil)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = tr.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, ts.URL, nil)
+	}, true, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualValues(t, 1, calls.Load())
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func Test_apiTransport_backoff(t *testing.T) {
+	tr, err := newAPITransport(&RetryConfig{InitialBackoffMilliseconds: 1000, MaxBackoffSeconds: 5}, nil)
+	assert.NoError(t, err)
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		d := tr.backoff(attempt + 1)
+		assert.GreaterOrEqual(t, d, max/2)
+		assert.LessOrEqual(t, d, max)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"invalid", 0},
+		{now.Add(10 * time.Second).Format(http.TimeFormat), 10 * time.Second},
+		{now.Add(-10 * time.Second).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryAfter(tt.value, now))
+		})
+	}
+}
+
+func Test_newRoundTripper(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+	assert.NoError(t, err)
+
+	h := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(h[:])
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		name    string
+		config  *TransportConfig
+		wantErr bool
+	}{
+		{"untrusted", nil, true},
+		{"CA bundle", &TransportConfig{CACertificatePath: caPath}, false},
+		{"pinned", &TransportConfig{CACertificatePath: caPath, PinnedPublicKeys: []string{otherPin, "sha256/" + pin}}, false},
+		{"pin mismatch", &TransportConfig{CACertificatePath: caPath, PinnedPublicKeys: []string{otherPin}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := newRoundTripper(tt.config)
+			assert.NoError(t, err)
+
+			res, err := (&http.Client{Transport: rt}).Get(ts.URL)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+			if err == nil {
+				_ = res.Body.Close()
+			}
+		})
+	}
+
+	_, err = newRoundTripper(&TransportConfig{PinnedPublicKeys: []string{"invalid"}})
+	assert.Error(t, err)
+	_, err = newRoundTripper(&TransportConfig{CACertificatePath: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+}
+
+func Test_newRoundTripper_proxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		assert.Equal(t, "http://api.example.com/v1/auth", r.URL.String())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	rt, err := newRoundTripper(&TransportConfig{ProxyURL: proxy.URL})
+	assert.NoError(t, err)
+
+	res, err := (&http.Client{Transport: rt}).Get("http://api.example.com/v1/auth")
+	assert.NoError(t, err)
+	_ = res.Body.Close()
+	assert.EqualValues(t, 1, proxied.Load())
+}
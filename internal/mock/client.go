@@ -5,7 +5,9 @@
 package mock_soratun
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	soratun "github.com/soracom/soratun"
 	gomock "go.uber.org/mock/gomock"
@@ -35,33 +37,138 @@ func (m *MockSoracomClient) EXPECT() *MockSoracomClientMockRecorder {
 }
 
 // CreateArcSession mocks base method.
-func (m *MockSoracomClient) CreateArcSession(simId, publicKey string) (*soratun.ArcSession, error) {
+func (m *MockSoracomClient) CreateArcSession(ctx context.Context, simId, publicKey string) (*soratun.ArcSession, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateArcSession", simId, publicKey)
+	ret := m.ctrl.Call(m, "CreateArcSession", ctx, simId, publicKey)
 	ret0, _ := ret[0].(*soratun.ArcSession)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateArcSession indicates an expected call of CreateArcSession.
-func (mr *MockSoracomClientMockRecorder) CreateArcSession(simId, publicKey interface{}) *gomock.Call {
+func (mr *MockSoracomClientMockRecorder) CreateArcSession(ctx, simId, publicKey interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateArcSession", reflect.TypeOf((*MockSoracomClient)(nil).CreateArcSession), simId, publicKey)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateArcSession", reflect.TypeOf((*MockSoracomClient)(nil).CreateArcSession), ctx, simId, publicKey)
 }
 
 // CreateVirtualSim mocks base method.
-func (m *MockSoracomClient) CreateVirtualSim() (*soratun.VirtualSim, error) {
+func (m *MockSoracomClient) CreateVirtualSim(ctx context.Context, subscription string) (*soratun.VirtualSim, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateVirtualSim")
+	ret := m.ctrl.Call(m, "CreateVirtualSim", ctx, subscription)
 	ret0, _ := ret[0].(*soratun.VirtualSim)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateVirtualSim indicates an expected call of CreateVirtualSim.
-func (mr *MockSoracomClientMockRecorder) CreateVirtualSim() *gomock.Call {
+func (mr *MockSoracomClientMockRecorder) CreateVirtualSim(ctx, subscription interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateVirtualSim", reflect.TypeOf((*MockSoracomClient)(nil).CreateVirtualSim))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateVirtualSim", reflect.TypeOf((*MockSoracomClient)(nil).CreateVirtualSim), ctx, subscription)
+}
+
+// DeleteArcSession mocks base method.
+func (m *MockSoracomClient) DeleteArcSession(ctx context.Context, simId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteArcSession", ctx, simId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteArcSession indicates an expected call of DeleteArcSession.
+func (mr *MockSoracomClientMockRecorder) DeleteArcSession(ctx, simId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteArcSession", reflect.TypeOf((*MockSoracomClient)(nil).DeleteArcSession), ctx, simId)
+}
+
+// EnableSimTermination mocks base method.
+func (m *MockSoracomClient) EnableSimTermination(ctx context.Context, simId string) (*soratun.VirtualSim, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnableSimTermination", ctx, simId)
+	ret0, _ := ret[0].(*soratun.VirtualSim)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnableSimTermination indicates an expected call of EnableSimTermination.
+func (mr *MockSoracomClientMockRecorder) EnableSimTermination(ctx, simId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableSimTermination", reflect.TypeOf((*MockSoracomClient)(nil).EnableSimTermination), ctx, simId)
+}
+
+// GetSim mocks base method.
+func (m *MockSoracomClient) GetSim(ctx context.Context, simId string) (*soratun.VirtualSim, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSim", ctx, simId)
+	ret0, _ := ret[0].(*soratun.VirtualSim)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSim indicates an expected call of GetSim.
+func (mr *MockSoracomClientMockRecorder) GetSim(ctx, simId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSim", reflect.TypeOf((*MockSoracomClient)(nil).GetSim), ctx, simId)
+}
+
+// ListSims mocks base method.
+func (m *MockSoracomClient) ListSims(ctx context.Context, limit int, lastEvaluatedKey string) ([]*soratun.VirtualSim, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSims", ctx, limit, lastEvaluatedKey)
+	ret0, _ := ret[0].([]*soratun.VirtualSim)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListSims indicates an expected call of ListSims.
+func (mr *MockSoracomClientMockRecorder) ListSims(ctx, limit, lastEvaluatedKey interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSims", reflect.TypeOf((*MockSoracomClient)(nil).ListSims), ctx, limit, lastEvaluatedKey)
+}
+
+// SetSimGroup mocks base method.
+func (m *MockSoracomClient) SetSimGroup(ctx context.Context, simId, groupId string) (*soratun.VirtualSim, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSimGroup", ctx, simId, groupId)
+	ret0, _ := ret[0].(*soratun.VirtualSim)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetSimGroup indicates an expected call of SetSimGroup.
+func (mr *MockSoracomClientMockRecorder) SetSimGroup(ctx, simId, groupId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSimGroup", reflect.TypeOf((*MockSoracomClient)(nil).SetSimGroup), ctx, simId, groupId)
+}
+
+// SetSimName mocks base method.
+func (m *MockSoracomClient) SetSimName(ctx context.Context, simId, name string) (*soratun.VirtualSim, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSimName", ctx, simId, name)
+	ret0, _ := ret[0].(*soratun.VirtualSim)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetSimName indicates an expected call of SetSimName.
+func (mr *MockSoracomClientMockRecorder) SetSimName(ctx, simId, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSimName", reflect.TypeOf((*MockSoracomClient)(nil).SetSimName), ctx, simId, name)
+}
+
+// SetSimTags mocks base method.
+func (m *MockSoracomClient) SetSimTags(ctx context.Context, simId string, tags map[string]string) (*soratun.VirtualSim, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSimTags", ctx, simId, tags)
+	ret0, _ := ret[0].(*soratun.VirtualSim)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetSimTags indicates an expected call of SetSimTags.
+func (mr *MockSoracomClientMockRecorder) SetSimTags(ctx, simId, tags interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSimTags", reflect.TypeOf((*MockSoracomClient)(nil).SetSimTags), ctx, simId, tags)
 }
 
 // SetVerbose mocks base method.
@@ -76,6 +183,50 @@ func (mr *MockSoracomClientMockRecorder) SetVerbose(v interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVerbose", reflect.TypeOf((*MockSoracomClient)(nil).SetVerbose), v)
 }
 
+// SuspendSim mocks base method.
+func (m *MockSoracomClient) SuspendSim(ctx context.Context, simId string) (*soratun.VirtualSim, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SuspendSim", ctx, simId)
+	ret0, _ := ret[0].(*soratun.VirtualSim)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SuspendSim indicates an expected call of SuspendSim.
+func (mr *MockSoracomClientMockRecorder) SuspendSim(ctx, simId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SuspendSim", reflect.TypeOf((*MockSoracomClient)(nil).SuspendSim), ctx, simId)
+}
+
+// TerminateSim mocks base method.
+func (m *MockSoracomClient) TerminateSim(ctx context.Context, simId string) (*soratun.VirtualSim, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TerminateSim", ctx, simId)
+	ret0, _ := ret[0].(*soratun.VirtualSim)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TerminateSim indicates an expected call of TerminateSim.
+func (mr *MockSoracomClientMockRecorder) TerminateSim(ctx, simId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TerminateSim", reflect.TypeOf((*MockSoracomClient)(nil).TerminateSim), ctx, simId)
+}
+
+// TokenTimeout mocks base method.
+func (m *MockSoracomClient) TokenTimeout() time.Duration {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TokenTimeout")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+// TokenTimeout indicates an expected call of TokenTimeout.
+func (mr *MockSoracomClientMockRecorder) TokenTimeout() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TokenTimeout", reflect.TypeOf((*MockSoracomClient)(nil).TokenTimeout))
+}
+
 // Verbose mocks base method.
 func (m *MockSoracomClient) Verbose() bool {
 	m.ctrl.T.Helper()
@@ -0,0 +1,109 @@
+package soratun
+
+import (
+	"context"
+	"os"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ArcSessionUpdate is args of "arc-session-set" control command, which applies a renewed session or a rotated key to
+// running soratun process.
+type ArcSessionUpdate struct {
+	// ArcSession is the new session.
+	ArcSession *ArcSession `json:"arcSession"`
+	// PrivateKey is the new private key of the device, if the key was rotated.
+	PrivateKey *Key `json:"privateKey,omitempty"`
+}
+
+// GenerateKeyPair generates a new WireGuard key pair.
+func GenerateKeyPair() (privateKey, publicKey Key, err error) {
+	k, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return Key{}, Key{}, err
+	}
+	return Key(k), Key(k.PublicKey()), nil
+}
+
+// CreateArcSessionWithNewKey generates a new WireGuard key pair on the device, then creates a new Arc session of the
+// SIM with the public key. The private key never leaves the device, and the previous key pair stops working.
+func CreateArcSessionWithNewKey(ctx context.Context, client SoracomClient, simId string) (privateKey, publicKey Key, session *ArcSession, err error) {
+	privateKey, publicKey, err = GenerateKeyPair()
+	if err != nil {
+		return Key{}, Key{}, nil, err
+	}
+
+	session, err = client.CreateArcSession(ctx, simId, publicKey.String())
+	if err != nil {
+		return Key{}, Key{}, nil, err
+	}
+	return privateKey, publicKey, session, nil
+}
+
+// CheckKeyRotation returns an error if privateKey in config was loaded from a secret reference which a rotated key
+// can't be saved to. A key loaded from a "file:" reference is saved to the file, but other references are managed
+// outside soratun. Call it before creating a session with a new key, since the previous key stops working.
+func CheckKeyRotation(config *Config) error {
+	return config.checkSecretWritable("privateKey")
+}
+
+// SetPrivateKey sets key to config. If privateKey was loaded from a "file:" reference, key is written to the file, so
+// the configuration file keeps the reference rather than the key in plaintext.
+func SetPrivateKey(config *Config, key Key) error {
+	config.PrivateKey = key
+	return config.updateSecretRef("privateKey")
+}
+
+// rotateKey creates a new Arc session with a new key pair for the SIM in config, and saves them to the configuration
+// file. Other fields in the file are kept as they are, rather than overwritten with config which may have been
+// modified with command line flags. If privateKey refers to a secret which can't be updated, no session is created. If the session is created but saving fails, both the update and an error are
+// returned.
+func rotateKey(ctx context.Context, config *Config) (*ArcSessionUpdate, error) {
+	saved, err := readSavedConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckKeyRotation(saved); err != nil {
+		return nil, err
+	}
+
+	client, err := NewDefaultSoracomClient(ctx, *config.Profile)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, publicKey, session, err := CreateArcSessionWithNewKey(ctx, client, config.SimId)
+	if err != nil {
+		return nil, err
+	}
+
+	update := &ArcSessionUpdate{ArcSession: session, PrivateKey: &privateKey}
+	if err := SetPrivateKey(saved, privateKey); err != nil {
+		return update, err
+	}
+	saved.PublicKey, saved.ArcSession = publicKey, session
+	return update, WriteConfigFile(config.ConfigPath, saved)
+}
+
+// SaveArcSession saves session to the configuration file which config was read from. Like rotateKey, other fields in
+// the file are kept as they are.
+func SaveArcSession(config *Config, session *ArcSession) error {
+	saved, err := readSavedConfig(config)
+	if err != nil {
+		return err
+	}
+	saved.ArcSession = session
+	return WriteConfigFile(config.ConfigPath, saved)
+}
+
+// readSavedConfig reads the configuration file which config was read from again, without command line flags applied.
+func readSavedConfig(config *Config) (*Config, error) {
+	b, err := os.ReadFile(config.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	var sealKey []byte
+	if config.sealer != nil {
+		sealKey = config.sealer.secret
+	}
+	return UnmarshalConfig(b, sealKey)
+}
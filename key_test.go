@@ -0,0 +1,155 @@
+package soratun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CreateArcSessionWithNewKey(t *testing.T) {
+	var registered string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth":
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+		case "/v1/sims/8942310022000000000/sessions/arc":
+			var body struct {
+				ArcClientPeerPublicKey string `json:"arcClientPeerPublicKey"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			registered = body.ArcClientPeerPublicKey
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010","arcClientPeerIpAddress":"10.0.0.1"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	client, err := NewDefaultSoracomClient(context.Background(), Profile{AuthKeyID: "keyId-xxx", AuthKey: "secret-xxx", Endpoint: s.URL})
+	assert.NoError(t, err)
+
+	privateKey, publicKey, session, err := CreateArcSessionWithNewKey(context.Background(), client, "8942310022000000000")
+	assert.NoError(t, err)
+	assert.Equal(t, publicKey.String(), registered)
+	assert.Equal(t, privateKey.AsWgKey().PublicKey().String(), registered)
+	assert.Equal(t, "10.0.0.1", session.ArcClientPeerIpAddress.String())
+}
+
+func Test_SaveArcSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arc.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+  "simId": "8942310022000000000",
+  "mtu": 1420,
+  "arcSessionStatus": {"arcServerEndpoint": "192.0.2.1:11010", "arcAllowedIPs": ["100.127.0.0/16"], "arcClientPeerIpAddress": "10.0.0.1"}
+}
+`), 0600))
+
+	// config read with command line flags applied, which should not be saved
+	config := &Config{
+		ConfigPath: path,
+		SimId:      "8942310022000000000",
+		Mtu:        1280,
+		ArcSession: &ArcSession{ArcAllowedIPs: []*IPNet{{IP: net.ParseIP("192.0.2.0"), Mask: net.CIDRMask(24, 32)}}},
+	}
+	session := &ArcSession{
+		ArcServerEndpoint:      &UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 11010},
+		ArcClientPeerIpAddress: net.ParseIP("10.0.0.2"),
+	}
+	assert.NoError(t, SaveArcSession(config, session))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	saved, err := UnmarshalConfig(b, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, MTU(1420), saved.Mtu)
+	assert.Equal(t, "10.0.0.2", saved.ArcSession.ArcClientPeerIpAddress.String())
+	assert.Equal(t, "192.0.2.2", saved.ArcSession.ArcServerEndpoint.IP.String())
+	assert.Empty(t, saved.ArcSession.ArcAllowedIPs)
+}
+
+func Test_rotateKey_secretRef(t *testing.T) {
+	oldKey, _, err := GenerateKeyPair()
+	assert.NoError(t, err)
+
+	var sessions int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth":
+			fmt.Fprint(w, `{"apiKey":"api-key","token":"token"}`)
+		case "/v1/sims/8942310022000000000/sessions/arc":
+			sessions++
+			fmt.Fprint(w, `{"arcServerEndpoint":"192.0.2.1:11010","arcClientPeerIpAddress":"10.0.0.1"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	readConfig := func(path string) *Config {
+		b, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		config, err := UnmarshalConfig(b, nil)
+		assert.NoError(t, err)
+		config.ConfigPath = path
+		return config
+	}
+	writeConfig := func(path, privateKey string) {
+		assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`{
+  "privateKey": %q,
+  "simId": "8942310022000000000",
+  "profile": {"authKeyId": "keyId-xxx", "authKey": "secret-xxx", "endpoint": %q}
+}
+`, privateKey, s.URL)), 0600))
+	}
+
+	t.Run("file", func(t *testing.T) {
+		dir := t.TempDir()
+		keyPath := filepath.Join(dir, "private_key")
+		assert.NoError(t, os.WriteFile(keyPath, []byte(oldKey.String()+"\n"), 0640))
+		path := filepath.Join(dir, "arc.json")
+		writeConfig(path, SecretRefFile+keyPath)
+
+		update, err := rotateKey(context.Background(), readConfig(path))
+		assert.NoError(t, err)
+		assert.NotEqual(t, oldKey.String(), update.PrivateKey.String())
+
+		b, err := os.ReadFile(keyPath)
+		assert.NoError(t, err)
+		assert.Equal(t, update.PrivateKey.String()+"\n", string(b))
+		fi, err := os.Stat(keyPath)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+		b, err = os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), SecretRefFile+keyPath)
+		assert.NotContains(t, string(b), update.PrivateKey.String())
+		assert.Equal(t, update.PrivateKey.String(), readConfig(path).PrivateKey.String())
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("SORATUN_TEST_PRIVATE_KEY", oldKey.String())
+		path := filepath.Join(t.TempDir(), "arc.json")
+		writeConfig(path, SecretRefEnv+"SORATUN_TEST_PRIVATE_KEY")
+		before, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		n := sessions
+
+		update, err := rotateKey(context.Background(), readConfig(path))
+		assert.Nil(t, update)
+		assert.ErrorContains(t, err, "privateKey refers to env:SORATUN_TEST_PRIVATE_KEY")
+		assert.Equal(t, n, sessions, "no session should be created")
+
+		after, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, string(before), string(after))
+	})
+}
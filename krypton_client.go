@@ -1,12 +1,11 @@
 package soratun
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
-	"os"
 	"strings"
 
 	"github.com/soracom/soratun/internal"
@@ -16,7 +15,7 @@ import (
 // https://developers.soracom.io/en/api/krypton/
 // https://users.soracom.io/ja-jp/tools/krypton-api/
 type SoracomKryptonClient interface {
-	Bootstrap() (*ArcSession, error)
+	Bootstrap(ctx context.Context) (*ArcSession, error)
 	SetVerbose(v bool)
 	Verbose() bool
 }
@@ -24,24 +23,33 @@ type SoracomKryptonClient interface {
 // A KryptonClientConfig holds SORACOM Krypton provisioning API client related information.
 type KryptonClientConfig struct {
 	Endpoint string
+	// Retry controls timeouts and retries of requests.
+	Retry *RetryConfig
+	// Transport controls TLS and proxy settings to connect to the endpoint.
+	Transport *TransportConfig
 }
 
 // DefaultSoracomKryptonClient is an implementation of the SoracomKryptonClient for the general use case.
 type DefaultSoracomKryptonClient struct {
-	endpoint string       // SORACOM Krypton provisioning API endpoint
-	client   *http.Client // HTTP client
-	verbose  bool
+	endpoint  string        // SORACOM Krypton provisioning API endpoint
+	transport *apiTransport // HTTP client with retries
+	verbose   bool
 }
 
 // NewDefaultSoracomKryptonClient returns new SoracomClient for caller.
-func NewDefaultSoracomKryptonClient(config *KryptonClientConfig) SoracomKryptonClient {
+func NewDefaultSoracomKryptonClient(config *KryptonClientConfig) (SoracomKryptonClient, error) {
+	transport, err := newAPITransport(config.Retry, config.Transport)
+	if err != nil {
+		return nil, err
+	}
+
 	c := DefaultSoracomKryptonClient{
-		endpoint: config.Endpoint,
-		client:   http.DefaultClient,
-		verbose:  false,
+		endpoint:  config.Endpoint,
+		transport: transport,
+		verbose:   false,
 	}
 
-	return &c
+	return &c, nil
 }
 
 // SetVerbose sets if verbose output is enabled or not.
@@ -54,9 +62,10 @@ func (c *DefaultSoracomKryptonClient) Verbose() bool {
 	return c.verbose
 }
 
-// Bootstrap bootstraps Arc virtual SIM.
-func (c *DefaultSoracomKryptonClient) Bootstrap() (*ArcSession, error) {
-	res, err := c.callAPI(&apiParams{
+// Bootstrap bootstraps Arc virtual SIM. It creates a new virtual SIM, so it is never retried unless the server
+// rejects the request with 429.
+func (c *DefaultSoracomKryptonClient) Bootstrap(ctx context.Context) (*ArcSession, error) {
+	res, err := c.callAPI(ctx, &apiParams{
 		method: "POST",
 		path:   "/provisioning/soracom/arc/bootstrap",
 		body:   "{}",
@@ -71,8 +80,8 @@ func (c *DefaultSoracomKryptonClient) Bootstrap() (*ArcSession, error) {
 }
 
 // BootstrapWithKeyID bootstraps Arc virtual SIM with SIM authentication.
-func (c *DefaultSoracomKryptonClient) BootstrapWithKeyID() (*ArcSession, error) {
-	res, err := c.callAPI(&apiParams{
+func (c *DefaultSoracomKryptonClient) BootstrapWithKeyID(ctx context.Context) (*ArcSession, error) {
+	res, err := c.callAPI(ctx, &apiParams{
 		method: "POST",
 		path:   "/provisioning/soracom/arc/bootstrap",
 		body:   "{}",
@@ -86,20 +95,10 @@ func (c *DefaultSoracomKryptonClient) BootstrapWithKeyID() (*ArcSession, error)
 	return &config, err
 }
 
-func (c *DefaultSoracomKryptonClient) callAPI(params *apiParams) (*http.Response, error) {
-	req, err := c.makeRequest(params)
-	if err != nil {
-		return nil, err
-	}
-
-	if c.Verbose() {
-		fmt.Fprintln(os.Stderr, "--- Request dump ---------------------------------")
-		r, _ := httputil.DumpRequest(req, true)
-		fmt.Fprintln(os.Stderr, string(r))
-		fmt.Fprintln(os.Stderr, "--- End of request dump --------------------------")
-	}
-	res, err := c.doRequest(req)
-	return res, err
+func (c *DefaultSoracomKryptonClient) callAPI(ctx context.Context, params *apiParams) (*http.Response, error) {
+	return c.transport.do(ctx, func() (*http.Request, error) {
+		return c.makeRequest(params)
+	}, params.idempotent, c.Verbose())
 }
 
 func (c *DefaultSoracomKryptonClient) makeRequest(params *apiParams) (*http.Request, error) {
@@ -120,29 +119,3 @@ func (c *DefaultSoracomKryptonClient) makeRequest(params *apiParams) (*http.Requ
 	req.Header.Set("User-Agent", internal.UserAgent)
 	return req, nil
 }
-
-func (c *DefaultSoracomKryptonClient) doRequest(req *http.Request) (*http.Response, error) {
-	res, err := c.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	if c.Verbose() && res != nil {
-		fmt.Fprintln(os.Stderr, "--- Response dump --------------------------------")
-		r, _ := httputil.DumpResponse(res, true)
-		fmt.Fprintln(os.Stderr, string(r))
-		fmt.Fprintln(os.Stderr, "--- End of response dump -------------------------")
-	}
-
-	if res.StatusCode >= http.StatusBadRequest {
-		defer func() {
-			err := res.Body.Close()
-			if err != nil {
-				fmt.Println("failed to close response", err)
-			}
-		}()
-		r, _ := io.ReadAll(res.Body)
-		return res, fmt.Errorf("%s: %s %s: %s", res.Status, req.Method, req.URL, r)
-	}
-	return res, nil
-}
@@ -0,0 +1,86 @@
+package soratun
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	// LogEventVerbose is a level of LogEvent logged with Verbosef.
+	LogEventVerbose = "verbose"
+	// LogEventError is a level of LogEvent logged with Errorf.
+	LogEventError = "error"
+
+	// logRingSize is the number of recent log events kept in memory.
+	logRingSize = 200
+)
+
+// LogEvent is a log message kept in memory, which is returned from "logs" control command.
+type LogEvent struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// LogsArgs is arguments for "logs" control command.
+type LogsArgs struct {
+	// Count is the number of recent events to return. 0 means all kept events.
+	Count int `json:"count,omitempty"`
+}
+
+// logRing keeps recent log events regardless of the log level, so they are available for `soratun top` even if the
+// process runs silently.
+type logRing struct {
+	mu     sync.Mutex
+	events []LogEvent
+	next   int
+	full   bool
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{events: make([]LogEvent, size)}
+}
+
+// wrap makes logger record every message to the ring, in addition to the original output.
+func (r *logRing) wrap(logger *device.Logger) {
+	verbosef, errorf := logger.Verbosef, logger.Errorf
+	logger.Verbosef = func(format string, args ...any) {
+		r.add(LogEventVerbose, fmt.Sprintf(format, args...))
+		verbosef(format, args...)
+	}
+	logger.Errorf = func(format string, args ...any) {
+		r.add(LogEventError, fmt.Sprintf(format, args...))
+		errorf(format, args...)
+	}
+}
+
+func (r *logRing) add(level, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[r.next] = LogEvent{Time: time.Now(), Level: level, Message: message}
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns up to count recent events in chronological order. count <= 0 returns all kept events.
+func (r *logRing) recent(count int) []LogEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []LogEvent
+	if r.full {
+		events = append(events, r.events[r.next:]...)
+	}
+	events = append(events, r.events[:r.next]...)
+
+	if count > 0 && len(events) > count {
+		events = events[len(events)-count:]
+	}
+	return events
+}
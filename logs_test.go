@@ -0,0 +1,29 @@
+package soratun
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func Test_logRing(t *testing.T) {
+	r := newLogRing(3)
+	logger := device.NewLogger(device.LogLevelSilent, "")
+	r.wrap(logger)
+
+	for i := 0; i < 4; i++ {
+		logger.Verbosef("message %d", i)
+	}
+	logger.Errorf("failed")
+
+	events := r.recent(0)
+	assert.Len(t, events, 3)
+	assert.Equal(t, "message 2", events[0].Message)
+	assert.Equal(t, "message 3", events[1].Message)
+	assert.Equal(t, LogEventError, events[2].Level)
+
+	events = r.recent(1)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "failed", events[0].Message)
+}
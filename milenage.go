@@ -0,0 +1,218 @@
+package soratun
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// sizes of the parameters of MILENAGE algorithm set, see 3GPP TS 35.206.
+const (
+	milenageKeySize  = 16
+	milenageRandSize = 16
+	milenageSqnSize  = 6
+	milenageAmfSize  = 2
+	milenageMacSize  = 8
+	milenageAutnSize = milenageSqnSize + milenageAmfSize + milenageMacSize
+)
+
+// milenage computes the authentication functions f1-f5 of MILENAGE algorithm set with the subscriber key K and the
+// derived operator variant OPc.
+type milenage struct {
+	k   []byte
+	opc []byte
+}
+
+// milenageResult holds the outputs of f2-f5 for a challenge.
+type milenageResult struct {
+	res []byte // RES, f2
+	ck  []byte // CK, f3
+	ik  []byte // IK, f4
+	ak  []byte // AK, f5
+}
+
+func newMilenage(k, opc []byte) (*milenage, error) {
+	if len(k) != milenageKeySize || len(opc) != milenageKeySize {
+		return nil, fmt.Errorf("K and OPc should be %d bytes", milenageKeySize)
+	}
+	return &milenage{k: k, opc: opc}, nil
+}
+
+// milenageOPc derives OPc from the operator variant OP.
+func milenageOPc(k, op []byte) ([]byte, error) {
+	if len(k) != milenageKeySize || len(op) != milenageKeySize {
+		return nil, fmt.Errorf("K and OP should be %d bytes", milenageKeySize)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	opc := make([]byte, milenageKeySize)
+	block.Encrypt(opc, op)
+	xorBytes(opc, opc, op)
+	return opc, nil
+}
+
+// f1 returns MAC-A, and f1* returns MAC-S for resynchronisation.
+func (m *milenage) f1(rand, sqn, amf []byte) (macA, macS []byte, err error) {
+	temp, err := m.temp(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	in1 := make([]byte, 16)
+	copy(in1[0:6], sqn)
+	copy(in1[6:8], amf)
+	copy(in1[8:14], sqn)
+	copy(in1[14:16], amf)
+
+	// OUT1 = E_K(TEMP xor rot(IN1 xor OPc, r1) xor c1) xor OPc, where r1 = 64 and c1 = 0
+	x := make([]byte, 16)
+	xorBytes(x, in1, m.opc)
+	x = rotateBytes(x, 8)
+	xorBytes(x, x, temp)
+	out, err := m.encrypt(x)
+	if err != nil {
+		return nil, nil, err
+	}
+	xorBytes(out, out, m.opc)
+	return out[0:8], out[8:16], nil
+}
+
+// f2345 returns RES, CK, IK and AK for the challenge.
+func (m *milenage) f2345(rand []byte) (*milenageResult, error) {
+	temp, err := m.temp(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	out2, err := m.out(temp, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	out3, err := m.out(temp, 4, 2)
+	if err != nil {
+		return nil, err
+	}
+	out4, err := m.out(temp, 8, 4)
+	if err != nil {
+		return nil, err
+	}
+	return &milenageResult{
+		res: out2[8:16],
+		ck:  out3,
+		ik:  out4,
+		ak:  out2[0:6],
+	}, nil
+}
+
+// f5star returns AK for resynchronisation.
+func (m *milenage) f5star(rand []byte) ([]byte, error) {
+	temp, err := m.temp(rand)
+	if err != nil {
+		return nil, err
+	}
+	out5, err := m.out(temp, 12, 8)
+	if err != nil {
+		return nil, err
+	}
+	return out5[0:6], nil
+}
+
+// temp returns TEMP = E_K(RAND xor OPc).
+func (m *milenage) temp(rand []byte) ([]byte, error) {
+	if len(rand) != milenageRandSize {
+		return nil, fmt.Errorf("RAND should be %d bytes", milenageRandSize)
+	}
+	x := make([]byte, 16)
+	xorBytes(x, rand, m.opc)
+	return m.encrypt(x)
+}
+
+// out returns OUTn = E_K(rot(TEMP xor OPc, r) xor c) xor OPc, where r is in bytes and c has only the last byte.
+func (m *milenage) out(temp []byte, r int, c byte) ([]byte, error) {
+	x := make([]byte, 16)
+	xorBytes(x, temp, m.opc)
+	x = rotateBytes(x, r)
+	x[15] ^= c
+	out, err := m.encrypt(x)
+	if err != nil {
+		return nil, err
+	}
+	xorBytes(out, out, m.opc)
+	return out, nil
+}
+
+func (m *milenage) encrypt(x []byte) ([]byte, error) {
+	block, err := aes.NewCipher(m.k)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 16)
+	block.Encrypt(out, x)
+	return out, nil
+}
+
+// generateAutn returns AUTN = SQN xor AK || AMF || MAC-A for the challenge, which is what the network sends to the
+// SIM.
+func (m *milenage) generateAutn(rand, sqn, amf []byte) ([]byte, error) {
+	macA, _, err := m.f1(rand, sqn, amf)
+	if err != nil {
+		return nil, err
+	}
+	r, err := m.f2345(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	autn := make([]byte, 0, milenageAutnSize)
+	sqnAk := make([]byte, milenageSqnSize)
+	xorBytes(sqnAk, sqn, r.ak)
+	autn = append(autn, sqnAk...)
+	autn = append(autn, amf...)
+	autn = append(autn, macA...)
+	return autn, nil
+}
+
+// errMilenageMacFailure is returned if AUTN is not generated with the same K and OPc.
+var errMilenageMacFailure = errors.New("MAC failure")
+
+// verifyAutn checks MAC-A in AUTN, and returns SQN in it with the result for the challenge.
+func (m *milenage) verifyAutn(rand, autn []byte) (sqn []byte, result *milenageResult, err error) {
+	if len(autn) != milenageAutnSize {
+		return nil, nil, fmt.Errorf("AUTN should be %d bytes", milenageAutnSize)
+	}
+	result, err = m.f2345(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqn = make([]byte, milenageSqnSize)
+	xorBytes(sqn, autn[0:6], result.ak)
+	amf := autn[6:8]
+	macA, _, err := m.f1(rand, sqn, amf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare(macA, autn[8:16]) != 1 {
+		return nil, nil, errMilenageMacFailure
+	}
+	return sqn, result, nil
+}
+
+// xorBytes sets dst[i] = a[i] xor b[i] for the length of a.
+func xorBytes(dst, a, b []byte) {
+	for i := range a {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// rotateBytes returns x cyclically rotated left by n bytes.
+func rotateBytes(x []byte, n int) []byte {
+	out := make([]byte, len(x))
+	for i := range x {
+		out[i] = x[(i+n)%len(x)]
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package soratun
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.NoError(t, err)
+	return b
+}
+
+// Test_milenage checks the functions with Test Set 1 of 3GPP TS 35.207.
+func Test_milenage(t *testing.T) {
+	k := mustDecodeHex(t, "465b5ce8b199b49faa5f0a2ee238a6bc")
+	rand := mustDecodeHex(t, "23553cbe9637a89d218ae64dae47bf35")
+	sqn := mustDecodeHex(t, "ff9bb4d0b607")
+	amf := mustDecodeHex(t, "b9b9")
+	op := mustDecodeHex(t, "cdc202d5123e20f62b6d676ac72cb318")
+
+	opc, err := milenageOPc(k, op)
+	assert.NoError(t, err)
+	assert.Equal(t, "cd63cb71954a9f4e48a5994e37a02baf", hex.EncodeToString(opc))
+
+	m, err := newMilenage(k, opc)
+	assert.NoError(t, err)
+
+	macA, macS, err := m.f1(rand, sqn, amf)
+	assert.NoError(t, err)
+	assert.Equal(t, "4a9ffac354dfafb3", hex.EncodeToString(macA))
+	assert.Equal(t, "01cfaf9ec4e871e9", hex.EncodeToString(macS))
+
+	r, err := m.f2345(rand)
+	assert.NoError(t, err)
+	assert.Equal(t, "a54211d5e3ba50bf", hex.EncodeToString(r.res))
+	assert.Equal(t, "b40ba9a3c58b2a05bbf0d987b21bf8cb", hex.EncodeToString(r.ck))
+	assert.Equal(t, "f769bcd751044604127672711c6d3441", hex.EncodeToString(r.ik))
+	assert.Equal(t, "aa689c648370", hex.EncodeToString(r.ak))
+
+	akS, err := m.f5star(rand)
+	assert.NoError(t, err)
+	assert.Equal(t, "451e8beca43b", hex.EncodeToString(akS))
+
+	autn, err := m.generateAutn(rand, sqn, amf)
+	assert.NoError(t, err)
+	got, _, err := m.verifyAutn(rand, autn)
+	assert.NoError(t, err)
+	assert.Equal(t, sqn, got)
+
+	autn[15] ^= 1
+	_, _, err = m.verifyAutn(rand, autn)
+	assert.ErrorIs(t, err, errMilenageMacFailure)
+}
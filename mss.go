@@ -0,0 +1,121 @@
+package soratun
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// mssTun wraps tun.Device and clamps TCP MSS option of SYN packets in both directions to fit in the MTU, so that TCP
+// connections forwarded through the tunnel avoid fragmentation even if end hosts have larger MTU.
+type mssTun struct {
+	tun.Device
+	mtu atomic.Int32
+}
+
+func newMssTun(t tun.Device, mtu int) *mssTun {
+	m := &mssTun{Device: t}
+	m.setMTU(mtu)
+	return m
+}
+
+// setMTU updates the MTU which MSS is calculated from.
+func (t *mssTun) setMTU(mtu int) {
+	t.mtu.Store(int32(mtu))
+}
+
+// Read reads packets from the device, then clamps MSS of outbound SYN packets.
+func (t *mssTun) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := t.Device.Read(bufs, sizes, offset)
+	mtu := int(t.mtu.Load())
+	for i := 0; i < n; i++ {
+		clampMSS(bufs[i][offset:offset+sizes[i]], mtu)
+	}
+	return n, err
+}
+
+// Write clamps MSS of inbound SYN packets, then writes packets to the device.
+func (t *mssTun) Write(bufs [][]byte, offset int) (int, error) {
+	mtu := int(t.mtu.Load())
+	for _, buf := range bufs {
+		clampMSS(buf[offset:], mtu)
+	}
+	return t.Device.Write(bufs, offset)
+}
+
+// clampMSS rewrites MSS option of a TCP SYN packet in place if it is larger than mtu allows, and updates TCP checksum
+// incrementally. clampMSS returns true if the packet was modified.
+func clampMSS(packet []byte, mtu int) bool {
+	if len(packet) < 1 {
+		return false
+	}
+
+	var segment []byte
+	var headers int
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0x0f) * 4
+		// skip non-TCP packets and non-first fragments
+		if len(packet) < 20 || ihl < 20 || len(packet) < ihl || packet[9] != protocolTCP || binary.BigEndian.Uint16(packet[6:8])&0x1fff != 0 {
+			return false
+		}
+		segment, headers = packet[ihl:], 20+20
+	case 6:
+		// extension headers are rare for SYN packets, so only the fixed header is supported
+		if len(packet) < 40 || packet[6] != protocolTCP {
+			return false
+		}
+		segment, headers = packet[40:], 40+20
+	default:
+		return false
+	}
+
+	if len(segment) < 20 || segment[13]&0x02 == 0 {
+		return false
+	}
+	dataOffset := int(segment[12]>>4) * 4
+	if dataOffset < 20 || len(segment) < dataOffset {
+		return false
+	}
+
+	mss := mtu - headers
+	if mss <= 0 {
+		return false
+	}
+
+	options := segment[20:dataOffset]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case 0: // end of option list
+			return false
+		case 1: // no-operation
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return false
+		}
+		if options[i] == 2 && options[i+1] == 4 {
+			current := binary.BigEndian.Uint16(options[i+2 : i+4])
+			if int(current) <= mss {
+				return false
+			}
+			binary.BigEndian.PutUint16(options[i+2:i+4], uint16(mss))
+			checksum := binary.BigEndian.Uint16(segment[16:18])
+			binary.BigEndian.PutUint16(segment[16:18], updateChecksum(checksum, current, uint16(mss)))
+			return true
+		}
+		i += int(options[i+1])
+	}
+	return false
+}
+
+// updateChecksum returns the Internet checksum after a 16-bit word changed from old to new, as described in RFC 1624.
+func updateChecksum(checksum, old, new uint16) uint16 {
+	sum := uint32(^checksum) + uint32(^old) + uint32(new)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
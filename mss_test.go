@@ -0,0 +1,59 @@
+package soratun
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tcpChecksum returns TCP checksum of the IPv4 packet, computed from scratch.
+func tcpChecksum(packet []byte) uint16 {
+	segment := packet[20:]
+	pseudo := make([]byte, 12, 12+len(segment))
+	copy(pseudo[0:8], packet[12:20])
+	pseudo[9] = protocolTCP
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(segment)))
+	b := append(pseudo, segment...)
+	b[12+16], b[12+17] = 0, 0
+
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// tcpSynPacket returns an IPv4 TCP SYN packet with MSS option and valid checksum.
+func tcpSynPacket(mss uint16) []byte {
+	b := ipv4Packet(protocolTCP, "10.0.0.1", "100.127.0.1", 40000, 22)
+	b = append(b, 0x02, 0x04, 0, 0, 0x01, 0x01, 0x04, 0x02) // MSS, NOP, NOP, SACK permitted
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[20+12] = 7 << 4
+	b[20+13] = 0x02
+	binary.BigEndian.PutUint16(b[40+2:40+4], mss)
+	binary.BigEndian.PutUint16(b[20+16:20+18], tcpChecksum(b))
+	return b
+}
+
+func Test_clampMSS(t *testing.T) {
+	p := tcpSynPacket(1460)
+	assert.True(t, clampMSS(p, 1380))
+	assert.EqualValues(t, 1340, binary.BigEndian.Uint16(p[42:44]))
+	assert.Equal(t, tcpChecksum(p), binary.BigEndian.Uint16(p[36:38]))
+
+	// smaller MSS is kept as is
+	p = tcpSynPacket(1200)
+	assert.False(t, clampMSS(p, 1380))
+	assert.EqualValues(t, 1200, binary.BigEndian.Uint16(p[42:44]))
+
+	// non-SYN packets are not modified
+	p = tcpSynPacket(1460)
+	p[20+13] = 0x10
+	assert.False(t, clampMSS(p, 1380))
+
+	assert.False(t, clampMSS(ipv4Packet(protocolUDP, "10.0.0.1", "100.127.0.1", 40000, 53), 1380))
+}
@@ -2,6 +2,8 @@ package soratun
 
 import (
 	"fmt"
+	"net"
+	"strings"
 
 	"golang.zx2c4.com/wireguard/device"
 )
@@ -13,21 +15,12 @@ func ConfigureInterface(iname string, config *Config) error {
 		fmt.Sprintf("(%s) ", iname),
 	)
 
-	command := []string{"sudo", "ifconfig", iname, config.ArcSession.ArcClientPeerIpAddress.String(), config.ArcSession.ArcClientPeerIpAddress.String()}
-	logger.Verbosef("assign IP address: %s", command)
-	_, err := runCommand(command)
-	if err != nil {
-		return err
-	}
-
-	for _, allowedIP := range config.ArcSession.ArcAllowedIPs {
-		prefix, _ := allowedIP.Mask.Size()
-		if prefix == 32 {
-			command = []string{"sudo", "route", "add", "-host", allowedIP.IP.String(), "-interface", iname}
+	for i, command := range interfaceCommands(iname, config) {
+		if i == 0 {
+			logger.Verbosef("assign IP address: %s", command)
 		} else {
-			command = []string{"sudo", "route", "add", "-net", fmt.Sprintf("%s/%d", allowedIP.IP, prefix), "-interface", iname}
+			logger.Verbosef("update routing table: %s", command)
 		}
-		logger.Verbosef("update routing table: %s", command)
 		result, err := runCommand(command)
 		if err != nil {
 			return err
@@ -36,3 +29,38 @@ func ConfigureInterface(iname string, config *Config) error {
 	}
 	return nil
 }
+
+// interfaceOperations returns human-readable operations which ConfigureInterface will perform.
+func interfaceOperations(iname string, config *Config) []string {
+	var ops []string
+	for _, command := range interfaceCommands(iname, config) {
+		ops = append(ops, strings.Join(command, " "))
+	}
+	return ops
+}
+
+// interfaceCommands returns commands to assign IP address to the interface, then update routing table for allowedIPs.
+func interfaceCommands(iname string, config *Config) [][]string {
+	commands := [][]string{
+		{"sudo", "ifconfig", iname, config.ArcSession.ArcClientPeerIpAddress.String(), config.ArcSession.ArcClientPeerIpAddress.String()},
+	}
+
+	for _, allowedIP := range config.ArcSession.ArcAllowedIPs {
+		prefix, _ := allowedIP.Mask.Size()
+		if prefix == 32 {
+			commands = append(commands, []string{"sudo", "route", "add", "-host", allowedIP.IP.String(), "-interface", iname})
+		} else {
+			commands = append(commands, []string{"sudo", "route", "add", "-net", fmt.Sprintf("%s/%d", allowedIP.IP, prefix), "-interface", iname})
+		}
+	}
+	return commands
+}
+
+// DeleteInterface removes the interface, which is left after the owning process is gone. utun interfaces are removed
+// by the kernel once no process holds them, so DeleteInterface only reports if the interface still exists.
+func DeleteInterface(iname string) error {
+	if _, err := net.InterfaceByName(iname); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s is still held by another process", iname)
+}
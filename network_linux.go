@@ -56,3 +56,26 @@ func ConfigureInterface(iname string, config *Config) error {
 
 	return nil
 }
+
+// interfaceOperations returns human-readable netlink operations which ConfigureInterface will perform.
+func interfaceOperations(iname string, config *Config) []string {
+	ops := []string{
+		fmt.Sprintf("netlink: add address %s/32 to %s", config.ArcSession.ArcClientPeerIpAddress, iname),
+		fmt.Sprintf("netlink: set link %s up", iname),
+	}
+
+	for _, allowedIP := range config.ArcSession.ArcAllowedIPs {
+		prefix, _ := allowedIP.Mask.Size()
+		ops = append(ops, fmt.Sprintf("netlink: replace route %s/%d dev %s scope link", allowedIP.IP, prefix, iname))
+	}
+	return ops
+}
+
+// DeleteInterface removes the interface, which is left after the owning process is gone.
+func DeleteInterface(iname string) error {
+	iface, err := netlink.LinkByName(iname)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkDel(iface)
+}
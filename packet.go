@@ -0,0 +1,127 @@
+package soratun
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// IP protocol numbers which soratun inspects.
+const (
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+)
+
+// packetInfo holds header fields of an IP packet which soratun inspects.
+type packetInfo struct {
+	protocol uint8
+	src      net.IP
+	dst      net.IP
+	srcPort  uint16
+	dstPort  uint16
+	tcpFlags uint8
+	// fragmented is true if the packet is a fragment, which is identified with fragmentId among packets of the same
+	// addresses and protocol. Only the first fragment, with fragmentOffset 0, has ports.
+	fragmented     bool
+	fragmentId     uint32
+	fragmentOffset uint16
+}
+
+// parsePacket parses IPv4 or IPv6 header and following TCP/UDP header of b. parsePacket returns false if b is not a
+// valid IP packet.
+func parsePacket(b []byte) (*packetInfo, bool) {
+	if len(b) < 1 {
+		return nil, false
+	}
+
+	var p packetInfo
+	var payload []byte
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < 20 || ihl < 20 || len(b) < ihl {
+			return nil, false
+		}
+		p.protocol = b[9]
+		p.src = net.IP(b[12:16])
+		p.dst = net.IP(b[16:20])
+		// more fragments flag, and fragment offset
+		flags := binary.BigEndian.Uint16(b[6:8])
+		if flags&0x3fff != 0 {
+			p.fragmented = true
+			p.fragmentId = uint32(binary.BigEndian.Uint16(b[4:6]))
+			p.fragmentOffset = flags & 0x1fff
+		}
+		// only the first fragment has transport header
+		if p.fragmentOffset != 0 {
+			return &p, true
+		}
+		payload = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return nil, false
+		}
+		p.src = net.IP(b[8:24])
+		p.dst = net.IP(b[24:40])
+		next, offset := b[6], 40
+		for {
+			// skip hop-by-hop, routing, fragment, and destination options extension headers
+			if next != 0 && next != 43 && next != 44 && next != 60 {
+				break
+			}
+			if len(b) < offset+8 {
+				return nil, false
+			}
+			if next == 44 {
+				p.fragmented = true
+				p.fragmentId = binary.BigEndian.Uint32(b[offset+4 : offset+8])
+				p.fragmentOffset = binary.BigEndian.Uint16(b[offset+2:offset+4]) >> 3
+				if p.fragmentOffset != 0 {
+					p.protocol = b[offset]
+					return &p, true
+				}
+			}
+			length := 8
+			if next != 44 {
+				length = (int(b[offset+1]) + 1) * 8
+			}
+			next, offset = b[offset], offset+length
+		}
+		if len(b) < offset {
+			return nil, false
+		}
+		p.protocol = next
+		payload = b[offset:]
+	default:
+		return nil, false
+	}
+
+	switch p.protocol {
+	case protocolTCP:
+		if len(payload) >= 14 {
+			p.tcpFlags = payload[13]
+		}
+		fallthrough
+	case protocolUDP:
+		if len(payload) >= 4 {
+			p.srcPort = binary.BigEndian.Uint16(payload[0:2])
+			p.dstPort = binary.BigEndian.Uint16(payload[2:4])
+		}
+	}
+	return &p, true
+}
+
+// protocolName returns name of the IP protocol number.
+func protocolName(protocol uint8) string {
+	switch protocol {
+	case protocolICMP, protocolICMPv6:
+		return "icmp"
+	case protocolTCP:
+		return "tcp"
+	case protocolUDP:
+		return "udp"
+	default:
+		return ""
+	}
+}
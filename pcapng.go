@@ -0,0 +1,95 @@
+package soratun
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapng block types and options, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html
+const (
+	pcapngSectionHeaderBlock        = 0x0a0d0d0a
+	pcapngInterfaceDescriptionBlock = 0x00000001
+	pcapngEnhancedPacketBlock       = 0x00000006
+	pcapngByteOrderMagic            = 0x1a2b3c4d
+	pcapngOptionEndOfOpt            = 0
+	pcapngOptionIfName              = 2
+	pcapngOptionEpbFlags            = 2
+	pcapngEpbFlagsInbound           = 0x1
+	pcapngEpbFlagsOutbound          = 0x2
+	// pcapngLinkTypeRaw is LINKTYPE_RAW, raw IPv4 or IPv6 packet without any link layer header.
+	pcapngLinkTypeRaw = 101
+	// pcapngSnapLen is maximum length of captured packets, 0 means no limit.
+	pcapngSnapLen = 0
+)
+
+// pcapngWriter writes raw IP packets in pcapng format. Timestamps are in microseconds, the default resolution.
+type pcapngWriter struct {
+	w io.Writer
+}
+
+// newPcapngWriter writes section header block and interface description block with given interface name to w, then
+// returns a writer for packets.
+func newPcapngWriter(w io.Writer, iname string) (*pcapngWriter, int, error) {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:6], 1) // major version
+	binary.LittleEndian.PutUint16(shb[6:8], 0) // minor version
+	binary.LittleEndian.PutUint64(shb[8:16], 0xffffffffffffffff)
+	n, err := writePcapngBlock(w, pcapngSectionHeaderBlock, shb)
+	if err != nil {
+		return nil, n, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:2], pcapngLinkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[4:8], pcapngSnapLen)
+	idb = appendPcapngOption(idb, pcapngOptionIfName, []byte(iname))
+	idb = appendPcapngOption(idb, pcapngOptionEndOfOpt, nil)
+	m, err := writePcapngBlock(w, pcapngInterfaceDescriptionBlock, idb)
+	return &pcapngWriter{w: w}, n + m, err
+}
+
+// writePacket writes an enhanced packet block for the packet, and returns number of bytes written.
+func (p *pcapngWriter) writePacket(ts time.Time, packet []byte, inbound bool) (int, error) {
+	usec := uint64(ts.UnixMicro())
+	epb := make([]byte, 20, 20+len(packet)+16)
+	binary.LittleEndian.PutUint32(epb[0:4], 0) // interface ID
+	binary.LittleEndian.PutUint32(epb[4:8], uint32(usec>>32))
+	binary.LittleEndian.PutUint32(epb[8:12], uint32(usec))
+	binary.LittleEndian.PutUint32(epb[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:20], uint32(len(packet)))
+	epb = append(epb, packet...)
+	epb = append(epb, make([]byte, pad4(len(packet)))...)
+
+	flags := make([]byte, 4)
+	if inbound {
+		binary.LittleEndian.PutUint32(flags, pcapngEpbFlagsInbound)
+	} else {
+		binary.LittleEndian.PutUint32(flags, pcapngEpbFlagsOutbound)
+	}
+	epb = appendPcapngOption(epb, pcapngOptionEpbFlags, flags)
+	epb = appendPcapngOption(epb, pcapngOptionEndOfOpt, nil)
+	return writePcapngBlock(p.w, pcapngEnhancedPacketBlock, epb)
+}
+
+func writePcapngBlock(w io.Writer, blockType uint32, body []byte) (int, error) {
+	length := uint32(12 + len(body))
+	b := make([]byte, 0, length)
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	return w.Write(b)
+}
+
+func appendPcapngOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
@@ -0,0 +1,137 @@
+//go:build !windows
+
+package soratun
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// interfaceLock is an exclusive lock on a pidfile for the interface, which is held while soratun manages the
+// interface. The lock is released by the kernel even if the process exits without cleanup.
+type interfaceLock struct {
+	path string
+	file *os.File
+}
+
+// PidFilePath returns path to the pidfile of the soratun process which manages the interface.
+func PidFilePath(iname string) string {
+	return filepath.Join(ControlSocketDirectory, iname+".pid")
+}
+
+// errInterfaceLocked is returned by lockInterface if another process holds the lock.
+var errInterfaceLocked = errors.New("interface is locked")
+
+// lockInterface acquires the lock for the interface and writes the process ID to the pidfile. If another process holds
+// the lock, lockInterface returns errInterfaceLocked with its process ID.
+func lockInterface(iname string) (*interfaceLock, error) {
+	if err := os.MkdirAll(ControlSocketDirectory, 0755); err != nil {
+		return nil, err
+	}
+
+	path := PidFilePath(iname)
+	l, err := lockPidFile(path)
+	if errors.Is(err, errInterfaceLocked) {
+		pid, _ := readPid(path)
+		return nil, fmt.Errorf("%w, another soratun process (pid %d) is managing %s", err, pid, iname)
+	}
+	return l, err
+}
+
+// lockPidFile acquires the lock on the pidfile at path, and writes the process ID to it.
+func lockPidFile(path string) (*interfaceLock, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			_ = f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, errInterfaceLocked
+			}
+			return nil, err
+		}
+
+		// the previous owner removes the pidfile before releasing the lock, so the file may have been removed after it
+		// was opened, while another process locks a new file at path
+		same, err := isSameFile(f, path)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		if !same {
+			_ = f.Close()
+			continue
+		}
+
+		if err := f.Truncate(0); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return &interfaceLock{path: path, file: f}, nil
+	}
+}
+
+// isSameFile returns true if f is the file at path.
+func isSameFile(f *os.File, path string) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pfi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi, pfi), nil
+}
+
+// Unlock removes the pidfile, then releases the lock. It does nothing if l is nil, i.e. soratun runs without the lock.
+func (l *interfaceLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	_ = os.Remove(l.path)
+	return l.file.Close()
+}
+
+// InterfaceOwner returns the process ID of the soratun process which manages the interface. running is false if no
+// process holds the lock, even if a stale pidfile is left.
+func InterfaceOwner(iname string) (pid int, running bool) {
+	path := PidFilePath(iname)
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return 0, false
+	}
+
+	pid, _ = readPid(path)
+	return pid, true
+}
+
+func readPid(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package soratun
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lockPidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arc0.pid")
+
+	l, err := lockPidFile(path)
+	assert.NoError(t, err)
+	pid, err := readPid(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	_, err = lockPidFile(path)
+	assert.ErrorIs(t, err, errInterfaceLocked)
+
+	// a process which opened the pidfile before it is removed must not lock the removed file
+	old, err := os.Open(path)
+	assert.NoError(t, err)
+	defer old.Close()
+	assert.NoError(t, l.Unlock())
+	same, err := isSameFile(old, path)
+	assert.NoError(t, err)
+	assert.False(t, same)
+
+	l, err = lockPidFile(path)
+	assert.NoError(t, err)
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(b))
+	assert.NoError(t, l.Unlock())
+
+	var none *interfaceLock
+	assert.NoError(t, none.Unlock())
+}
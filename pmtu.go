@@ -0,0 +1,232 @@
+package soratun
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// minMTU is the minimum MTU every IPv4 host must accept.
+	minMTU = 576
+	// maxPathMTU is the largest path MTU to probe, which is the Ethernet MTU.
+	maxPathMTU = 1500
+	// wireGuardOverheadIPv4 is the size of IPv4, UDP, and WireGuard data message headers.
+	wireGuardOverheadIPv4 = 20 + 8 + 32
+	// wireGuardOverheadIPv6 is the size of IPv6, UDP, and WireGuard data message headers.
+	wireGuardOverheadIPv6 = 40 + 8 + 32
+	// pmtuProbeTimeout is the time to wait for an echo reply for each probe.
+	pmtuProbeTimeout = time.Second
+	// pmtuProbeAttempts is the number of probes for each size before the size is treated as too large.
+	pmtuProbeAttempts = 2
+	// pmtuProbeInterval is an interval to discover the path MTU again while the tunnel is up.
+	pmtuProbeInterval = 10 * time.Minute
+)
+
+// errPmtuProbeTooBig means the probe could not be sent since the packet is larger than the local interface MTU.
+var errPmtuProbeTooBig = errors.New("packet too big")
+
+// ProbePathMTU discovers path MTU to ip with ICMP echo requests which are not allowed to be fragmented. ProbePathMTU
+// returns the largest packet size, including IP header, which reached ip and got the reply. It returns an error if the
+// host does not reply to even the smallest probe, e.g. ICMP is filtered on the path.
+func ProbePathMTU(ip net.IP) (int, error) {
+	p, err := newPmtuProber(ip)
+	if err != nil {
+		return 0, err
+	}
+	defer p.conn.Close()
+
+	lo, hi := minMTU, maxPathMTU
+	if p.ipv6 {
+		lo = 1280
+	}
+
+	// most paths have the Ethernet MTU, so try it first
+	if p.probe(hi) {
+		return hi, nil
+	}
+	if !p.probe(lo) {
+		return 0, fmt.Errorf("no ICMP echo reply from %s", ip)
+	}
+
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if p.probe(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// TunnelMTU returns the tunnel interface MTU for given path MTU to the SORACOM Arc server at ip.
+func TunnelMTU(pathMTU int, ip net.IP) int {
+	if ip.To4() == nil {
+		return pathMTU - wireGuardOverheadIPv6
+	}
+	return pathMTU - wireGuardOverheadIPv4
+}
+
+// DiscoverTunnelMTU probes path MTU to the SORACOM Arc server at ip, then returns the tunnel interface MTU.
+func DiscoverTunnelMTU(ip net.IP) (int, error) {
+	pathMTU, err := ProbePathMTU(ip)
+	if err != nil {
+		return 0, err
+	}
+	return TunnelMTU(pathMTU, ip), nil
+}
+
+// listenICMP opens an ICMP socket which never fragments outgoing packets. listenICMP tries a raw socket first, then
+// falls back to an unprivileged ICMP socket (net.ipv4.ping_group_range on Linux). privileged is true for the raw one.
+func listenICMP(ipv6 bool) (conn net.PacketConn, privileged bool, err error) {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+
+	conn, err = net.ListenPacket(network, address)
+	if err == nil {
+		rc, err := conn.(syscall.Conn).SyscallConn()
+		if err != nil {
+			conn.Close()
+			return nil, false, err
+		}
+		var serr error
+		if err := rc.Control(func(fd uintptr) {
+			serr = setDontFragment(int(fd), ipv6)
+		}); err != nil {
+			serr = err
+		}
+		if serr != nil {
+			conn.Close()
+			return nil, false, serr
+		}
+		return conn, true, nil
+	}
+
+	family, protocol := syscall.AF_INET, syscall.IPPROTO_ICMP
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if ipv6 {
+		family, protocol = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa = &syscall.SockaddrInet6{}
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, protocol)
+	if err != nil {
+		return nil, false, err
+	}
+	syscall.CloseOnExec(fd)
+	if err := setDontFragment(fd, ipv6); err != nil {
+		syscall.Close(fd)
+		return nil, false, err
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, false, err
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	conn, err = net.FilePacketConn(f)
+	return conn, false, err
+}
+
+type pmtuProber struct {
+	conn net.PacketConn
+	dst  net.Addr
+	ipv6 bool
+	id   int
+	seq  int
+}
+
+func newPmtuProber(ip net.IP) (*pmtuProber, error) {
+	ipv6 := ip.To4() == nil
+	conn, privileged, err := listenICMP(ipv6)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMP socket: %w", err)
+	}
+
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if !privileged {
+		dst = &net.UDPAddr{IP: ip}
+	}
+
+	return &pmtuProber{
+		conn: conn,
+		dst:  dst,
+		ipv6: ipv6,
+		id:   os.Getpid() & 0xffff,
+		seq:  rand.Intn(0xffff),
+	}, nil
+}
+
+// probe returns true if an echo request of size bytes, including IP header, got the reply.
+func (p *pmtuProber) probe(size int) bool {
+	for i := 0; i < pmtuProbeAttempts; i++ {
+		ok, err := p.echo(size)
+		if ok {
+			return true
+		}
+		if errors.Is(err, errPmtuProbeTooBig) {
+			return false
+		}
+	}
+	return false
+}
+
+func (p *pmtuProber) echo(size int) (bool, error) {
+	p.seq = (p.seq + 1) & 0xffff
+
+	var typ, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	protocol, header := protocolICMP, 20+8
+	if p.ipv6 {
+		typ, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		protocol, header = protocolICMPv6, 40+8
+	}
+
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: p.id, Seq: p.seq, Data: make([]byte, size-header)},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := p.conn.WriteTo(b, p.dst); err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return false, errPmtuProbeTooBig
+		}
+		return false, err
+	}
+
+	deadline := time.Now().Add(pmtuProbeTimeout)
+	if err := p.conn.SetReadDeadline(deadline); err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, maxPathMTU+100)
+	for {
+		n, _, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return false, err
+		}
+		m, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || m.Type != reply {
+			continue
+		}
+		// unprivileged ICMP sockets rewrite the identifier, so match replies by sequence number and size
+		if echo, ok := m.Body.(*icmp.Echo); ok && echo.Seq == p.seq && len(echo.Data) == size-header {
+			return true, nil
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package soratun
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// socket options from netinet/in.h and netinet6/in6.h, which syscall package does not define.
+const (
+	ipDontFrag   = 28
+	ipv6DontFrag = 62
+)
+
+// setDontFragment sets DF bit on packets sent from the socket.
+func setDontFragment(fd int, ipv6 bool) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, ipv6DontFrag, 1)
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, ipDontFrag, 1)
+}
+
+// setInterfaceMTU updates MTU of the interface.
+func setInterfaceMTU(iname string, mtu int) error {
+	_, err := runCommand([]string{"sudo", "ifconfig", iname, "mtu", strconv.Itoa(mtu)})
+	return err
+}
@@ -0,0 +1,25 @@
+package soratun
+
+import (
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// setDontFragment sets DF bit on packets sent from the socket, ignoring cached path MTU so that probes larger than the
+// path MTU will actually be sent.
+func setDontFragment(fd int, ipv6 bool) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+}
+
+// setInterfaceMTU updates MTU of the interface.
+func setInterfaceMTU(iname string, mtu int) error {
+	iface, err := netlink.LinkByName(iname)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetMTU(iface, mtu)
+}
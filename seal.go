@@ -0,0 +1,122 @@
+package soratun
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// SealedPrefix is a prefix of secret fields sealed by `soratun config encrypt`. The rest is base64 encoded salt, nonce
+// and XChaCha20-Poly1305 ciphertext, with a key derived by scrypt from a passphrase or keyfile.
+const SealedPrefix = "sealed:v1:"
+
+// scrypt parameters recommended for interactive logins as of 2017, which take less than 100ms on a recent machine.
+const (
+	sealSaltSize = 16
+	sealScryptN  = 1 << 15
+	sealScryptR  = 8
+	sealScryptP  = 1
+)
+
+// ErrConfigSealed is returned when the configuration has sealed fields, but no key is supplied.
+var ErrConfigSealed = errors.New("configuration is sealed, but no passphrase or keyfile is supplied")
+
+// IsSealed returns true if s is a sealed secret.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, SealedPrefix)
+}
+
+// sealer seals and opens secret fields. Since deriving a key is deliberately slow, keys are cached by salt, and
+// sealing reuses the salt of the last opened field.
+type sealer struct {
+	secret []byte
+	salt   []byte
+	aeads  map[string]cipher.AEAD
+}
+
+func newSealer(secret []byte) *sealer {
+	return &sealer{secret: secret, aeads: map[string]cipher.AEAD{}}
+}
+
+func (s *sealer) aead(salt []byte) (cipher.AEAD, error) {
+	if a, ok := s.aeads[string(salt)]; ok {
+		return a, nil
+	}
+	key, err := scrypt.Key(s.secret, salt, sealScryptN, sealScryptR, sealScryptP, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	a, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[string(salt)] = a
+	return a, nil
+}
+
+// seal encrypts value of the field. The field name is authenticated, so a sealed value can't be moved to another
+// field.
+func (s *sealer) seal(field, value string) (string, error) {
+	if s.salt == nil {
+		s.salt = make([]byte, sealSaltSize)
+		if _, err := rand.Read(s.salt); err != nil {
+			return "", err
+		}
+	}
+	a, err := s.aead(s.salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, a.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	b := append(append([]byte{}, s.salt...), nonce...)
+	b = a.Seal(b, nonce, []byte(value), []byte(field))
+	return SealedPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// open decrypts sealed value of the field.
+func (s *sealer) open(field, sealed string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, SealedPrefix))
+	if err != nil || len(b) < sealSaltSize+chacha20poly1305.NonceSizeX {
+		return "", fmt.Errorf("invalid sealed value of %s", field)
+	}
+
+	salt, nonce, ciphertext := b[:sealSaltSize], b[sealSaltSize:sealSaltSize+chacha20poly1305.NonceSizeX], b[sealSaltSize+chacha20poly1305.NonceSizeX:]
+	a, err := s.aead(salt)
+	if err != nil {
+		return "", err
+	}
+	v, err := a.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("failed to unseal %s, wrong passphrase or keyfile?", field)
+	}
+
+	s.salt = salt
+	return string(v), nil
+}
+
+// Seal makes MarshalConfig encrypt secret fields with a key derived from secret, which is a passphrase or content of a
+// keyfile. Fields which refer to secret references are kept as they are.
+func (c *Config) Seal(secret []byte) {
+	c.sealer = newSealer(secret)
+}
+
+// Unseal makes MarshalConfig write secret fields in plaintext.
+func (c *Config) Unseal() {
+	c.sealer = nil
+}
+
+// Sealed returns true if MarshalConfig encrypts secret fields.
+func (c *Config) Sealed() bool {
+	return c.sealer != nil
+}
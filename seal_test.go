@@ -0,0 +1,69 @@
+package soratun
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Config_Seal(t *testing.T) {
+	privateKey := "mPsgZYoFcWX6wpSkH6QgqKuQaoEGdqU6lbNNG0S2ZXs="
+	t.Setenv("SORATUN_TEST_AUTH_KEY", "secret-xxx")
+
+	config, err := UnmarshalConfig([]byte(`{
+  "privateKey": "`+privateKey+`",
+  "simId": "8942310022000000000",
+  "profile": {
+    "authKey": "env:SORATUN_TEST_AUTH_KEY",
+    "authKeyId": "keyId-xxx",
+    "endpoint": "https://api.soracom.io"
+  }
+}`), nil)
+	assert.NoError(t, err)
+	assert.False(t, config.Sealed())
+
+	config.Seal([]byte("passphrase"))
+	b, err := MarshalConfig(config)
+	assert.NoError(t, err)
+
+	var raw struct {
+		PrivateKey string `json:"privateKey"`
+		SimId      string `json:"simId"`
+		Profile    struct {
+			AuthKey   string `json:"authKey"`
+			AuthKeyID string `json:"authKeyId"`
+		} `json:"profile"`
+	}
+	assert.NoError(t, json.Unmarshal(b, &raw))
+	assert.True(t, IsSealed(raw.PrivateKey))
+	assert.True(t, IsSealed(raw.Profile.AuthKeyID))
+	assert.Equal(t, "env:SORATUN_TEST_AUTH_KEY", raw.Profile.AuthKey)
+	assert.Equal(t, "8942310022000000000", raw.SimId)
+
+	_, err = UnmarshalConfig(b, nil)
+	assert.True(t, errors.Is(err, ErrConfigSealed))
+	_, err = UnmarshalConfig(b, []byte("wrong"))
+	assert.Error(t, err)
+
+	opened, err := UnmarshalConfig(b, []byte("passphrase"))
+	assert.NoError(t, err)
+	assert.True(t, opened.Sealed())
+	assert.Equal(t, privateKey, opened.PrivateKey.String())
+	assert.Equal(t, "keyId-xxx", opened.Profile.AuthKeyID)
+	assert.Equal(t, "secret-xxx", opened.Profile.AuthKey)
+
+	// a sealed value can't be moved to another field
+	swapped := strings.Replace(string(b), `"authKeyId": "`+raw.Profile.AuthKeyID, `"authKeyId": "`+raw.PrivateKey, 1)
+	_, err = UnmarshalConfig([]byte(swapped), []byte("passphrase"))
+	assert.Error(t, err)
+
+	opened.Unseal()
+	b, err = MarshalConfig(opened)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"privateKey": "`+privateKey+`"`)
+	assert.Contains(t, string(b), `"authKeyId": "keyId-xxx"`)
+	assert.Contains(t, string(b), `"authKey": "env:SORATUN_TEST_AUTH_KEY"`)
+}
@@ -0,0 +1,350 @@
+package soratun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// prefixes of secret references, which can be used for secret fields in the configuration instead of plaintext.
+const (
+	// SecretRefEnv refers to an environment variable, e.g. "env:SORACOM_AUTH_KEY".
+	SecretRefEnv = "env:"
+	// SecretRefFile refers to a file, e.g. "file:/run/secrets/auth_key".
+	SecretRefFile = "file:"
+	// SecretRefExec refers to the output of a command, e.g. "exec:/usr/bin/vault-helper arc".
+	SecretRefExec = "exec:"
+	// SecretRefCredential refers to a systemd credential in $CREDENTIALS_DIRECTORY, e.g. "credential:auth_key".
+	SecretRefCredential = "credential:"
+)
+
+// secretExecTimeout is a deadline for a command of "exec:" secret reference.
+const secretExecTimeout = 30 * time.Second
+
+// secretFields are JSON paths of the configuration fields which accept secret references.
+var secretFields = []string{"privateKey", "profile.authKeyId", "profile.authKey"}
+
+// IsSecretRef returns true if s is a secret reference rather than a plaintext value.
+func IsSecretRef(s string) bool {
+	for _, p := range []string{SecretRefEnv, SecretRefFile, SecretRefExec, SecretRefCredential} {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveSecret returns the secret which ref refers to. Leading and trailing whitespaces, e.g. a newline at the end
+// of a file, are removed.
+func ResolveSecret(ref string) (string, error) {
+	var v string
+	switch {
+	case strings.HasPrefix(ref, SecretRefEnv):
+		name := strings.TrimPrefix(ref, SecretRefEnv)
+		v = os.Getenv(name)
+		if v == "" {
+			return "", fmt.Errorf("environment variable %s for secret is not set", name)
+		}
+	case strings.HasPrefix(ref, SecretRefFile):
+		b, err := os.ReadFile(strings.TrimPrefix(ref, SecretRefFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		v = string(b)
+	case strings.HasPrefix(ref, SecretRefExec):
+		args := strings.Fields(strings.TrimPrefix(ref, SecretRefExec))
+		if len(args) == 0 {
+			return "", errors.New("no command is specified for secret")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), secretExecTimeout)
+		defer cancel()
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = &stderr
+		b, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to run %s for secret: %w\n%s", args[0], err, &stderr)
+		}
+		v = string(b)
+	case strings.HasPrefix(ref, SecretRefCredential):
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return "", errors.New("CREDENTIALS_DIRECTORY is not set, credentials are available only for systemd services with LoadCredential=")
+		}
+		name := strings.TrimPrefix(ref, SecretRefCredential)
+		if name == "" || strings.ContainsRune(name, '/') {
+			return "", fmt.Errorf("invalid credential name %q", name)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read credential: %w", err)
+		}
+		v = string(b)
+	default:
+		return "", fmt.Errorf("unknown secret reference %q", ref)
+	}
+
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", fmt.Errorf("secret %s is empty", ref)
+	}
+	return v, nil
+}
+
+// secretRef is a secret reference which a configuration field was loaded from, and the value it resolved to.
+type secretRef struct {
+	ref   string
+	value string
+}
+
+// UnmarshalConfig parses JSON configuration, resolving secret references and opening sealed values in secret fields
+// with sealKey, which is a passphrase or content of a keyfile. sealKey may be nil if the configuration is not sealed.
+// References and the key are kept in the returned Config, so MarshalConfig can write the fields back in the same way.
+func UnmarshalConfig(b []byte, sealKey []byte) (*Config, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	var s *sealer
+	refs := map[string]string{}
+	modified := false
+	for _, field := range secretFields {
+		path := strings.Split(field, ".")
+		obj := raw
+		if len(path) == 2 {
+			obj = nil
+			if err := json.Unmarshal(raw[path[0]], &obj); err != nil || obj == nil {
+				continue
+			}
+		}
+
+		var v string
+		if err := json.Unmarshal(obj[path[len(path)-1]], &v); err != nil {
+			continue
+		}
+
+		var err error
+		switch {
+		case IsSealed(v):
+			if sealKey == nil {
+				return nil, fmt.Errorf("failed to read %s: %w", field, ErrConfigSealed)
+			}
+			if s == nil {
+				s = newSealer(sealKey)
+			}
+			if v, err = s.open(field, v); err != nil {
+				return nil, err
+			}
+		case IsSecretRef(v):
+			ref := v
+			if v, err = ResolveSecret(ref); err != nil {
+				return nil, fmt.Errorf("failed to resolve %s: %w", field, err)
+			}
+			refs[field] = ref
+		default:
+			continue
+		}
+		modified = true
+
+		obj[path[len(path)-1]], _ = json.Marshal(v)
+		if len(path) == 2 {
+			if raw[path[0]], err = json.Marshal(obj); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if modified {
+		var err error
+		if b, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var config Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+
+	config.sealer = s
+	for field, ref := range refs {
+		v, _ := config.secretValue(field)
+		if config.secretRefs == nil {
+			config.secretRefs = map[string]secretRef{}
+		}
+		config.secretRefs[field] = secretRef{ref: ref, value: v}
+	}
+	return &config, nil
+}
+
+// MarshalConfig encodes the configuration as indented JSON. Secret fields which were loaded from secret references
+// are written back as the references, unless the value was changed, e.g. by bootstrap issuing a new key. Other secret
+// fields are sealed if the configuration was loaded with sealed fields or Config.Seal was called.
+func MarshalConfig(c *Config) ([]byte, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range secretFields {
+		v, ok := c.secretValue(field)
+		if !ok || v == "" {
+			continue
+		}
+
+		var replacement string
+		if r, ok := c.secretRefs[field]; ok && v == r.value {
+			replacement = r.ref
+		} else if c.sealer != nil {
+			if replacement, err = c.sealer.seal(field, v); err != nil {
+				return nil, err
+			}
+		} else {
+			continue
+		}
+
+		key, _ := json.Marshal(field[strings.LastIndex(field, ".")+1:])
+		value, _ := json.Marshal(v)
+		r, _ := json.Marshal(replacement)
+		b = bytes.Replace(b, bytes.Join([][]byte{key, value}, []byte(":")), bytes.Join([][]byte{key, r}, []byte(":")), 1)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// WriteConfigFile writes the configuration encoded with MarshalConfig to path atomically, so a running soratun process
+// or a power loss never sees a partially written file. If path is a symlink, its target is replaced. Mode and owner of
+// the existing file are kept, and a new file is created with 0600.
+func WriteConfigFile(path string, c *Config) error {
+	b, err := MarshalConfig(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, append(b, '\n'))
+}
+
+// writeFileAtomically replaces the file at path with b through a temporary file in the same directory, keeping mode
+// and owner of the existing file.
+func writeFileAtomically(path string, b []byte) error {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+
+	// temporary file is created with 0600, and renamed in the same directory
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if err := copyFileMode(f, path); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// copyFileMode sets mode and owner of the file at path to f, if the file exists.
+func copyFileMode(f *os.File, path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	tmp, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if tst, ok := tmp.Sys().(*syscall.Stat_t); ok && tst.Uid == st.Uid && tst.Gid == st.Gid {
+		return nil
+	}
+	if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil {
+		return fmt.Errorf("failed to keep owner of %s: %w", path, err)
+	}
+	return nil
+}
+
+// secretValue returns current value of the secret field.
+func (c *Config) secretValue(field string) (string, bool) {
+	switch field {
+	case "privateKey":
+		return c.PrivateKey.String(), true
+	case "profile.authKeyId":
+		if c.Profile != nil {
+			return c.Profile.AuthKeyID, true
+		}
+	case "profile.authKey":
+		if c.Profile != nil {
+			return c.Profile.AuthKey, true
+		}
+	}
+	return "", false
+}
+
+// checkSecretWritable returns an error if field was loaded from a secret reference which can't be updated with a new
+// value. Only "file:" references are written back; environment variables, commands and systemd credentials are
+// managed outside soratun.
+func (c *Config) checkSecretWritable(field string) error {
+	r, ok := c.secretRefs[field]
+	if !ok || strings.HasPrefix(r.ref, SecretRefFile) {
+		return nil
+	}
+	return fmt.Errorf("%s refers to %s which soratun can't update, use a %q reference or a plaintext value instead", field, r.ref, SecretRefFile)
+}
+
+// updateSecretRef writes the current value of field to the file which it was loaded from, so MarshalConfig keeps the
+// reference rather than writing the new value inline. It does nothing if field was not loaded from a reference.
+func (c *Config) updateSecretRef(field string) error {
+	r, ok := c.secretRefs[field]
+	if !ok {
+		return nil
+	}
+	if err := c.checkSecretWritable(field); err != nil {
+		return err
+	}
+
+	v, _ := c.secretValue(field)
+	path := strings.TrimPrefix(r.ref, SecretRefFile)
+	if err := writeFileAtomically(path, []byte(v+"\n")); err != nil {
+		return fmt.Errorf("failed to write %s to %s: %w", field, path, err)
+	}
+	r.value = v
+	c.secretRefs[field] = r
+	return nil
+}
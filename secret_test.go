@@ -0,0 +1,128 @@
+package soratun
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "auth_key"), []byte("secret-file\n"), 0600))
+	t.Setenv("SORATUN_TEST_SECRET", "secret-env")
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"env:SORATUN_TEST_SECRET", "secret-env", false},
+		{"env:SORATUN_TEST_UNSET", "", true},
+		{"file:" + filepath.Join(dir, "auth_key"), "secret-file", false},
+		{"file:" + filepath.Join(dir, "missing"), "", true},
+		{"exec:echo secret-exec", "secret-exec", false},
+		{"exec:false", "", true},
+		{"credential:auth_key", "secret-file", false},
+		{"credential:../auth_key", "", true},
+		{"secret-plain", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := ResolveSecret(tt.ref)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_UnmarshalConfig_secretRefs(t *testing.T) {
+	privateKey := "mPsgZYoFcWX6wpSkH6QgqKuQaoEGdqU6lbNNG0S2ZXs="
+	t.Setenv("SORATUN_TEST_PRIVATE_KEY", privateKey)
+	t.Setenv("SORATUN_TEST_AUTH_KEY", "secret-xxx")
+
+	config, err := UnmarshalConfig([]byte(`{
+  "privateKey": "env:SORATUN_TEST_PRIVATE_KEY",
+  "simId": "8942310022000000000",
+  "profile": {
+    "authKey": "env:SORATUN_TEST_AUTH_KEY",
+    "authKeyId": "keyId-xxx",
+    "endpoint": "https://api.soracom.io"
+  }
+}`), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, privateKey, config.PrivateKey.String())
+	assert.Equal(t, "secret-xxx", config.Profile.AuthKey)
+	assert.Equal(t, "keyId-xxx", config.Profile.AuthKeyID)
+
+	b, err := MarshalConfig(config)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"privateKey": "env:SORATUN_TEST_PRIVATE_KEY"`)
+	assert.Contains(t, string(b), `"authKey": "env:SORATUN_TEST_AUTH_KEY"`)
+	assert.Contains(t, string(b), `"authKeyId": "keyId-xxx"`)
+	assert.NotContains(t, string(b), "secret-xxx")
+
+	// a changed value no longer matches the reference
+	config.Profile.AuthKey = "secret-yyy"
+	b, err = MarshalConfig(config)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"authKey": "secret-yyy"`)
+
+	_, err = UnmarshalConfig([]byte(`{"privateKey": "env:SORATUN_TEST_UNSET"}`), nil)
+	assert.Error(t, err)
+}
+
+func Test_WriteConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "arc.json")
+	link := filepath.Join(dir, "link.json")
+	assert.NoError(t, os.WriteFile(target, []byte("{}\n"), 0644))
+	assert.NoError(t, os.Symlink(target, link))
+
+	assert.NoError(t, WriteConfigFile(link, &Config{SimId: "8942310022000000000"}))
+
+	b, err := os.ReadFile(target)
+	assert.NoError(t, err)
+	config, err := UnmarshalConfig(b, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "8942310022000000000", config.SimId)
+
+	fi, err := os.Lstat(link)
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, fi.Mode()&os.ModeSymlink)
+
+	fi, err = os.Stat(target)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm(), "mode of the existing file should be kept")
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	created := filepath.Join(dir, "new.json")
+	assert.NoError(t, WriteConfigFile(created, &Config{}))
+	fi, err = os.Stat(created)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+}
+
+func Test_WriteConfigFile_owner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owner requires root")
+	}
+	path := filepath.Join(t.TempDir(), "arc.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{}\n"), 0640))
+	assert.NoError(t, os.Chown(path, 1234, 5678))
+
+	assert.NoError(t, WriteConfigFile(path, &Config{}))
+
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	st := fi.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(1234), st.Uid)
+	assert.Equal(t, uint32(5678), st.Gid)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
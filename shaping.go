@@ -0,0 +1,176 @@
+package soratun
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// minBurstBytes is the minimum bucket size, to let at least a couple of full-sized packets through.
+const minBurstBytes = 3000
+
+// ShapingConfig holds rate limits applied inside soratun, without tc or kernel qdisc setup.
+type ShapingConfig struct {
+	// Ingress limits traffic from SORACOM Arc to the device.
+	Ingress *RateLimit `json:"ingress,omitempty"`
+	// Egress limits traffic from the device to SORACOM Arc.
+	Egress *RateLimit `json:"egress,omitempty"`
+}
+
+// RateLimit defines a token bucket.
+type RateLimit struct {
+	// BitsPerSecond is the sustained rate. 0 means unlimited.
+	BitsPerSecond uint64 `json:"bitsPerSecond"`
+	// BurstBytes is the bucket size. If 0, 100 milliseconds worth of the rate will be used.
+	BurstBytes uint64 `json:"burstBytes,omitempty"`
+}
+
+// String returns a human-readable representation of the limit.
+func (l *RateLimit) String() string {
+	if l == nil || l.BitsPerSecond == 0 {
+		return "unlimited"
+	}
+	if l.BurstBytes == 0 {
+		return fmt.Sprintf("%d bit/s", l.BitsPerSecond)
+	}
+	return fmt.Sprintf("%d bit/s (burst %d bytes)", l.BitsPerSecond, l.BurstBytes)
+}
+
+// tokenBucket delays callers to keep the rate. A packet larger than available tokens is sent after the deficit is
+// refilled, so the bucket never drops packets by itself.
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit *RateLimit) *tokenBucket {
+	b := &tokenBucket{}
+	b.set(limit)
+	return b
+}
+
+// set updates the rate and the bucket size. A nil or zero limit means unlimited.
+func (b *tokenBucket) set(limit *RateLimit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limit = RateLimit{}
+	if limit != nil {
+		b.limit = *limit
+	}
+
+	b.rate = float64(b.limit.BitsPerSecond) / 8
+	b.burst = float64(b.limit.BurstBytes)
+	if b.burst == 0 {
+		b.burst = b.rate / 10
+	}
+	if b.burst < minBurstBytes {
+		b.burst = minBurstBytes
+	}
+	if b.tokens > b.burst || b.last.IsZero() {
+		b.tokens = b.burst
+	}
+	b.last = time.Now()
+}
+
+// get returns current limit, or nil if unlimited.
+func (b *tokenBucket) get() *RateLimit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit.BitsPerSecond == 0 {
+		return nil
+	}
+	limit := b.limit
+	return &limit
+}
+
+// wait blocks until n bytes are allowed to be sent.
+func (b *tokenBucket) wait(n int) {
+	b.mu.Lock()
+	if b.rate == 0 {
+		b.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// shapingTun wraps tun.Device and delays packets to keep ingress and egress rates.
+type shapingTun struct {
+	tun.Device
+	ingress *tokenBucket
+	egress  *tokenBucket
+}
+
+func newShapingTun(t tun.Device, config *ShapingConfig) *shapingTun {
+	if config == nil {
+		config = &ShapingConfig{}
+	}
+	return &shapingTun{
+		Device:  t,
+		ingress: newTokenBucket(config.Ingress),
+		egress:  newTokenBucket(config.Egress),
+	}
+}
+
+// Read reads packets from the device, then waits for egress tokens before handing them to WireGuard.
+func (t *shapingTun) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := t.Device.Read(bufs, sizes, offset)
+	total := 0
+	for i := 0; i < n; i++ {
+		total += sizes[i]
+	}
+	t.egress.wait(total)
+	return n, err
+}
+
+// Write waits for ingress tokens, then writes packets to the device.
+func (t *shapingTun) Write(bufs [][]byte, offset int) (int, error) {
+	total := 0
+	for _, buf := range bufs {
+		total += len(buf) - offset
+	}
+	t.ingress.wait(total)
+	return t.Device.Write(bufs, offset)
+}
+
+// config returns current rate limits.
+func (t *shapingTun) config() *ShapingConfig {
+	return &ShapingConfig{
+		Ingress: t.ingress.get(),
+		Egress:  t.egress.get(),
+	}
+}
+
+// update replaces rate limits. A nil limit in config keeps the current limit.
+func (t *shapingTun) update(config *ShapingConfig) {
+	if config.Ingress != nil {
+		t.ingress.set(config.Ingress)
+	}
+	if config.Egress != nil {
+		t.egress.set(config.Egress)
+	}
+}
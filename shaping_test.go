@@ -0,0 +1,136 @@
+package soratun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RateLimit_String(t *testing.T) {
+	assert.Equal(t, "unlimited", (*RateLimit)(nil).String())
+	assert.Equal(t, "unlimited", (&RateLimit{}).String())
+	assert.Equal(t, "1000000 bit/s", (&RateLimit{BitsPerSecond: 1000000}).String())
+	assert.Equal(t, "512000 bit/s (burst 64000 bytes)", (&RateLimit{BitsPerSecond: 512000, BurstBytes: 64000}).String())
+}
+
+func Test_tokenBucket_set(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         *RateLimit
+		expectedRate  float64
+		expectedBurst float64
+	}{
+		{"nil", nil, 0, minBurstBytes},
+		{"zero", &RateLimit{}, 0, minBurstBytes},
+		{"100 milliseconds worth of the rate", &RateLimit{BitsPerSecond: 8000000}, 1000000, 100000},
+		{"minimum burst", &RateLimit{BitsPerSecond: 80000}, 10000, minBurstBytes},
+		{"explicit burst", &RateLimit{BitsPerSecond: 8000000, BurstBytes: 64000}, 1000000, 64000},
+		{"explicit burst below minimum", &RateLimit{BitsPerSecond: 8000000, BurstBytes: 100}, 1000000, minBurstBytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.limit)
+			assert.Equal(t, tt.expectedRate, b.rate)
+			assert.Equal(t, tt.expectedBurst, b.burst)
+			assert.Equal(t, tt.expectedBurst, b.tokens, "a new bucket should be full")
+			if tt.expectedRate == 0 {
+				assert.Nil(t, b.get())
+			} else {
+				assert.Equal(t, tt.limit, b.get())
+			}
+		})
+	}
+}
+
+func Test_tokenBucket_set_keepsTokens(t *testing.T) {
+	b := newTokenBucket(&RateLimit{BitsPerSecond: 8000000})
+	b.tokens = 10
+
+	b.set(&RateLimit{BitsPerSecond: 16000000})
+	assert.Equal(t, float64(10), b.tokens, "raising the limit should not refill the bucket")
+
+	b.tokens = 100000
+	b.set(&RateLimit{BitsPerSecond: 8000000, BurstBytes: 5000})
+	assert.Equal(t, float64(5000), b.tokens, "tokens should be capped by the new burst")
+}
+
+func Test_tokenBucket_wait(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		b := newTokenBucket(nil)
+		start := time.Now()
+		b.wait(1 << 30)
+		assert.Less(t, time.Since(start), 10*time.Millisecond)
+	})
+
+	t.Run("within burst", func(t *testing.T) {
+		b := newTokenBucket(&RateLimit{BitsPerSecond: 80000}) // 10000 bytes/s, 3000 bytes burst
+		start := time.Now()
+		b.wait(3000)
+		assert.Less(t, time.Since(start), 10*time.Millisecond)
+		assert.InDelta(t, 0, b.tokens, 100)
+	})
+
+	t.Run("deficit", func(t *testing.T) {
+		b := newTokenBucket(&RateLimit{BitsPerSecond: 80000}) // 10000 bytes/s, 3000 bytes burst
+		b.wait(3000)
+		start := time.Now()
+		b.wait(500)
+		assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond, "500 bytes should take 50 milliseconds to refill")
+		assert.InDelta(t, -500, b.tokens, 100)
+	})
+
+	t.Run("refill up to burst", func(t *testing.T) {
+		b := newTokenBucket(&RateLimit{BitsPerSecond: 80000})
+		b.tokens = 0
+		b.last = time.Now().Add(-time.Hour)
+		b.wait(0)
+		assert.Equal(t, float64(minBurstBytes), b.tokens)
+	})
+}
+
+func Test_shapingTun(t *testing.T) {
+	ft := &fakeTun{packets: [][]byte{make([]byte, 1000), make([]byte, 500)}}
+	st := newShapingTun(ft, &ShapingConfig{
+		Ingress: &RateLimit{BitsPerSecond: 8000000, BurstBytes: 100000},
+		Egress:  &RateLimit{BitsPerSecond: 4000000, BurstBytes: 50000},
+	})
+
+	const offset = 16
+	bufs := [][]byte{make([]byte, offset+1500), make([]byte, offset+1500)}
+	sizes := make([]int, len(bufs))
+	n, err := st.Read(bufs, sizes, offset)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []int{1000, 500}, sizes)
+	assert.InDelta(t, 50000-1500, st.egress.tokens, 100, "read packets should consume egress tokens")
+	assert.InDelta(t, 100000, st.ingress.tokens, 0)
+
+	n, err = st.Write([][]byte{make([]byte, offset+200), make([]byte, offset+300)}, offset)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, ft.written, 2)
+	assert.InDelta(t, 100000-500, st.ingress.tokens, 100, "written packets should consume ingress tokens")
+
+	assert.Equal(t, &ShapingConfig{
+		Ingress: &RateLimit{BitsPerSecond: 8000000, BurstBytes: 100000},
+		Egress:  &RateLimit{BitsPerSecond: 4000000, BurstBytes: 50000},
+	}, st.config())
+
+	// nil keeps the current limit, and zero removes it
+	st.update(&ShapingConfig{Egress: &RateLimit{}})
+	assert.Equal(t, &ShapingConfig{
+		Ingress: &RateLimit{BitsPerSecond: 8000000, BurstBytes: 100000},
+	}, st.config())
+
+	st.update(&ShapingConfig{Ingress: &RateLimit{BitsPerSecond: 1000000}})
+	assert.Equal(t, &ShapingConfig{
+		Ingress: &RateLimit{BitsPerSecond: 1000000},
+	}, st.config())
+}
+
+func Test_newShapingTun_nilConfig(t *testing.T) {
+	st := newShapingTun(&fakeTun{}, nil)
+	assert.Equal(t, &ShapingConfig{}, st.config())
+}
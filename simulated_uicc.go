@@ -0,0 +1,189 @@
+package soratun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+)
+
+// SimulatedUICC is a UICC which runs MILENAGE with the subscriber key K and OPc, to test SIM authentication without
+// hardware.
+type SimulatedUICC struct {
+	imsi     string
+	milenage *milenage
+
+	mu          sync.Mutex
+	application bool   // whether the USIM application is selected
+	selected    []byte // file ID of the selected EF
+	pending     []byte // response data for GET RESPONSE
+	sqn         []byte // the highest accepted sequence number
+}
+
+// AIDs of applications on SimulatedUICC. ISIM comes first in EF DIR, so that USIM has to be looked up.
+var (
+	simulatedISIMAID = []byte{0xa0, 0x00, 0x00, 0x00, 0x87, 0x10, 0x04, 0xff, 0x49, 0xff, 0x05, 0x89, 0x00, 0x00, 0x00, 0x00}
+	simulatedUSIMAID = []byte{0xa0, 0x00, 0x00, 0x00, 0x87, 0x10, 0x02, 0xff, 0x49, 0xff, 0x05, 0x89, 0x00, 0x00, 0x00, 0x00}
+)
+
+// simulatedDirRecordLen is the record length of EF DIR.
+const simulatedDirRecordLen = 0x20
+
+// NewSimulatedUICC returns a new SimulatedUICC with the IMSI, K and OPc.
+func NewSimulatedUICC(imsi string, k, opc []byte) (*SimulatedUICC, error) {
+	m, err := newMilenage(k, opc)
+	if err != nil {
+		return nil, err
+	}
+	if imsi == "" {
+		return nil, errors.New("IMSI is required")
+	}
+	return &SimulatedUICC{imsi: imsi, milenage: m, sqn: make([]byte, milenageSqnSize)}, nil
+}
+
+// Transmit executes SELECT, READ BINARY, READ RECORD, GET RESPONSE and AUTHENTICATE commands. Like a card on a new
+// connection to a card reader, no application is selected until the USIM is selected by its AID.
+func (u *SimulatedUICC) Transmit(_ context.Context, command []byte) ([]byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(command) < 4 {
+		return statusWord(0x6700), nil
+	}
+	switch command[1] {
+	case 0xa4:
+		return u.selectFile(command), nil
+	case 0xb0:
+		if !bytes.Equal(u.selected, []byte{0x6f, 0x07}) {
+			return statusWord(0x6986), nil
+		}
+		return append(encodeIMSI(u.imsi), statusWord(swSuccess)...), nil
+	case 0xb2:
+		return u.readRecord(command), nil
+	case 0xc0:
+		if u.pending == nil {
+			return statusWord(0x6985), nil
+		}
+		res := append(u.pending, statusWord(swSuccess)...)
+		u.pending = nil
+		return res, nil
+	case 0x88:
+		if !u.application {
+			return statusWord(0x6985), nil
+		}
+		return u.authenticate(command), nil
+	}
+	return statusWord(0x6d00), nil
+}
+
+func (u *SimulatedUICC) selectFile(command []byte) []byte {
+	if len(command) < 7 || int(command[4]) != len(command)-5 {
+		return statusWord(0x6700)
+	}
+	name := command[5:]
+	switch {
+	case command[2] == 0x04 && bytes.Equal(name, simulatedUSIMAID):
+		// SELECT by DF name
+		u.application = true
+		u.selected = nil
+		// a minimal FCP of an ADF
+		u.pending = append([]byte{0x62, byte(4 + len(name)), 0x82, 0x02, 0x78, 0x21, 0x84, byte(len(name))}, name...)
+	case command[2] == 0x08 && bytes.Equal(name, []byte{0x2f, 0x00}):
+		// SELECT by path from MF, EF DIR is a linear fixed EF with two records
+		u.selected = name
+		u.pending = []byte{0x62, 0x07, 0x82, 0x05, 0x42, 0x21, 0x00, simulatedDirRecordLen, 0x02}
+	case command[2] == 0x08 && bytes.Equal(name, []byte{0x7f, 0xff, 0x6f, 0x07}) && u.application:
+		// 7FFF is the selected application, which is not available until it is selected
+		u.selected = name[2:]
+		// a minimal FCP of a transparent EF
+		u.pending = []byte{0x62, 0x03, 0x82, 0x01, 0x21}
+	default:
+		return statusWord(0x6a82)
+	}
+	return []byte{0x61, byte(len(u.pending))}
+}
+
+// readRecord returns a record of EF DIR, which is an application template with the AID and the label.
+func (u *SimulatedUICC) readRecord(command []byte) []byte {
+	if !bytes.Equal(u.selected, []byte{0x2f, 0x00}) {
+		return statusWord(0x6986)
+	}
+	if len(command) != 5 || command[3] != 0x04 {
+		return statusWord(0x6a86)
+	}
+
+	var aid []byte
+	var label string
+	switch command[2] {
+	case 1:
+		aid, label = simulatedISIMAID, "ISIM"
+	case 2:
+		aid, label = simulatedUSIMAID, "USIM"
+	default:
+		return statusWord(0x6a83)
+	}
+	if command[4] != simulatedDirRecordLen {
+		return []byte{0x6c, simulatedDirRecordLen}
+	}
+
+	template := append([]byte{0x4f, byte(len(aid))}, aid...)
+	template = append(template, 0x50, byte(len(label)))
+	template = append(template, label...)
+	record := append([]byte{0x61, byte(len(template))}, template...)
+	record = append(record, bytes.Repeat([]byte{0xff}, simulatedDirRecordLen-len(record))...)
+	return append(record, statusWord(swSuccess)...)
+}
+
+func (u *SimulatedUICC) authenticate(command []byte) []byte {
+	// P2 0x81 is 3G security context
+	if command[3] != 0x81 || len(command) != 5+2+milenageRandSize+milenageAutnSize ||
+		command[5] != milenageRandSize || command[6+milenageRandSize] != milenageAutnSize {
+		return statusWord(0x6a86)
+	}
+	rand := command[6 : 6+milenageRandSize]
+	autn := command[7+milenageRandSize:]
+
+	sqn, result, err := u.milenage.verifyAutn(rand, autn)
+	if err != nil {
+		return statusWord(swAuthMacFailure)
+	}
+
+	if bytes.Compare(sqn, u.sqn) <= 0 {
+		auts, err := u.auts(rand)
+		if err != nil {
+			return statusWord(0x6f00)
+		}
+		u.pending = append([]byte{0xdc, byte(len(auts))}, auts...)
+		return []byte{0x61, byte(len(u.pending))}
+	}
+	u.sqn = sqn
+
+	data := []byte{0xdb}
+	for _, v := range [][]byte{result.res, result.ck, result.ik} {
+		data = append(data, byte(len(v)))
+		data = append(data, v...)
+	}
+	u.pending = data
+	return []byte{0x61, byte(len(u.pending))}
+}
+
+// auts returns AUTS = SQNms xor AK* || MAC-S for resynchronisation.
+func (u *SimulatedUICC) auts(rand []byte) ([]byte, error) {
+	akS, err := u.milenage.f5star(rand)
+	if err != nil {
+		return nil, err
+	}
+	// AMF is zero for resynchronisation
+	_, macS, err := u.milenage.f1(rand, u.sqn, make([]byte, milenageAmfSize))
+	if err != nil {
+		return nil, err
+	}
+
+	auts := make([]byte, milenageSqnSize)
+	xorBytes(auts, u.sqn, akS)
+	return append(auts, macS...), nil
+}
+
+func statusWord(v uint16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
@@ -0,0 +1,232 @@
+package soratun
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VerboseFileEnv is an environment variable which specifies a file to append verbose HTTP dumps to, instead of stderr.
+const VerboseFileEnv = "SORACOM_VERBOSE_FILE"
+
+// redacted replaces credentials in verbose HTTP dumps, which customers may share with support.
+const redacted = "***REDACTED***"
+
+// redactedHeaders are headers which carry credentials.
+var redactedHeaders = []string{"X-Soracom-Api-Key", "X-Soracom-Token", "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactedFields are lowercased JSON field names which carry credentials, at any depth of request and response bodies.
+var redactedFields = map[string]bool{
+	"apikey":                  true,
+	"authkey":                 true,
+	"token":                   true,
+	"password":                true,
+	"privatekey":              true,
+	"arcclientpeerprivatekey": true,
+}
+
+// httpTracer writes redacted dumps of a request and its response, with timings of each phase of the request.
+type httpTracer struct {
+	w io.Writer
+
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	dns          time.Duration
+	connect      time.Duration
+	tls          time.Duration
+	firstByte    time.Duration
+	reused       bool
+}
+
+// openVerboseOutput returns the file specified with SORACOM_VERBOSE_FILE, or stderr, and a function to close it.
+func openVerboseOutput() (io.Writer, func()) {
+	path := os.Getenv(VerboseFileEnv)
+	if path == "" {
+		return os.Stderr, func() {}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s, writing verbose output to stderr: %v\n", path, err)
+		return os.Stderr, func() {}
+	}
+	return f, func() {
+		_ = f.Close()
+	}
+}
+
+func newHTTPTracer(w io.Writer) *httpTracer {
+	return &httpTracer{w: w}
+}
+
+// trace returns req which records timings to the tracer.
+func (t *httpTracer) trace(req *http.Request) *http.Request {
+	t.start = time.Now()
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dns = time.Since(t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			// with multiple addresses, connections are attempted in parallel and the first attempt counts
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.connect = time.Since(t.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tls = time.Since(t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.reused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.firstByte = time.Since(t.start)
+		},
+	}))
+}
+
+// dumpRequest writes req with credentials redacted. The body is read through GetBody, so req is not consumed.
+func (t *httpTracer) dumpRequest(req *http.Request) {
+	r := *req
+	r.Header = redactHeader(req.Header)
+	r.Body = nil
+	head, _ := httputil.DumpRequest(&r, false)
+
+	var body []byte
+	if req.GetBody != nil {
+		if b, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(b)
+		}
+	}
+
+	fmt.Fprintln(t.w, "--- Request dump ---------------------------------")
+	fmt.Fprintf(t.w, "%s%s\n", head, redactBody(body))
+	fmt.Fprintln(t.w, "--- End of request dump --------------------------")
+}
+
+// dumpResponse writes res with credentials redacted, and timings of the request. The body is replaced with a copy,
+// so the caller can still read it.
+func (t *httpTracer) dumpResponse(res *http.Response) {
+	r := *res
+	r.Header = redactHeader(res.Header)
+	r.Body = nil
+	head, _ := httputil.DumpResponse(&r, false)
+
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		body = []byte(fmt.Sprintf("(failed to read body: %v)", err))
+	}
+
+	fmt.Fprintln(t.w, "--- Response dump --------------------------------")
+	fmt.Fprintf(t.w, "%s%s\n", head, redactBody(body))
+	fmt.Fprintln(t.w, "--- End of response dump -------------------------")
+	t.printTimings()
+}
+
+// printTimings writes durations of phases which happened. DNS, connect and TLS are absent for a reused connection.
+func (t *httpTracer) printTimings() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var phases []string
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{{"dns", t.dns}, {"connect", t.connect}, {"tls", t.tls}, {"first byte", t.firstByte}, {"total", time.Since(t.start)}} {
+		if p.d > 0 {
+			phases = append(phases, fmt.Sprintf("%s %s", p.name, p.d.Round(10*time.Microsecond)))
+		}
+	}
+	if t.reused {
+		phases = append(phases, "connection reused")
+	}
+	fmt.Fprintf(t.w, "--- Timings: %s ---\n", strings.Join(phases, ", "))
+}
+
+// redactHeader returns a copy of h with credentials redacted.
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range redactedHeaders {
+		if h.Get(k) != "" {
+			h.Set(k, redacted)
+		}
+	}
+	return h
+}
+
+// redactBody returns JSON body b with credentials redacted. Bodies which are not JSON are returned as they are.
+func redactBody(b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return b
+	}
+
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if redactedFields[strings.ToLower(k)] {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactValue(e)
+		}
+	}
+	return v
+}
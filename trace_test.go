@@ -0,0 +1,69 @@
+package soratun
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_redactBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"auth", `{"authKeyId":"keyId-xxx","authKey":"secret-xxx"}`, `{"authKey":"***REDACTED***","authKeyId":"keyId-xxx"}`},
+		{"nested", `{"simId":"894","arcSessionStatus":{"arcClientPeerPrivateKey":"key"},"profiles":[{"apiKey":"a","token":"t"}]}`, `{"arcSessionStatus":{"arcClientPeerPrivateKey":"***REDACTED***"},"profiles":[{"apiKey":"***REDACTED***","token":"***REDACTED***"}],"simId":"894"}`},
+		{"number", `{"tokenTimeoutSeconds":300}`, `{"tokenTimeoutSeconds":300}`},
+		{"not JSON", `plain text`, `plain text`},
+		{"empty", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, string(redactBody([]byte(tt.body))))
+		})
+	}
+}
+
+func Test_apiTransport_do_verbose(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"simId":"8942310022000000000","arcClientPeerPrivateKey":"private-key-xxx"}`))
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "verbose.log")
+	t.Setenv(VerboseFileEnv, path)
+
+	tr, err := newAPITransport(nil, nil)
+	assert.NoError(t, err)
+	res, err := tr.do(context.Background(), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/auth", strings.NewReader(`{"authKeyId":"keyId-xxx","authKey":"secret-xxx"}`))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Soracom-Token", "token-xxx")
+		return req, nil
+	}, true, true)
+	assert.NoError(t, err)
+
+	// the caller still reads the original body
+	b := make([]byte, 1024)
+	n, _ := res.Body.Read(b)
+	assert.Contains(t, string(b[:n]), "private-key-xxx")
+
+	out, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	for _, secret := range []string{"secret-xxx", "token-xxx", "private-key-xxx"} {
+		assert.NotContains(t, string(out), secret)
+	}
+	assert.Contains(t, string(out), "keyId-xxx")
+	assert.Contains(t, string(out), "X-Soracom-Token: "+redacted)
+	assert.Contains(t, string(out), "--- Timings: ")
+}
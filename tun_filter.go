@@ -0,0 +1,58 @@
+package soratun
+
+import (
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// filterTun wraps tun.Device and silently drops packets which the filter functions reject. outbound filters packets
+// read from the device (to SORACOM Arc), inbound filters packets written to the device (from SORACOM Arc). A nil
+// function allows all packets.
+type filterTun struct {
+	tun.Device
+	outbound func(packet []byte) bool
+	inbound  func(packet []byte) bool
+}
+
+// Read reads packets from the device, then drops rejected packets by compacting bufs.
+func (t *filterTun) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := t.Device.Read(bufs, sizes, offset)
+	if t.outbound == nil {
+		return n, err
+	}
+
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if !t.outbound(bufs[i][offset : offset+sizes[i]]) {
+			continue
+		}
+		// buffers are owned by the caller with their indices, so copy the content instead of swapping slices
+		if allowed != i {
+			copy(bufs[allowed][offset:], bufs[i][offset:offset+sizes[i]])
+			sizes[allowed] = sizes[i]
+		}
+		allowed++
+	}
+	return allowed, err
+}
+
+// Write writes allowed packets to the device.
+func (t *filterTun) Write(bufs [][]byte, offset int) (int, error) {
+	if t.inbound == nil {
+		return t.Device.Write(bufs, offset)
+	}
+
+	allowed := make([][]byte, 0, len(bufs))
+	for _, buf := range bufs {
+		if t.inbound(buf[offset:]) {
+			allowed = append(allowed, buf)
+		}
+	}
+	if len(allowed) == 0 {
+		return len(bufs), nil
+	}
+
+	if _, err := t.Device.Write(allowed, offset); err != nil {
+		return 0, err
+	}
+	return len(bufs), nil
+}
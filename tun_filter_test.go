@@ -0,0 +1,99 @@
+package soratun
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// fakeTun returns packets to read, and records written packets.
+type fakeTun struct {
+	tun.Device
+	packets [][]byte
+	err     error
+	written [][]byte
+}
+
+func (t *fakeTun) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n := 0
+	for ; n < len(t.packets) && n < len(bufs); n++ {
+		sizes[n] = copy(bufs[n][offset:], t.packets[n])
+	}
+	return n, t.err
+}
+
+func (t *fakeTun) Write(bufs [][]byte, offset int) (int, error) {
+	for _, buf := range bufs {
+		t.written = append(t.written, append([]byte{}, buf[offset:]...))
+	}
+	return len(bufs), t.err
+}
+
+func Test_filterTun_Read(t *testing.T) {
+	dropOdd := func(packet []byte) bool { return packet[0]%2 == 0 }
+	readErr := errors.New("read error")
+
+	tests := []struct {
+		name     string
+		packets  [][]byte
+		outbound func([]byte) bool
+		err      error
+		expected [][]byte
+	}{
+		{"no filter", [][]byte{{1}, {2, 2}}, nil, nil, [][]byte{{1}, {2, 2}}},
+		{"drop and compact", [][]byte{{1}, {2, 2}, {3, 3, 3}, {4, 4, 4, 4}}, dropOdd, nil, [][]byte{{2, 2}, {4, 4, 4, 4}}},
+		{"keep leading packets in place", [][]byte{{2}, {4, 4}, {5}}, dropOdd, nil, [][]byte{{2}, {4, 4}}},
+		{"drop all", [][]byte{{1}, {3}}, dropOdd, nil, [][]byte{}},
+		{"error with packets", [][]byte{{1}, {2}}, dropOdd, readErr, [][]byte{{2}}},
+	}
+
+	const offset = 16
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := &filterTun{Device: &fakeTun{packets: tt.packets, err: tt.err}, outbound: tt.outbound}
+			bufs := make([][]byte, 4)
+			for i := range bufs {
+				bufs[i] = make([]byte, offset+8)
+			}
+			sizes := make([]int, len(bufs))
+
+			n, err := ft.Read(bufs, sizes, offset)
+			assert.Equal(t, tt.err, err)
+			got := [][]byte{}
+			for i := 0; i < n; i++ {
+				got = append(got, bufs[i][offset:offset+sizes[i]])
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func Test_filterTun_Write(t *testing.T) {
+	dropOdd := func(packet []byte) bool { return packet[0]%2 == 0 }
+
+	tests := []struct {
+		name     string
+		bufs     [][]byte
+		inbound  func([]byte) bool
+		expected [][]byte
+	}{
+		{"no filter", [][]byte{{0, 1}, {0, 2}}, nil, [][]byte{{1}, {2}}},
+		{"drop", [][]byte{{0, 1}, {0, 2}, {0, 4}}, dropOdd, [][]byte{{2}, {4}}},
+		{"drop all", [][]byte{{0, 1}}, dropOdd, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := &fakeTun{}
+			ft := &filterTun{Device: device, inbound: tt.inbound}
+
+			// dropped packets are reported as written, so wireguard-go doesn't treat them as errors
+			n, err := ft.Write(tt.bufs, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, len(tt.bufs), n)
+			assert.Equal(t, tt.expected, device.written)
+		})
+	}
+}
@@ -5,6 +5,8 @@ package soratun
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -13,6 +15,7 @@ import (
 	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -46,14 +49,35 @@ const (
 	DefaultMTU = device.DefaultMTU
 )
 
+// TunnelInfo describes a running soratun process, which is returned from "info" control command.
+type TunnelInfo struct {
+	// Interface is the actual interface name.
+	Interface string `json:"interface"`
+	// SimId is virtual SIM's SimId for the connection.
+	SimId string `json:"simId"`
+	// ArcClientPeerIpAddress is an IP address for this client.
+	ArcClientPeerIpAddress net.IP `json:"arcClientPeerIpAddress,omitempty"`
+	// ConfigPath is the configuration file, or empty if the configuration was read from stdin.
+	ConfigPath string `json:"configPath,omitempty"`
+	// Pid is the process ID.
+	Pid int `json:"pid"`
+	// StartedAt is the time when the process started to bring up the interface.
+	StartedAt time.Time `json:"startedAt"`
+	// Mtu is current MTU of the interface.
+	Mtu int `json:"mtu"`
+}
+
 // Up ups new SORACOM Arc tunnel with given ArcSession.
 func Up(ctx context.Context, config *Config) {
 	iname := config.Interface
+	startedAt := time.Now()
 
 	logger := device.NewLogger(
 		config.LogLevel,
 		fmt.Sprintf("(%s) ", iname),
 	)
+	logs := newLogRing(logRingSize)
+	logs.wrap(logger)
 
 	if isWatchdogEnabled() {
 		logger.Verbosef("systemd watchdog is available. Will update watchdog timer every %s seconds", watchdogTimeout)
@@ -63,8 +87,53 @@ func Up(ctx context.Context, config *Config) {
 		}
 	}
 
+	if config.DataUsage != nil {
+		if err := config.DataUsage.Validate(); err != nil {
+			logger.Errorf("invalid data usage configuration: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var filter *acl
+	if config.Acl != nil {
+		var err error
+		filter, err = compileAcl(config.Acl)
+		if err != nil {
+			logger.Errorf("invalid acl configuration: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	// on macOS "utun" lets the kernel select the interface name, so lock the actual name after the device is created.
+	// Only another process managing the interface is fatal, and like the control socket, the tunnel works without the
+	// lock, e.g. if /var/run/soratun is not writable.
+	var lock *interfaceLock
+	lockAfterCreate := runtime.GOOS == "darwin" && iname == "utun"
+	if !lockAfterCreate {
+		var err error
+		if lock, err = lockInterface(iname); errors.Is(err, errInterfaceLocked) {
+			logger.Errorf("failed to lock interface: %v", err)
+			os.Exit(1)
+		} else if err != nil {
+			logger.Errorf("failed to lock interface, continuing without it: %v", err)
+		}
+	}
+
+	mtu := int(config.Mtu)
+	if config.Mtu == MTUAuto {
+		endpoint := config.ArcSession.ArcServerEndpoint.IP
+		m, err := DiscoverTunnelMTU(endpoint)
+		if err != nil {
+			logger.Errorf("failed to discover path MTU to %s, falling back to %d: %v", endpoint, DefaultMTU, err)
+			mtu = DefaultMTU
+		} else {
+			logger.Verbosef("discovered MTU %d for the path to %s", m, endpoint)
+			mtu = m
+		}
+	}
+
 	// specified interface name and actual interface name may vary
-	t, err := tun.CreateTUN(iname, config.Mtu)
+	t, err := tun.CreateTUN(iname, mtu)
 	if err != nil {
 		logger.Errorf("failed to create new tunnel: %v", err)
 		os.Exit(1)
@@ -78,9 +147,45 @@ func Up(ctx context.Context, config *Config) {
 			config.LogLevel,
 			fmt.Sprintf("(%s) ", iname),
 		)
+		logs.wrap(logger)
 	}
 
-	d := device.NewDevice(t, conn.NewDefaultBind(), logger)
+	if lockAfterCreate {
+		if lock, err = lockInterface(iname); errors.Is(err, errInterfaceLocked) {
+			logger.Errorf("failed to lock interface: %v", err)
+			t.Close()
+			os.Exit(1)
+		} else if err != nil {
+			logger.Errorf("failed to lock interface, continuing without it: %v", err)
+		}
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			logger.Errorf("failed to unlock interface: %v", err)
+		}
+	}()
+
+	var wrapped tun.Device = t
+
+	var mt *mssTun
+	if config.MssClamping {
+		mt = newMssTun(wrapped, mtu)
+		wrapped = mt
+	}
+
+	if filter != nil {
+		wrapped = filter.tun(wrapped)
+	}
+
+	var meter *dataUsageMeter
+	if config.DataUsage != nil {
+		meter = newDataUsageMeter(config, iname, logger)
+		wrapped = meter.tun(wrapped)
+	}
+
+	st := newShapingTun(wrapped, config.Shaping)
+	ct := newCaptureTun(st, iname)
+	d := device.NewDevice(ct, conn.NewDefaultBind(), logger)
 
 	logger.Verbosef("device started")
 
@@ -117,6 +222,89 @@ func Up(ctx context.Context, config *Config) {
 
 	logger.Verbosef("UAPI listener started")
 
+	// the tunnel works without the control socket, only commands like "soratun status" and "soratun capture" don't
+	ctrl, err := listenControl(iname, logger)
+	if err != nil {
+		logger.Errorf("failed to listen on control socket, continuing without it: %v", err)
+	}
+	defer func() {
+		if err := ctrl.Close(); err != nil {
+			logger.Errorf("failed to close control listener: %v", err)
+		}
+	}()
+
+	shutdown := make(chan struct{}, 1)
+	ctrl.handle("shutdown", func(_ json.RawMessage) (interface{}, error) {
+		logger.Verbosef("shutdown requested")
+		select {
+		case shutdown <- struct{}{}:
+		default:
+		}
+		return nil, nil
+	})
+
+	ctrl.handleQuiet("info", func(_ json.RawMessage) (interface{}, error) {
+		current, err := t.MTU()
+		if err != nil {
+			current = mtu
+		}
+		return &TunnelInfo{
+			Interface:              iname,
+			SimId:                  config.SimId,
+			ArcClientPeerIpAddress: config.ArcSession.ArcClientPeerIpAddress,
+			ConfigPath:             config.ConfigPath,
+			Pid:                    os.Getpid(),
+			StartedAt:              startedAt,
+			Mtu:                    current,
+		}, nil
+	})
+
+	ctrl.handleQuiet("logs", func(args json.RawMessage) (interface{}, error) {
+		var logsArgs LogsArgs
+		if len(args) > 0 {
+			if err := json.Unmarshal(args, &logsArgs); err != nil {
+				return nil, err
+			}
+		}
+		return logs.recent(logsArgs.Count), nil
+	})
+
+	ctrl.handle("capture-start", func(args json.RawMessage) (interface{}, error) {
+		var options CaptureOptions
+		if err := json.Unmarshal(args, &options); err != nil {
+			return nil, err
+		}
+		logger.Verbosef("start capturing packets to %s", options.Name)
+		return nil, ct.startCapture(options)
+	})
+	ctrl.handle("capture-stop", func(_ json.RawMessage) (interface{}, error) {
+		logger.Verbosef("stop capturing packets")
+		return ct.stopCapture()
+	})
+
+	ctrl.handle("shaping-get", func(_ json.RawMessage) (interface{}, error) {
+		return st.config(), nil
+	})
+	ctrl.handle("shaping-set", func(args json.RawMessage) (interface{}, error) {
+		var shaping ShapingConfig
+		if err := json.Unmarshal(args, &shaping); err != nil {
+			return nil, err
+		}
+		st.update(&shaping)
+		current := st.config()
+		logger.Verbosef("rate limits updated: ingress %s, egress %s", current.Ingress, current.Egress)
+		return current, nil
+	})
+
+	ctrl.handle("acl-stats", func(_ json.RawMessage) (interface{}, error) {
+		if filter == nil {
+			return nil, errors.New("acl is not configured")
+		}
+		return filter.stats(), nil
+	})
+
+	logger.Verbosef("control listener started")
+
 	client, err := wgctrl.New()
 	if err != nil {
 		logger.Errorf("failed to open wgctrl: %v", err)
@@ -130,28 +318,50 @@ func Up(ctx context.Context, config *Config) {
 		}
 	}()
 
-	var allowedIPs []net.IPNet
-	for _, v := range config.ArcSession.ArcAllowedIPs {
-		allowedIPs = append(allowedIPs, (net.IPNet)(*v))
-	}
+	// a renewed session or a rotated key is applied to the running device by `soratun session renew --apply` or
+	// `soratun rotate-key --apply`. Address and routes of the interface are not updated, so a session which changes them
+	// requires restarting, while allowed IPs which are already routed, including additional ones, are kept. Replacing the
+	// peer keeps the interface, and the tunnel resumes after a new handshake.
+	var sessionMu sync.Mutex
+	currentSession, currentKey := config.ArcSession, config.PrivateKey
+	applySession := func(update *ArcSessionUpdate) error {
+		if update.ArcSession == nil || update.ArcSession.ArcServerEndpoint == nil {
+			return errors.New("no server endpoint in the session")
+		}
 
-	err = client.ConfigureDevice(iname, wgtypes.Config{
-		PrivateKey:   config.PrivateKey.AsWgKey(),
-		FirewallMark: nil,
-		ReplacePeers: true,
-		Peers: []wgtypes.PeerConfig{
-			{
-				PublicKey: *config.ArcSession.ArcServerPeerPublicKey.AsWgKey(),
-				Endpoint: &net.UDPAddr{
-					IP:   config.ArcSession.ArcServerEndpoint.IP,
-					Port: config.ArcSession.ArcServerEndpoint.Port,
-				},
-				PersistentKeepaliveInterval: duration(time.Duration(config.PersistentKeepalive) * time.Second),
-				ReplaceAllowedIPs:           true,
-				AllowedIPs:                  allowedIPs,
-			},
-		},
+		sessionMu.Lock()
+		defer sessionMu.Unlock()
+		if !update.ArcSession.ArcClientPeerIpAddress.Equal(currentSession.ArcClientPeerIpAddress) || !containsIPNets(currentSession.ArcAllowedIPs, update.ArcSession.ArcAllowedIPs) {
+			return errors.New("client IP address or allowed IPs are changed, restart soratun to apply the session")
+		}
+
+		session := *update.ArcSession
+		session.ArcAllowedIPs = currentSession.ArcAllowedIPs
+		c := *config
+		c.ArcSession = &session
+		c.PrivateKey = currentKey
+		if update.PrivateKey != nil {
+			c.PrivateKey = *update.PrivateKey
+		}
+		if err := client.ConfigureDevice(iname, wireGuardConfig(&c)); err != nil {
+			return fmt.Errorf("failed to configure device: %w", err)
+		}
+		currentSession, currentKey = &session, c.PrivateKey
+		if update.PrivateKey != nil {
+			logger.Verbosef("private key rotated, new public key %s", c.PrivateKey.AsWgKey().PublicKey())
+		}
+		logger.Verbosef("arc session updated: server endpoint %s:%d", session.ArcServerEndpoint.IP, session.ArcServerEndpoint.Port)
+		return nil
+	}
+	ctrl.handle("arc-session-set", func(args json.RawMessage) (interface{}, error) {
+		var update ArcSessionUpdate
+		if err := json.Unmarshal(args, &update); err != nil {
+			return nil, err
+		}
+		return nil, applySession(&update)
 	})
+
+	err = client.ConfigureDevice(iname, wireGuardConfig(config))
 	if err != nil {
 		logger.Errorf("failed to configure new device %s: %v", iname, err)
 		d.Close()
@@ -227,6 +437,100 @@ func Up(ctx context.Context, config *Config) {
 						logger.Verbosef("soratun_latest_handshake_epoch{simId=\"%s\",interface=\"%s\",endpoint=\"%s:%d\"} %d", config.SimId, d.Name, p.Endpoint.IP, p.Endpoint.Port, p.LastHandshakeTime.Unix())
 					}
 				}
+				if filter != nil {
+					stats := filter.stats()
+					for direction, hits := range map[string]map[string]uint64{"inbound": stats.Inbound, "outbound": stats.Outbound} {
+						for rule, n := range hits {
+							logger.Verbosef("soratun_acl_hits_total{simId=\"%s\",interface=\"%s\",direction=\"%s\",rule=\"%s\"} %d", config.SimId, iname, direction, rule, n)
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	if config.Mtu == MTUAuto {
+		go func() {
+			ticker := time.NewTicker(pmtuProbeInterval)
+			defer ticker.Stop()
+
+			endpoint := config.ArcSession.ArcServerEndpoint.IP
+			for {
+				<-ticker.C
+				m, err := DiscoverTunnelMTU(endpoint)
+				if err != nil {
+					logger.Verbosef("failed to discover path MTU to %s: %v", endpoint, err)
+					continue
+				}
+				current, err := t.MTU()
+				if err == nil && current == m {
+					continue
+				}
+				if err := setInterfaceMTU(iname, m); err != nil {
+					logger.Errorf("failed to update MTU to %d: %v", m, err)
+					continue
+				}
+				if mt != nil {
+					mt.setMTU(m)
+				}
+				logger.Verbosef("MTU changed from %d to %d", current, m)
+			}
+		}()
+	}
+
+	if config.KeyRotationIntervalHours > 0 {
+		if config.Profile == nil || config.ConfigPath == "" {
+			logger.Errorf("key rotation is disabled, since it requires profile and the configuration file to save keys")
+		} else if err := CheckKeyRotation(config); err != nil {
+			logger.Errorf("key rotation is disabled: %v", err)
+		} else {
+			go func() {
+				ticker := time.NewTicker(time.Duration(config.KeyRotationIntervalHours) * time.Hour)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
+						return
+					}
+					update, err := rotateKey(ctx, config)
+					if update == nil {
+						logger.Errorf("failed to rotate key: %v", err)
+						continue
+					}
+					if err != nil {
+						// the server already accepts only the new key, so keep the tunnel working until restart
+						logger.Errorf("failed to save rotated key, restarting soratun will fail to connect: %v", err)
+					}
+					if err := applySession(update); err != nil {
+						logger.Errorf("failed to apply rotated key: %v", err)
+					}
+				}
+			}()
+		}
+	}
+
+	stopMeter := make(chan struct{})
+	meterStopped := make(chan struct{})
+	if meter != nil {
+		// evaluate caps with persisted counters before any traffic
+		meter.sample(client)
+
+		go func() {
+			defer close(meterStopped)
+			ticker := time.NewTicker(dataUsageInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+				case <-stopMeter:
+					return
+				case <-ctx.Done():
+					return
+				}
+				meter.sample(client)
 			}
 		}()
 	}
@@ -241,10 +545,22 @@ func Up(ctx context.Context, config *Config) {
 	case <-errs:
 	case <-d.Wait():
 	case <-ctx.Done():
+	case <-shutdown:
+	}
+
+	if meter != nil {
+		// stop sampling, then flush counters before the device goes away
+		close(stopMeter)
+		<-meterStopped
+		meter.sample(client)
 	}
 
 	d.Close()
 
+	if stats, err := ct.stopCapture(); err == nil {
+		logger.Verbosef("stopped capturing packets: %d packets written to %s", stats.Packets, stats.Files)
+	}
+
 	if len(config.PostDown) > 0 {
 		for i, com := range config.PostDown {
 			if len(com) == 0 || com[0] == "" {
@@ -265,6 +581,49 @@ func Up(ctx context.Context, config *Config) {
 	logger.Verbosef("shutting down")
 }
 
+// wireGuardConfig returns WireGuard device configuration for given SORACOM Arc configuration.
+func wireGuardConfig(config *Config) wgtypes.Config {
+	var allowedIPs []net.IPNet
+	for _, v := range config.ArcSession.ArcAllowedIPs {
+		allowedIPs = append(allowedIPs, (net.IPNet)(*v))
+	}
+
+	return wgtypes.Config{
+		PrivateKey:   config.PrivateKey.AsWgKey(),
+		FirewallMark: nil,
+		ReplacePeers: true,
+		Peers: []wgtypes.PeerConfig{
+			{
+				PublicKey: *config.ArcSession.ArcServerPeerPublicKey.AsWgKey(),
+				Endpoint: &net.UDPAddr{
+					IP:   config.ArcSession.ArcServerEndpoint.IP,
+					Port: config.ArcSession.ArcServerEndpoint.Port,
+				},
+				PersistentKeepaliveInterval: duration(time.Duration(config.PersistentKeepalive) * time.Second),
+				ReplaceAllowedIPs:           true,
+				AllowedIPs:                  allowedIPs,
+			},
+		},
+	}
+}
+
+// containsIPNets returns true if all networks in b are in a.
+func containsIPNets(a, b []*IPNet) bool {
+	for _, n := range b {
+		found := false
+		for _, m := range a {
+			if (*net.IPNet)(m).String() == (*net.IPNet)(n).String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func duration(d time.Duration) *time.Duration { return &d }
 
 func isWatchdogEnabled() bool {
@@ -0,0 +1,377 @@
+package soratun
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UICC is a SIM card which executes APDU commands, e.g. through AT+CSIM command of a modem, or a card reader.
+type UICC interface {
+	// Transmit sends a command APDU, and returns the response APDU, which ends with status words SW1 and SW2.
+	Transmit(ctx context.Context, command []byte) ([]byte, error)
+}
+
+// status words of the response APDU, see ETSI TS 102 221.
+const (
+	swSuccess              = 0x9000
+	swAuthMacFailure       = 0x9862
+	swSecurityNotSatisfied = 0x6982
+)
+
+// uiccCommandTimeout is the timeout of a command to the UICC, if the context does not have a deadline.
+const uiccCommandTimeout = 10 * time.Second
+
+// UICCError is returned if the UICC responds to a command with an error status.
+type UICCError struct {
+	Command string
+	SW      uint16
+}
+
+func (e *UICCError) Error() string {
+	switch e.SW {
+	case swAuthMacFailure:
+		return fmt.Sprintf("%s failed with status %04X: AUTN is not generated for this SIM", e.Command, e.SW)
+	case swSecurityNotSatisfied:
+		return fmt.Sprintf("%s failed with status %04X: security status not satisfied, PIN may be required", e.Command, e.SW)
+	}
+	return fmt.Sprintf("%s failed with status %04X", e.Command, e.SW)
+}
+
+// ErrUICCSyncFailure is returned if the sequence number in AUTN is not fresh for the UICC.
+var ErrUICCSyncFailure = errors.New("synchronization failure of sequence number")
+
+// transmitAPDU sends a command to the UICC, and returns the response data after retrieving the rest of it with GET
+// RESPONSE, or an error if the status is not success.
+func transmitAPDU(ctx context.Context, u UICC, name string, command []byte) ([]byte, error) {
+	res, err := u.Transmit(ctx, command)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var data []byte
+	for {
+		if len(res) < 2 {
+			return nil, fmt.Errorf("%s: invalid response %X", name, res)
+		}
+		sw1, sw2 := res[len(res)-2], res[len(res)-1]
+		data = append(data, res[:len(res)-2]...)
+
+		switch sw1 {
+		case 0x61:
+			// more data is available with GET RESPONSE
+			res, err = u.Transmit(ctx, []byte{command[0], 0xc0, 0x00, 0x00, sw2})
+		case 0x6c:
+			// wrong Le, resend with the exact length
+			res, err = u.Transmit(ctx, withLe(command, sw2))
+		default:
+			if sw := uint16(sw1)<<8 | uint16(sw2); sw != swSuccess {
+				return nil, &UICCError{Command: name, SW: sw}
+			}
+			return data, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+// usimAIDPrefix is RID and application code of USIM, which AID of the USIM application starts with, see ETSI TS 101 220.
+var usimAIDPrefix = []byte{0xa0, 0x00, 0x00, 0x00, 0x87, 0x10, 0x02}
+
+// selectUSIM finds the USIM application in EF DIR, and selects it. A modem usually has selected it already, but no
+// application is selected on a new connection to a card reader, and the UICC rejects commands to the USIM.
+func selectUSIM(ctx context.Context, u UICC) error {
+	// SELECT by path from MF, 2F00 is EF DIR
+	fcp, err := transmitAPDU(ctx, u, "SELECT EF DIR", []byte{0x00, 0xa4, 0x08, 0x04, 0x02, 0x2f, 0x00})
+	if err != nil {
+		return err
+	}
+	// file descriptor of a linear fixed EF has the record length and the number of records
+	descriptor := findTLV(findTLV(fcp, 0x62), 0x82)
+	if len(descriptor) < 5 {
+		return fmt.Errorf("SELECT EF DIR: unexpected FCP %X", fcp)
+	}
+	recordLen, records := descriptor[3], int(descriptor[4])
+
+	for i := 1; i <= records; i++ {
+		// READ RECORD of the absolute record number
+		record, err := transmitAPDU(ctx, u, "READ RECORD EF DIR", []byte{0x00, 0xb2, byte(i), 0x04, recordLen})
+		if err != nil {
+			return err
+		}
+		aid := findTLV(findTLV(record, 0x61), 0x4f)
+		if bytes.HasPrefix(aid, usimAIDPrefix) {
+			// SELECT by DF name, which is the AID
+			command := append([]byte{0x00, 0xa4, 0x04, 0x04, byte(len(aid))}, aid...)
+			_, err := transmitAPDU(ctx, u, "SELECT USIM", command)
+			return err
+		}
+	}
+	return errors.New("no USIM application is found in EF DIR")
+}
+
+// findTLV returns the value of the first BER-TLV data object with the tag in b, or nil if not found. Only one-byte tags
+// are supported, which is enough for FCP and EF DIR.
+func findTLV(b []byte, tag byte) []byte {
+	for len(b) >= 2 && b[0] != 0xff && b[0] != 0x00 {
+		t, l, v := b[0], int(b[1]), b[2:]
+		if l == 0x81 && len(v) > 0 {
+			l, v = int(v[0]), v[1:]
+		}
+		if l > len(v) {
+			return nil
+		}
+		if t == tag {
+			return v[:l]
+		}
+		b = v[l:]
+	}
+	return nil
+}
+
+// withLe returns a copy of the short command APDU with Le replaced, or appended if the command has no Le.
+func withLe(command []byte, le byte) []byte {
+	c := append([]byte{}, command...)
+	if hasLe(c) {
+		c[len(c)-1] = le
+	} else {
+		c = append(c, le)
+	}
+	return c
+}
+
+// hasLe returns true if the short command APDU has Le, which is after the header in case 2, and after the data in
+// case 4. Case 1 has no body, and case 3 has only the data.
+func hasLe(command []byte) bool {
+	return len(command) == 5 || len(command) > 5 && len(command) == 6+int(command[4])
+}
+
+// readIMSI reads EF IMSI of the USIM, which has to be selected with selectUSIM.
+func readIMSI(ctx context.Context, u UICC) (string, error) {
+	// SELECT by path from MF, 7FFF is the selected USIM application, and 6F07 is EF IMSI
+	_, err := transmitAPDU(ctx, u, "SELECT EF IMSI", []byte{0x00, 0xa4, 0x08, 0x04, 0x04, 0x7f, 0xff, 0x6f, 0x07})
+	if err != nil {
+		return "", err
+	}
+	data, err := transmitAPDU(ctx, u, "READ BINARY EF IMSI", []byte{0x00, 0xb0, 0x00, 0x00, 0x09})
+	if err != nil {
+		return "", err
+	}
+	return decodeIMSI(data)
+}
+
+// decodeIMSI decodes EF IMSI, which has the length followed by BCD digits, and the first nibble is parity.
+func decodeIMSI(b []byte) (string, error) {
+	if len(b) < 2 || int(b[0]) > len(b)-1 || b[0] == 0 {
+		return "", fmt.Errorf("invalid EF IMSI %X", b)
+	}
+
+	var sb strings.Builder
+	for i, v := range b[1 : 1+int(b[0])] {
+		for j, d := range []byte{v & 0x0f, v >> 4} {
+			if i == 0 && j == 0 {
+				continue
+			}
+			if d == 0x0f {
+				break
+			}
+			if d > 9 {
+				return "", fmt.Errorf("invalid EF IMSI %X", b)
+			}
+			sb.WriteByte('0' + d)
+		}
+	}
+	return sb.String(), nil
+}
+
+// encodeIMSI encodes IMSI into EF IMSI.
+func encodeIMSI(imsi string) []byte {
+	digits := []byte{0x01} // parity nibble of even number of digits
+	if len(imsi)%2 == 1 {
+		digits[0] = 0x09
+	}
+	for _, c := range imsi {
+		digits = append(digits, byte(c-'0'))
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, 0x0f)
+	}
+
+	b := []byte{byte(len(digits) / 2)}
+	for i := 0; i < len(digits); i += 2 {
+		b = append(b, digits[i]|digits[i+1]<<4)
+	}
+	return b
+}
+
+// uiccAuthResult holds the result of AUTHENTICATE command in 3G security context.
+type uiccAuthResult struct {
+	res []byte
+	ck  []byte
+	ik  []byte
+}
+
+// authenticateUICC runs AUTHENTICATE command with the challenge, and returns RES, CK and IK.
+func authenticateUICC(ctx context.Context, u UICC, rand, autn []byte) (*uiccAuthResult, error) {
+	if len(rand) != milenageRandSize || len(autn) != milenageAutnSize {
+		return nil, fmt.Errorf("invalid challenge, RAND should be %d bytes and AUTN should be %d bytes", milenageRandSize, milenageAutnSize)
+	}
+
+	command := []byte{0x00, 0x88, 0x00, 0x81, byte(2 + len(rand) + len(autn)), byte(len(rand))}
+	command = append(command, rand...)
+	command = append(command, byte(len(autn)))
+	command = append(command, autn...)
+	data, err := transmitAPDU(ctx, u, "AUTHENTICATE", command)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > 0 && data[0] == 0xdc {
+		var auts []byte
+		if len(data) > 1 && 2+int(data[1]) <= len(data) {
+			auts = data[2 : 2+int(data[1])]
+		}
+		// AUTS would let Keys API resynchronize the sequence number, but the request for it is not implemented
+		return nil, fmt.Errorf("%w with AUTS %X, resynchronization with SORACOM Krypton is not supported", ErrUICCSyncFailure, auts)
+	}
+	if len(data) == 0 || data[0] != 0xdb {
+		return nil, fmt.Errorf("AUTHENTICATE: unexpected response %X", data)
+	}
+	var fields [][]byte
+	for p := 1; p < len(data) && len(fields) < 3; {
+		l := int(data[p])
+		if p+1+l > len(data) {
+			return nil, fmt.Errorf("AUTHENTICATE: truncated response %X", data)
+		}
+		fields = append(fields, data[p+1:p+1+l])
+		p += 1 + l
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("AUTHENTICATE: truncated response %X", data)
+	}
+	return &uiccAuthResult{res: fields[0], ck: fields[1], ik: fields[2]}, nil
+}
+
+// ATCommandUICC talks to the UICC with AT+CSIM command of a modem, e.g. through a serial port.
+type ATCommandUICC struct {
+	rw io.ReadWriter
+	r  *bufio.Reader
+}
+
+// NewATCommandUICC returns a new ATCommandUICC which sends AT commands to rw. If rw has SetReadDeadline, e.g.
+// *os.File of a serial port, reading responses is aborted with the context.
+func NewATCommandUICC(rw io.ReadWriter) *ATCommandUICC {
+	return &ATCommandUICC{rw: rw, r: bufio.NewReader(rw)}
+}
+
+// Transmit sends the command with AT+CSIM, and returns the response.
+func (u *ATCommandUICC) Transmit(ctx context.Context, command []byte) ([]byte, error) {
+	if d, ok := u.rw.(interface{ SetReadDeadline(time.Time) error }); ok {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(uiccCommandTimeout)
+		}
+		if err := d.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := fmt.Fprintf(u.rw, "%s\r", csimCommand(command)); err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line, err := u.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response of AT+CSIM: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		output.WriteString(line + "\n")
+		switch {
+		case line == "OK":
+			return parseCSIMResponse(output.String())
+		case line == "ERROR" || strings.HasPrefix(line, "+CME ERROR"):
+			return nil, fmt.Errorf("modem returned %s for AT+CSIM", line)
+		}
+	}
+}
+
+// parity modes of SerialConfig.
+const (
+	ParityNone = 0
+	ParityOdd  = 1
+	ParityEven = 2
+)
+
+// SerialConfig holds settings of the serial port of a modem.
+type SerialConfig struct {
+	PortName   string
+	BaudRate   uint
+	DataBits   uint
+	StopBits   uint
+	ParityMode uint
+}
+
+// MMCLIUICC talks to the UICC with AT+CSIM command through ModemManager, which needs to run in debug mode to accept
+// AT commands.
+type MMCLIUICC struct {
+	// Path is the path to mmcli.
+	Path string
+	// Modem is the modem to use, e.g. "0" or "any".
+	Modem string
+}
+
+// Transmit sends the command with "mmcli --command", and returns the response.
+func (u *MMCLIUICC) Transmit(ctx context.Context, command []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, uiccCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, u.Path, "-m", u.Modem, "--command="+csimCommand(command))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error while running %s: %s\n%s", u.Path, err, &stderr)
+	}
+	return parseCSIMResponse(stdout.String())
+}
+
+func csimCommand(command []byte) string {
+	h := strings.ToUpper(hex.EncodeToString(command))
+	return fmt.Sprintf("AT+CSIM=%d,\"%s\"", len(h), h)
+}
+
+// parseCSIMResponse finds '+CSIM: <length>,"<response>"' in the output, and returns the response.
+func parseCSIMResponse(output string) ([]byte, error) {
+	i := strings.Index(output, "+CSIM:")
+	if i < 0 {
+		return nil, fmt.Errorf("no response of AT+CSIM in %q", output)
+	}
+	fields := strings.SplitN(strings.TrimSpace(output[i+len("+CSIM:"):]), ",", 2)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid response of AT+CSIM %q", output)
+	}
+	l, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid response of AT+CSIM %q", output)
+	}
+	// the response is quoted, and mmcli quotes the whole line with '
+	v, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(fields[1]), "\""), "\"")
+	if len(v) != l {
+		return nil, fmt.Errorf("invalid response of AT+CSIM %q", output)
+	}
+	return hex.DecodeString(v)
+}
@@ -0,0 +1,341 @@
+package soratun
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPCSCSocketPath is the socket of pcscd, the PC/SC daemon of pcsc-lite. PCSCLITE_CSOCK_NAME environment
+// variable overrides it, as libpcsclite does.
+const DefaultPCSCSocketPath = "/run/pcscd/pcscd.comm"
+
+// messages of pcscd, see winscard_msg.h of pcsc-lite.
+const (
+	pcscEstablishContext = 0x01
+	pcscReleaseContext   = 0x02
+	pcscConnect          = 0x04
+	pcscDisconnect       = 0x06
+	pcscTransmit         = 0x09
+	pcscVersion          = 0x11
+	pcscGetReadersState  = 0x12
+)
+
+// constants of pcsc-lite, see pcsclite.h and winscard_msg.h.
+const (
+	pcscProtocolVersionMajor = 4
+	pcscProtocolVersionMinor = 4
+	pcscMaxReaderName        = 128
+	pcscMaxReaders           = 16
+	pcscMaxAtrSize           = 33
+	pcscMaxBufferSize        = 264
+	pcscScopeSystem          = 0x0002
+	pcscShareShared          = 0x0002
+	pcscProtocolT0           = 0x0001
+	pcscProtocolT1           = 0x0002
+	pcscLeaveCard            = 0x0000
+	pcscStatePresent         = 0x0004
+	pcscIORequestLength      = 8
+)
+
+// pcscError is an error code returned from pcscd.
+type pcscError uint32
+
+var pcscErrorNames = map[pcscError]string{
+	0x80100003: "invalid handle",
+	0x80100008: "insufficient buffer",
+	0x80100009: "unknown reader",
+	0x8010000B: "sharing violation, the card is used by another process",
+	0x8010000C: "no smart card",
+	0x8010000F: "protocol mismatch",
+	0x8010001D: "no service",
+	0x8010001E: "service stopped",
+	0x8010002E: "no readers available",
+	0x80100066: "unresponsive card",
+	0x80100067: "unpowered card",
+	0x80100069: "removed card",
+}
+
+func (e pcscError) Error() string {
+	if name, ok := pcscErrorNames[e]; ok {
+		return fmt.Sprintf("PC/SC error 0x%08X: %s", uint32(e), name)
+	}
+	return fmt.Sprintf("PC/SC error 0x%08X", uint32(e))
+}
+
+// message structs of pcscd in host byte order, see winscard_msg.h and eventhandler.h of pcsc-lite.
+type pcscHeader struct {
+	Size    uint32
+	Command uint32
+}
+
+type pcscVersionMessage struct {
+	Major int32
+	Minor int32
+	Rv    uint32
+}
+
+type pcscEstablishMessage struct {
+	Scope   uint32
+	Context uint32
+	Rv      uint32
+}
+
+type pcscReleaseMessage struct {
+	Context uint32
+	Rv      uint32
+}
+
+type pcscConnectMessage struct {
+	Context            uint32
+	Reader             [pcscMaxReaderName]byte
+	ShareMode          uint32
+	PreferredProtocols uint32
+	Card               int32
+	ActiveProtocol     uint32
+	Rv                 uint32
+}
+
+type pcscDisconnectMessage struct {
+	Card        int32
+	Disposition uint32
+	Rv          uint32
+}
+
+type pcscTransmitMessage struct {
+	Card            int32
+	SendPciProtocol uint32
+	SendPciLength   uint32
+	SendLength      uint32
+	RecvPciProtocol uint32
+	RecvPciLength   uint32
+	RecvLength      uint32
+	Rv              uint32
+}
+
+type pcscReaderState struct {
+	Name         [pcscMaxReaderName]byte
+	EventCounter uint32
+	State        uint32
+	Sharing      int32
+	Atr          [pcscMaxAtrSize]byte
+	_            [3]byte
+	AtrLength    uint32
+	Protocol     uint32
+}
+
+// PCSCUICC talks to the UICC in a card reader through pcscd, without linking libpcsclite.
+type PCSCUICC struct {
+	// Reader is the name of the card reader.
+	Reader string
+
+	mu       sync.Mutex
+	conn     net.Conn
+	context  uint32
+	card     int32
+	protocol uint32
+}
+
+// OpenPCSCUICC connects to the card in the reader through pcscd. If reader is empty, the first reader with a card is
+// used.
+func OpenPCSCUICC(reader string) (*PCSCUICC, error) {
+	path := os.Getenv("PCSCLITE_CSOCK_NAME")
+	if path == "" {
+		path = DefaultPCSCSocketPath
+	}
+	conn, err := net.DialTimeout("unix", path, uiccCommandTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to pcscd, which is required for a card reader: %w", err)
+	}
+
+	u := &PCSCUICC{conn: conn}
+	if err := u.open(reader); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *PCSCUICC) open(reader string) error {
+	if err := u.conn.SetDeadline(time.Now().Add(uiccCommandTimeout)); err != nil {
+		return err
+	}
+
+	// pcscd accepts only the same protocol version. Messages used here are the same in 4.x, so the version of pcscd is
+	// used if only the minor version is different.
+	version := pcscVersionMessage{Major: pcscProtocolVersionMajor, Minor: pcscProtocolVersionMinor}
+	if err := u.call(pcscVersion, &version, &version); err != nil {
+		return err
+	}
+	if version.Rv != 0 {
+		if version.Major != pcscProtocolVersionMajor {
+			return fmt.Errorf("unsupported protocol version %d.%d of pcscd", version.Major, version.Minor)
+		}
+		version.Rv = 0
+		if err := u.call(pcscVersion, &version, &version); err != nil {
+			return err
+		}
+		if version.Rv != 0 {
+			return pcscError(version.Rv)
+		}
+	}
+
+	establish := pcscEstablishMessage{Scope: pcscScopeSystem}
+	if err := u.call(pcscEstablishContext, &establish, &establish); err != nil {
+		return err
+	}
+	if establish.Rv != 0 {
+		return pcscError(establish.Rv)
+	}
+	u.context = establish.Context
+
+	name, err := u.findReader(reader)
+	if err != nil {
+		return err
+	}
+
+	connect := pcscConnectMessage{
+		Context:            u.context,
+		ShareMode:          pcscShareShared,
+		PreferredProtocols: pcscProtocolT0 | pcscProtocolT1,
+	}
+	copy(connect.Reader[:pcscMaxReaderName-1], name)
+	if err := u.call(pcscConnect, &connect, &connect); err != nil {
+		return err
+	}
+	if connect.Rv != 0 {
+		return fmt.Errorf("failed to connect to the card in %s: %w", name, pcscError(connect.Rv))
+	}
+	u.Reader, u.card, u.protocol = name, connect.Card, connect.ActiveProtocol
+	return nil
+}
+
+// findReader returns the reader, or the first reader with a card if reader is empty.
+func (u *PCSCUICC) findReader(reader string) (string, error) {
+	var states [pcscMaxReaders]pcscReaderState
+	if err := u.call(pcscGetReadersState, nil, &states); err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, s := range states {
+		name := string(bytes.TrimRight(s.Name[:], "\x00"))
+		if name == "" {
+			continue
+		}
+		if (reader == "" || name == reader) && s.State&pcscStatePresent != 0 {
+			return name, nil
+		}
+		names = append(names, name)
+	}
+
+	if reader != "" {
+		for _, name := range names {
+			if name == reader {
+				return "", fmt.Errorf("no card is found in %s", reader)
+			}
+		}
+		return "", fmt.Errorf("card reader %s is not found in [%s]", reader, strings.Join(names, ", "))
+	}
+	if len(names) > 0 {
+		return "", fmt.Errorf("no card is found in card readers [%s]", strings.Join(names, ", "))
+	}
+	return "", errors.New("no card reader is found")
+}
+
+// call sends the request with a header, and reads the response into res. The response has no header, and has the same
+// struct as the request except for pcscGetReadersState.
+func (u *PCSCUICC) call(command uint32, req, res interface{}) error {
+	var b bytes.Buffer
+	size := 0
+	if req != nil {
+		size = binary.Size(req)
+	}
+	_ = binary.Write(&b, binary.NativeEndian, &pcscHeader{Size: uint32(size), Command: command})
+	if req != nil {
+		_ = binary.Write(&b, binary.NativeEndian, req)
+	}
+	if _, err := u.conn.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("failed to send to pcscd: %w", err)
+	}
+	if err := binary.Read(u.conn, binary.NativeEndian, res); err != nil {
+		return fmt.Errorf("failed to receive from pcscd: %w", err)
+	}
+	return nil
+}
+
+// Transmit sends the command to the card, and returns the response. On T=1, Le=00 is appended to a command with data
+// and no Le, since the card returns no data without Le, while a card on T=0 returns 61xx for GET RESPONSE.
+func (u *PCSCUICC) Transmit(ctx context.Context, command []byte) ([]byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.protocol == pcscProtocolT1 && len(command) > 5 && !hasLe(command) {
+		command = withLe(command, 0x00)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(uiccCommandTimeout)
+	}
+	if err := u.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// the command follows the message, and the response follows the message from pcscd
+	transmit := pcscTransmitMessage{
+		Card:            u.card,
+		SendPciProtocol: u.protocol,
+		SendPciLength:   pcscIORequestLength,
+		SendLength:      uint32(len(command)),
+		RecvPciProtocol: u.protocol,
+		RecvPciLength:   pcscIORequestLength,
+		RecvLength:      pcscMaxBufferSize,
+	}
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.NativeEndian, &pcscHeader{Size: uint32(binary.Size(&transmit)), Command: pcscTransmit})
+	_ = binary.Write(&b, binary.NativeEndian, &transmit)
+	b.Write(command)
+	if _, err := u.conn.Write(b.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to send to pcscd: %w", err)
+	}
+
+	if err := binary.Read(u.conn, binary.NativeEndian, &transmit); err != nil {
+		return nil, fmt.Errorf("failed to receive from pcscd: %w", err)
+	}
+	if transmit.Rv != 0 {
+		return nil, pcscError(transmit.Rv)
+	}
+	if transmit.RecvLength > pcscMaxBufferSize {
+		return nil, fmt.Errorf("invalid response length %d from pcscd", transmit.RecvLength)
+	}
+	res := make([]byte, transmit.RecvLength)
+	if _, err := io.ReadFull(u.conn, res); err != nil {
+		return nil, fmt.Errorf("failed to receive from pcscd: %w", err)
+	}
+	return res, nil
+}
+
+// Close disconnects from the card without resetting it, and releases the context.
+func (u *PCSCUICC) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	_ = u.conn.SetDeadline(time.Now().Add(uiccCommandTimeout))
+	disconnect := pcscDisconnectMessage{Card: u.card, Disposition: pcscLeaveCard}
+	_ = u.call(pcscDisconnect, &disconnect, &disconnect)
+	release := pcscReleaseMessage{Context: u.context}
+	_ = u.call(pcscReleaseContext, &release, &release)
+	return u.conn.Close()
+}
@@ -0,0 +1,263 @@
+package soratun
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePCSCD answers pcsc-lite messages with the UICC in the reader, like pcscd of protocol 4.5.
+type fakePCSCD struct {
+	readers  map[string]UICC // nil UICC means no card
+	protocol uint32          // negotiated protocol, T=0 if zero
+
+	mu       sync.Mutex
+	commands []uint32
+	reader   string
+}
+
+func (d *fakePCSCD) serve(t *testing.T, l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go d.serveConn(t, c)
+	}
+}
+
+func (d *fakePCSCD) serveConn(t *testing.T, c net.Conn) {
+	defer c.Close()
+	var card UICC
+	for {
+		var h pcscHeader
+		if err := binary.Read(c, binary.NativeEndian, &h); err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.commands = append(d.commands, h.Command)
+		d.mu.Unlock()
+
+		var res interface{}
+		switch h.Command {
+		case pcscVersion:
+			var m pcscVersionMessage
+			assert.NoError(t, binary.Read(c, binary.NativeEndian, &m))
+			if m.Major != 4 || m.Minor != 5 {
+				m.Rv = 0x8010001E
+			}
+			m.Major, m.Minor = 4, 5
+			res = &m
+		case pcscEstablishContext:
+			var m pcscEstablishMessage
+			assert.NoError(t, binary.Read(c, binary.NativeEndian, &m))
+			assert.EqualValues(t, pcscScopeSystem, m.Scope)
+			m.Context = 0x1234
+			res = &m
+		case pcscGetReadersState:
+			assert.EqualValues(t, 0, h.Size)
+			var states [pcscMaxReaders]pcscReaderState
+			i := 0
+			for _, name := range []string{"Empty Reader 00 00", "SIM Reader 01 00"} {
+				if u, ok := d.readers[name]; ok {
+					copy(states[i].Name[:], name)
+					if u != nil {
+						states[i].State = pcscStatePresent
+					}
+					i++
+				}
+			}
+			res = &states
+		case pcscConnect:
+			var m pcscConnectMessage
+			assert.NoError(t, binary.Read(c, binary.NativeEndian, &m))
+			assert.EqualValues(t, 0x1234, m.Context)
+			d.mu.Lock()
+			d.reader = string(bytes.TrimRight(m.Reader[:], "\x00"))
+			d.mu.Unlock()
+			card = d.readers[d.reader]
+			if card == nil {
+				m.Rv = 0x8010000C
+			}
+			m.Card, m.ActiveProtocol = 0x5678, d.activeProtocol()
+			res = &m
+		case pcscTransmit:
+			var m pcscTransmitMessage
+			assert.NoError(t, binary.Read(c, binary.NativeEndian, &m))
+			assert.EqualValues(t, 0x5678, m.Card)
+			assert.EqualValues(t, d.activeProtocol(), m.SendPciProtocol)
+			command := make([]byte, m.SendLength)
+			_, err := io.ReadFull(c, command)
+			assert.NoError(t, err)
+			r, err := card.Transmit(context.Background(), command)
+			assert.NoError(t, err)
+			m.RecvLength = uint32(len(r))
+			assert.NoError(t, binary.Write(c, binary.NativeEndian, &m))
+			_, err = c.Write(r)
+			assert.NoError(t, err)
+			continue
+		case pcscDisconnect:
+			var m pcscDisconnectMessage
+			assert.NoError(t, binary.Read(c, binary.NativeEndian, &m))
+			assert.EqualValues(t, pcscLeaveCard, m.Disposition)
+			res = &m
+		case pcscReleaseContext:
+			var m pcscReleaseMessage
+			assert.NoError(t, binary.Read(c, binary.NativeEndian, &m))
+			res = &m
+		default:
+			t.Errorf("unexpected command %d", h.Command)
+			return
+		}
+		if h.Command != pcscGetReadersState {
+			assert.EqualValues(t, binary.Size(res), h.Size)
+		}
+		assert.NoError(t, binary.Write(c, binary.NativeEndian, res))
+	}
+}
+
+func (d *fakePCSCD) activeProtocol() uint32 {
+	if d.protocol == 0 {
+		return pcscProtocolT0
+	}
+	return d.protocol
+}
+
+// t1UICC wraps a UICC which answers like on T=0, to answer like on T=1: response data is returned with the status for a
+// command with Le, and only the status is returned for a command without Le.
+type t1UICC struct {
+	UICC
+}
+
+func (u *t1UICC) Transmit(ctx context.Context, command []byte) ([]byte, error) {
+	withData := len(command) > 5
+	le := withData && hasLe(command)
+	if le {
+		// T=0 has no Le after the data
+		command = command[:len(command)-1]
+	}
+	res, err := u.UICC.Transmit(ctx, command)
+	if err != nil || len(res) != 2 || res[0] != 0x61 || !withData {
+		return res, err
+	}
+	if !le {
+		return statusWord(swSuccess), nil
+	}
+	return u.UICC.Transmit(ctx, []byte{command[0], 0xc0, 0x00, 0x00, res[1]})
+}
+
+func startFakePCSCD(t *testing.T, d *fakePCSCD) {
+	path := filepath.Join(t.TempDir(), "pcscd.comm")
+	l, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	t.Setenv("PCSCLITE_CSOCK_NAME", path)
+	go d.serve(t, l)
+}
+
+func Test_PCSCUICC(t *testing.T) {
+	// sizes of the structs in pcsc-lite
+	assert.Equal(t, 12, binary.Size(&pcscVersionMessage{}))
+	assert.Equal(t, 152, binary.Size(&pcscConnectMessage{}))
+	assert.Equal(t, 32, binary.Size(&pcscTransmitMessage{}))
+	assert.Equal(t, 184, binary.Size(&pcscReaderState{}))
+
+	card, err := NewSimulatedUICC("440103123456789", mustDecodeHex(t, "465b5ce8b199b49faa5f0a2ee238a6bc"), mustDecodeHex(t, "cd63cb71954a9f4e48a5994e37a02baf"))
+	assert.NoError(t, err)
+	d := &fakePCSCD{readers: map[string]UICC{"Empty Reader 00 00": nil, "SIM Reader 01 00": card}}
+	startFakePCSCD(t, d)
+
+	u, err := OpenPCSCUICC("")
+	assert.NoError(t, err)
+	assert.Equal(t, "SIM Reader 01 00", u.Reader)
+
+	// no application is selected on a new connection
+	_, err = readIMSI(context.Background(), u)
+	var uiccErr *UICCError
+	assert.ErrorAs(t, err, &uiccErr)
+	assert.EqualValues(t, 0x6a82, uiccErr.SW)
+
+	assert.NoError(t, selectUSIM(context.Background(), u))
+	imsi, err := readIMSI(context.Background(), u)
+	assert.NoError(t, err)
+	assert.Equal(t, "440103123456789", imsi)
+
+	assert.NoError(t, u.Close())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Equal(t, "SIM Reader 01 00", d.reader)
+	assert.Equal(t, []uint32{
+		pcscVersion, pcscVersion, // 4.4 is rejected, then 4.5 of pcscd is used
+		pcscEstablishContext,
+		pcscGetReadersState,
+		pcscConnect,
+		pcscTransmit,                                           // SELECT EF IMSI is rejected
+		pcscTransmit, pcscTransmit, pcscTransmit, pcscTransmit, // SELECT EF DIR, GET RESPONSE, READ RECORD of ISIM and USIM
+		pcscTransmit, pcscTransmit, // SELECT USIM, GET RESPONSE
+		pcscTransmit, pcscTransmit, pcscTransmit, // SELECT EF IMSI, GET RESPONSE, READ BINARY
+		pcscDisconnect,
+		pcscReleaseContext,
+	}, d.commands)
+}
+
+func Test_OpenPCSCUICC_noCard(t *testing.T) {
+	startFakePCSCD(t, &fakePCSCD{readers: map[string]UICC{"Empty Reader 00 00": nil}})
+
+	_, err := OpenPCSCUICC("")
+	assert.ErrorContains(t, err, "no card is found in card readers [Empty Reader 00 00]")
+
+	_, err = OpenPCSCUICC("Empty Reader 00 00")
+	assert.ErrorContains(t, err, "no card is found in Empty Reader 00 00")
+
+	_, err = OpenPCSCUICC("Other Reader")
+	assert.ErrorContains(t, err, "card reader Other Reader is not found")
+
+	startFakePCSCD(t, &fakePCSCD{readers: map[string]UICC{}})
+	_, err = OpenPCSCUICC("")
+	assert.ErrorContains(t, err, "no card reader is found")
+
+	t.Setenv("PCSCLITE_CSOCK_NAME", filepath.Join(t.TempDir(), "missing.comm"))
+	_, err = OpenPCSCUICC("")
+	assert.ErrorContains(t, err, "failed to connect to pcscd")
+}
+
+func Test_PCSCUICC_T1(t *testing.T) {
+	k := mustDecodeHex(t, "465b5ce8b199b49faa5f0a2ee238a6bc")
+	opc := mustDecodeHex(t, "cd63cb71954a9f4e48a5994e37a02baf")
+	card, err := NewSimulatedUICC("440103123456789", k, opc)
+	assert.NoError(t, err)
+	d := &fakePCSCD{readers: map[string]UICC{"SIM Reader 01 00": &t1UICC{card}}, protocol: pcscProtocolT1}
+	startFakePCSCD(t, d)
+
+	u, err := OpenPCSCUICC("")
+	assert.NoError(t, err)
+	defer u.Close()
+
+	// SELECT returns FCP and AUTHENTICATE returns the result only if Le is sent
+	assert.NoError(t, selectUSIM(context.Background(), u))
+	imsi, err := readIMSI(context.Background(), u)
+	assert.NoError(t, err)
+	assert.Equal(t, "440103123456789", imsi)
+
+	m, err := newMilenage(k, opc)
+	assert.NoError(t, err)
+	rand := mustDecodeHex(t, "23553cbe9637a89d218ae64dae47bf35")
+	autn, err := m.generateAutn(rand, mustDecodeHex(t, "000000000021"), mustDecodeHex(t, "8000"))
+	assert.NoError(t, err)
+	result, err := authenticateUICC(context.Background(), u, rand, autn)
+	assert.NoError(t, err)
+	expected, err := m.f2345(rand)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.res, result.res)
+	assert.Equal(t, expected.ck, result.ck)
+	assert.Equal(t, expected.ik, result.ik)
+}
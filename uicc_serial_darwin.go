@@ -0,0 +1,11 @@
+package soratun
+
+import (
+	"errors"
+	"os"
+)
+
+// OpenSerialPort opens the serial port of a modem in raw mode.
+func OpenSerialPort(c *SerialConfig) (*os.File, error) {
+	return nil, errors.New("serial port is not supported on this platform")
+}
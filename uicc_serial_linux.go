@@ -0,0 +1,79 @@
+package soratun
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// baudRates maps supported baud rates to termios speeds.
+var baudRates = map[uint]uint32{
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+// dataBitsFlags maps supported data bits to termios flags.
+var dataBitsFlags = map[uint]uint32{5: unix.CS5, 6: unix.CS6, 7: unix.CS7, 8: unix.CS8}
+
+// OpenSerialPort opens the serial port of a modem in raw mode. The port is non-blocking, so that reading responses can
+// be aborted with a deadline.
+func OpenSerialPort(c *SerialConfig) (*os.File, error) {
+	speed, ok := baudRates[c.BaudRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", c.BaudRate)
+	}
+	size, ok := dataBitsFlags[c.DataBits]
+	if !ok {
+		return nil, fmt.Errorf("unsupported data bits %d", c.DataBits)
+	}
+	if c.StopBits != 1 && c.StopBits != 2 {
+		return nil, fmt.Errorf("unsupported stop bits %d", c.StopBits)
+	}
+
+	fd, err := unix.Open(c.PortName, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", c.PortName, err)
+	}
+
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to get attributes of %s: %w", c.PortName, err)
+	}
+
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CBAUD
+	t.Cflag |= size | speed | unix.CREAD | unix.CLOCAL
+	if c.StopBits == 2 {
+		t.Cflag |= unix.CSTOPB
+	}
+	switch c.ParityMode {
+	case ParityNone:
+	case ParityOdd:
+		t.Cflag |= unix.PARENB | unix.PARODD
+	case ParityEven:
+		t.Cflag |= unix.PARENB
+	default:
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("unsupported parity mode %d", c.ParityMode)
+	}
+	t.Ispeed = speed
+	t.Ospeed = speed
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, t); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to set attributes of %s: %w", c.PortName, err)
+	}
+	return os.NewFile(uintptr(fd), c.PortName), nil
+}